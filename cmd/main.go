@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
+	"riley/internal/auth"
 	"riley/internal/config"
 	"riley/internal/handlers"
 	"riley/internal/handlers/middlewares"
+	"riley/internal/mail"
+	"riley/internal/models"
+	"riley/internal/oidc"
+	"riley/internal/passwords"
 	"riley/internal/sql"
+	"riley/internal/storage"
 )
 
 func main() {
@@ -26,17 +34,200 @@ func main() {
 		AddSource: true,
 	}))
 
+	c := config.LoadConfig()
+
+	keyring, err := auth.NewKeyring(c.Token)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	revocations, err := auth.NewRevocationList(sqlDatabase)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// TOTP secrets encrypted with a missing or published key are not secret
+	if c.MFA.EncryptionKey == "" || c.MFA.EncryptionKey == config.TEST_MFA_ENCRYPTION_KEY {
+		log.Fatalln("MFA encryption key must be set to a key of its own")
+	}
+
+	cipher, err := auth.NewSecretCipher(c.MFA.EncryptionKey)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Fail at startup rather than on the first login
+	_, err = passwords.NewHasher(c.Password.Hasher)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	mailer, err := mail.NewSender(c.Mail)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	store, err := storage.New(c.Storage)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	hndl := handlers.Handler{
 		SQLDatabase: sqlDatabase,
-		Config:      config.LoadConfig(),
+		Config:      c,
 		Logger:      logger,
+		Keyring:     keyring,
+		Revocations: revocations,
+		Cipher:      cipher,
+		Mailer:      mailer,
+		Storage:     store,
+	}
+
+	if c.OIDC.Issuer != "" {
+		hndl.OIDC = oidc.NewProvider(c.OIDC, &http.Client{Timeout: 10 * time.Second})
 	}
 
-	http.Handle("GET /list", middlewares.DefaultMiddlewares(hndl.List))
-	http.Handle("POST /upload", middlewares.DefaultMiddlewares(hndl.Upload))
-	http.Handle("POST /download", middlewares.DefaultMiddlewares(hndl.Download))
-	http.Handle("POST /delete", middlewares.DefaultMiddlewares(hndl.Delete))
-	http.Handle("POST /login", middlewares.RateLimiter(hndl.Login))
+	go purgeTrash(&hndl)
+	go syncRevocations(&hndl)
+	go purgeAccountTokens(&hndl)
+	go purgeLogins(&hndl)
+
+	rateLimitStore, err := middlewares.NewRateLimitStore(hndl.Config.RateLimit, sqlDatabase, logger)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	limiter, err := middlewares.NewRateLimiter(hndl.Config.RateLimit, rateLimitStore, logger)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer limiter.Stop()
+
+	mw := middlewares.New(
+		sqlDatabase,
+		limiter,
+		auth.Policy{RequireVerifiedEmail: c.Account.RequireVerifiedEmail},
+		auth.NewAPIKeyAuthenticator(sqlDatabase, logger),
+		auth.NewTokenAuthenticator(keyring, revocations, sqlDatabase),
+	)
+
+	http.Handle("GET /list", mw.Default(config.RATE_LIMIT_POLICY_DEFAULT, hndl.List))
+	http.Handle("POST /upload", mw.Default(config.RATE_LIMIT_POLICY_DEFAULT, hndl.Upload))
+	http.Handle("GET /download/{hash}", mw.Default(config.RATE_LIMIT_POLICY_DOWNLOAD, hndl.Download))
+	http.Handle("POST /delete", mw.Default(config.RATE_LIMIT_POLICY_DEFAULT, hndl.Delete))
+	http.Handle("GET /trash", mw.Default(config.RATE_LIMIT_POLICY_DEFAULT, hndl.Trash))
+	http.Handle("POST /restore", mw.Default(config.RATE_LIMIT_POLICY_DEFAULT, hndl.Restore))
+	http.Handle("POST /texts", mw.Default(config.RATE_LIMIT_POLICY_DEFAULT, hndl.CreateText))
+	http.Handle("GET /texts/{hash}", mw.Default(config.RATE_LIMIT_POLICY_DEFAULT, hndl.GetText))
+	http.Handle("GET /texts/{hash}/raw", mw.Default(config.RATE_LIMIT_POLICY_DEFAULT, hndl.GetRawText))
+	http.Handle("DELETE /texts/{hash}", mw.Default(config.RATE_LIMIT_POLICY_DEFAULT, hndl.DeleteText))
+	http.Handle("POST /login", mw.Public(config.RATE_LIMIT_POLICY_LOGIN, hndl.Login))
+	http.Handle("POST /login/mfa", mw.Public(config.RATE_LIMIT_POLICY_LOGIN, hndl.LoginMFA))
+	http.Handle("POST /login/mfa/enroll", mw.Public(config.RATE_LIMIT_POLICY_LOGIN, hndl.LoginMFAEnroll))
+	http.Handle("GET /login/oidc", mw.Public(config.RATE_LIMIT_POLICY_LOGIN, hndl.OIDCLogin))
+	http.Handle("GET /login/oidc/callback", mw.Public(config.RATE_LIMIT_POLICY_LOGIN, hndl.OIDCCallback))
+	http.Handle("POST /signup", mw.Public(config.RATE_LIMIT_POLICY_SIGNUP, hndl.Signup))
+	http.Handle("GET /.well-known/jwks.json", mw.Public(config.RATE_LIMIT_POLICY_DEFAULT, hndl.JWKS))
+	http.Handle("POST /token/refresh", mw.Public(config.RATE_LIMIT_POLICY_REFRESH, hndl.Refresh))
+	http.Handle("POST /logout", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.Logout))
+	http.Handle("POST /logout/all", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.LogoutAll))
+	http.Handle("GET /sessions", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.ListSessions))
+	http.Handle("DELETE /sessions/{id}", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.DeleteSession))
+	http.Handle("POST /api-keys", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.CreateAPIKey))
+	http.Handle("GET /api-keys", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.ListAPIKeys))
+	http.Handle("DELETE /api-keys/{id}", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.DeleteAPIKey))
+	http.Handle("POST /mfa/totp", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.EnrollTOTP))
+	http.Handle("POST /mfa/totp/confirm", mw.Authenticated(config.RATE_LIMIT_POLICY_LOGIN, hndl.ConfirmTOTP))
+	http.Handle("DELETE /mfa/totp", mw.Authenticated(config.RATE_LIMIT_POLICY_LOGIN, hndl.DisableTOTP))
+	http.Handle("POST /mfa/recovery-codes", mw.Authenticated(config.RATE_LIMIT_POLICY_LOGIN, hndl.RegenerateRecoveryCodes))
+	http.Handle("POST /account/password", mw.Authenticated(config.RATE_LIMIT_POLICY_LOGIN, hndl.ChangePassword))
+	http.Handle("POST /account/email/verification", mw.Authenticated(config.RATE_LIMIT_POLICY_SIGNUP, hndl.ResendVerificationEmail))
+	http.Handle("POST /account/email/verify", mw.Public(config.RATE_LIMIT_POLICY_LOGIN, hndl.VerifyEmail))
+	http.Handle("POST /account/password/reset", mw.Public(config.RATE_LIMIT_POLICY_SIGNUP, hndl.RequestPasswordReset))
+	http.Handle("POST /account/password/reset/confirm", mw.Public(config.RATE_LIMIT_POLICY_LOGIN, hndl.ResetPassword))
+	http.Handle("GET /account/logins", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.ListLogins))
+	http.Handle("GET /admin/logins", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.AdminListLogins))
+	http.Handle("GET /admin/users", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.ListUsers))
+	http.Handle("GET /admin/users/{id}", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.GetUser))
+	http.Handle("POST /admin/users/{id}/suspend", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.SuspendUser))
+	http.Handle("POST /admin/users/{id}/unsuspend", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.UnsuspendUser))
+	http.Handle("POST /admin/users/{id}/password-reset", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.ForcePasswordReset))
+	http.Handle("PUT /admin/users/{id}/role", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.SetUserRole))
+	http.Handle("DELETE /admin/users/{id}", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.DeleteUser))
+	http.Handle("GET /admin/audit", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.ListAuditLog))
+	http.Handle("GET /admin/mfa", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.GetMFAPolicy))
+	http.Handle("PUT /admin/mfa", mw.Authenticated(config.RATE_LIMIT_POLICY_DEFAULT, hndl.SetMFAPolicy))
 
 	log.Fatalln(http.ListenAndServe(":8080", nil))
 }
+
+// purgeTrash permanently removes items whose trash retention window has
+// passed, once at startup and then every hour
+func purgeTrash(h *handlers.Handler) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purged, err := models.PurgeTrash(context.Background(), time.Now().UTC().Add(-h.Config.TrashRetention), h.Storage, h.SQLDatabase)
+		if err != nil {
+			h.Logger.Error("Error purging trash", "error", err.Error())
+		} else if purged > 0 {
+			h.Logger.Info("Purged trash", "items", purged)
+		}
+
+		<-ticker.C
+	}
+}
+
+// syncRevocations reloads the revoked tokens every RevocationSyncInterval,
+// so that logouts on other instances are enforced here too, and deletes
+// the ones that expired
+func syncRevocations(h *handlers.Handler) {
+	ticker := time.NewTicker(h.Config.Session.RevocationSyncInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := models.PurgeRevokedTokens(time.Now().UTC(), h.SQLDatabase)
+		if err != nil {
+			h.Logger.Error("Error purging revoked tokens", "error", err.Error())
+		}
+
+		err = h.Revocations.Sync()
+		if err != nil {
+			h.Logger.Error("Error syncing revoked tokens", "error", err.Error())
+		}
+	}
+}
+
+// purgeAccountTokens deletes the expired email verification and password
+// reset tokens every hour
+func purgeAccountTokens(h *handlers.Handler) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := models.PurgeAccountTokens(time.Now().UTC(), h.SQLDatabase)
+		if err != nil {
+			h.Logger.Error("Error purging account tokens", "error", err.Error())
+		}
+	}
+}
+
+// purgeLogins deletes the login attempts older than LoginEventRetention
+// and the failed login counts that expired, every hour
+func purgeLogins(h *handlers.Handler) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := models.PurgeLoginEvents(time.Now().UTC().Add(-h.Config.LoginEventRetention), h.SQLDatabase)
+		if err != nil {
+			h.Logger.Error("Error purging login events", "error", err.Error())
+		}
+
+		_, err = models.PurgeLoginThrottles(h.Config.Lockout, h.SQLDatabase)
+		if err != nil {
+			h.Logger.Error("Error purging login throttles", "error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package apperror
+
+import (
+	"errors"
+	"net/http"
+)
+
+type Code string
+
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeValidationFailed Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeConflict         Code = "conflict"
+	CodeExpired          Code = "expired"
+	CodeTooLarge         Code = "too_large"
+	CodeQuotaExceeded    Code = "quota_exceeded"
+	CodeRateLimited      Code = "rate_limited"
+	CodeInternal         Code = "internal_error"
+)
+
+var statusCodes = map[Code]int{
+	CodeBadRequest:       http.StatusBadRequest,
+	CodeValidationFailed: http.StatusUnprocessableEntity,
+	CodeUnauthorized:     http.StatusUnauthorized,
+	CodeForbidden:        http.StatusForbidden,
+	CodeNotFound:         http.StatusNotFound,
+	CodeConflict:         http.StatusConflict,
+	CodeExpired:          http.StatusGone,
+	CodeTooLarge:         http.StatusRequestEntityTooLarge,
+	CodeQuotaExceeded:    http.StatusInsufficientStorage,
+	CodeRateLimited:      http.StatusTooManyRequests,
+	CodeInternal:         http.StatusInternalServerError,
+}
+
+// Error is an error with a machine readable code
+//
+// Models return these (usually as package level sentinels) so that
+// handlers can map them to a status code without inspecting messages
+type Error struct {
+	Err     error
+	Details map[string]any
+	// origin is the error this one was copied from, so that copies with
+	// details or a different message still match the sentinel
+	origin  *Error
+	Code    Code
+	Message string
+}
+
+// New creates an error with a code and a message safe to show to clients
+func New(code Code, message string) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// Wrap creates an error with a code and a message, keeping err as the
+// cause for logging and errors.Is
+func Wrap(code Code, message string, err error) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+		Err:     err,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the error e was copied from
+func (e *Error) Is(target error) bool {
+	return e.origin != nil && e.origin == target
+}
+
+func (e *Error) copy() *Error {
+	c := *e
+	if c.origin == nil {
+		c.origin = e
+	}
+
+	return &c
+}
+
+// WithDetails returns a copy of the error carrying extra details for the
+// client, e.g. the fields that failed validation
+func (e *Error) WithDetails(details map[string]any) *Error {
+	c := e.copy()
+	c.Details = details
+
+	return c
+}
+
+// WithMessage returns a copy of the error with a more specific message
+func (e *Error) WithMessage(message string) *Error {
+	c := e.copy()
+	c.Message = message
+
+	return c
+}
+
+// WithCause returns a copy of the error wrapping err
+func (e *Error) WithCause(err error) *Error {
+	c := e.copy()
+	c.Err = err
+
+	return c
+}
+
+// StatusCode returns the HTTP status code for the error code
+func (e *Error) StatusCode() int {
+	status, ok := statusCodes[e.Code]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	return status
+}
+
+// From returns the *Error in err's chain, or an internal error wrapping
+// err if there is none
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	return Wrap(CodeInternal, "Internal server error", err)
+}
@@ -0,0 +1,77 @@
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	sentinel := New(CodeNotFound, "Item not found")
+
+	derived := sentinel.WithDetails(map[string]any{"hashes": []string{"a"}}).WithMessage("Item a not found")
+	if !errors.Is(derived, sentinel) {
+		t.Error("Testing derived error: Wanted errors.Is to match the sentinel")
+	}
+
+	if !errors.Is(fmt.Errorf("wrapped: %w", derived), sentinel) {
+		t.Error("Testing wrapped error: Wanted errors.Is to match the sentinel")
+	}
+
+	if errors.Is(New(CodeNotFound, "Item not found"), sentinel) {
+		t.Error("Testing unrelated error: Wanted errors.Is not to match the sentinel")
+	}
+
+	if derived.StatusCode() != http.StatusNotFound {
+		t.Error("Testing status code: Wanted", http.StatusNotFound, "got", derived.StatusCode())
+	}
+}
+
+func TestWrite(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	err := Write(rr, "req-1", New(CodeValidationFailed, "Invalid input").WithDetails(map[string]any{"email": "is required"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Error("Testing status code: Wanted", http.StatusUnprocessableEntity, "got", rr.Code)
+	}
+
+	response := envelope{}
+
+	err = json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error.Code != CodeValidationFailed || response.Error.RequestID != "req-1" || response.Error.Details["email"] != "is required" {
+		t.Error("Testing body: got", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+
+	err = Write(rr, "", errors.New("connection refused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Error("Testing plain error: Wanted", http.StatusInternalServerError, "got", rr.Code)
+	}
+
+	response = envelope{}
+
+	err = json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error.Message != "Internal server error" {
+		t.Error("Testing plain error: Wanted internal message, got", response.Error.Message)
+	}
+}
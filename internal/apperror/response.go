@@ -0,0 +1,37 @@
+package apperror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type envelope struct {
+	Error body `json:"error"`
+}
+
+type body struct {
+	Details   map[string]any `json:"details,omitempty"`
+	Code      Code           `json:"code"`
+	Message   string         `json:"message"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// Write writes err as a JSON error envelope
+//
+// Errors without a code are reported as internal errors, without leaking
+// their message to the client
+func Write(w http.ResponseWriter, requestID string, err error) error {
+	e := From(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.StatusCode())
+
+	return json.NewEncoder(w).Encode(envelope{
+		Error: body{
+			Details:   e.Details,
+			Code:      e.Code,
+			Message:   e.Message,
+			RequestID: requestID,
+		},
+	})
+}
@@ -1,62 +1,208 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func TokenDefaultExpiryDate() time.Time {
-	return time.Now().UTC().Add(time.Hour * 24)
-}
+var (
+	// ErrTokenRevoked is returned for access tokens that were revoked by a
+	// logout before they expired
+	ErrTokenRevoked = errors.New("token has been revoked")
+
+	// ErrWrongTokenType is returned for tokens used for something they
+	// were not issued for, such as an MFA challenge used as access token
+	ErrWrongTokenType = errors.New("wrong token type")
+)
 
-func CheckToken(tokenString string, secret string) error {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+// tokenTypeMFAChallenge is the typ claim of MFA challenge tokens; access
+// tokens have no typ claim
+const tokenTypeMFAChallenge = "mfa_challenge"
+
+// CheckToken validates an access token and checks that its jti is not in
+// the revocation list
+func CheckToken(tokenString string, keyring *Keyring, revocations *RevocationList) error {
+	claims, err := keyring.Parse(tokenString)
 	if err != nil {
 		return err
 	}
 
-	if !token.Valid {
-		return jwt.ErrSignatureInvalid
+	if _, ok := claims["typ"]; ok {
+		return ErrWrongTokenType
+	}
+
+	tokenID, _ := claims["jti"].(string)
+	if revocations.IsRevoked(tokenID) {
+		return ErrTokenRevoked
 	}
 
 	return nil
 }
 
-func GetUserIDFromToken(tokenString string, secret string) (uint64, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+func GetUserIDFromToken(tokenString string, keyring *Keyring) (uint64, error) {
+	claims, err := keyring.Parse(tokenString)
 	if err != nil {
 		return 0, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
+	userID, ok := claims["sub"].(float64)
 	if !ok {
-		return 0, jwt.ErrInvalidKeyType
+		return 0, jwt.ErrTokenInvalidSubject
+	}
+
+	return uint64(userID), nil
+}
+
+// GenerateToken issues an access token that does not belong to a session
+func GenerateToken(expiryDate time.Time, userID uint64, keyring *Keyring) (string, error) {
+	tokenID, err := NewTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	return generateToken(expiryDate, userID, tokenID, jwt.MapClaims{}, keyring)
+}
+
+// GenerateSessionToken issues an access token for a session, identified
+// by tokenID so that it can be revoked
+func GenerateSessionToken(expiryDate time.Time, userID uint64, sessionID uint64, tokenID string, keyring *Keyring) (string, error) {
+	return generateToken(expiryDate, userID, tokenID, jwt.MapClaims{"sid": sessionID}, keyring)
+}
+
+// GenerateMFAChallengeToken issues the token a user who passed the
+// password check exchanges, together with a second factor, for a session
+func GenerateMFAChallengeToken(expiryDate time.Time, userID uint64, tokenID string, keyring *Keyring) (string, error) {
+	return generateToken(expiryDate, userID, tokenID, jwt.MapClaims{"typ": tokenTypeMFAChallenge}, keyring)
+}
+
+// ParseMFAChallengeToken validates an MFA challenge token and returns the
+// user ID and token ID
+func ParseMFAChallengeToken(tokenString string, keyring *Keyring, revocations *RevocationList) (uint64, string, error) {
+	userID, tokenID, err := parseTypedToken(tokenString, tokenTypeMFAChallenge, keyring)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if revocations.IsRevoked(tokenID) {
+		return 0, "", ErrTokenRevoked
+	}
+
+	return userID, tokenID, nil
+}
+
+// GenerateAccountToken issues a token sent by email, such as an email
+// verification or password reset link, for the purpose
+//
+// The token is only signed; the caller records tokenID so that it can be
+// used once
+func GenerateAccountToken(purpose string, expiryDate time.Time, userID uint64, tokenID string, keyring *Keyring) (string, error) {
+	return generateToken(expiryDate, userID, tokenID, jwt.MapClaims{"typ": purpose}, keyring)
+}
+
+// ParseAccountToken validates a token issued by GenerateAccountToken for
+// the purpose and returns the user ID and token ID
+func ParseAccountToken(purpose string, tokenString string, keyring *Keyring) (uint64, string, error) {
+	return parseTypedToken(tokenString, purpose, keyring)
+}
+
+// parseTypedToken validates a token with the typ claim and returns its
+// user ID and token ID
+func parseTypedToken(tokenString string, typ string, keyring *Keyring) (uint64, string, error) {
+	claims, err := keyring.Parse(tokenString)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if tokenType, _ := claims["typ"].(string); tokenType != typ {
+		return 0, "", ErrWrongTokenType
 	}
 
 	userID, ok := claims["sub"].(float64)
 	if !ok {
-		return 0, jwt.ErrInvalidKeyType
+		return 0, "", jwt.ErrTokenInvalidSubject
 	}
 
-	return uint64(userID), nil
+	tokenID, _ := claims["jti"].(string)
+
+	return uint64(userID), tokenID, nil
+}
+
+func generateToken(expiryDate time.Time, userID uint64, tokenID string, claims jwt.MapClaims, keyring *Keyring) (string, error) {
+	now := time.Now().UTC().Unix()
+
+	claims["exp"] = expiryDate.Unix()
+	claims["iat"] = now
+	claims["nbf"] = now
+	claims["sub"] = userID
+	claims["jti"] = tokenID
+
+	return keyring.Sign(claims)
+}
+
+// NewTokenID returns a random ID for the jti claim of an access token
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// NewRefreshToken returns a random refresh token and the hash it is
+// stored under
+func NewRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the hash a refresh token is stored under
+func HashRefreshToken(token string) string {
+	return hashToken(token)
+}
+
+// NewAPIKey returns a random API key, its display prefix and the hash it
+// is stored under
+func NewAPIKey() (string, string, string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return key, key[:len(APIKeyPrefix)+8], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hash an API key is stored under
+func HashAPIKey(key string) string {
+	return hashToken(key)
 }
 
-func GenerateToken(expiryDate time.Time, userID uint64, secret string) (string, error) {
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS256,
-		jwt.MapClaims{
-			"exp": expiryDate.Unix(),
-			"iat": time.Now().UTC().Unix(),
-			"nbt": time.Now().UTC().Unix(),
-			"sub": userID,
-			"iss": "riley",
-		},
-	)
+// hashToken hashes random tokens for storage
+//
+// The tokens are random, so a fast unsalted hash is enough to keep a
+// database leak from exposing usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
 
-	return token.SignedString([]byte(secret))
+	return hex.EncodeToString(sum[:])
 }
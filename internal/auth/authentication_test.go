@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -13,16 +14,23 @@ import (
 
 func TestCheckToken(t *testing.T) {
 	wrongToken := "0123456789"
-	secret := "secret"
 	expiryDate := time.Now().UTC().Add(time.Hour * 24)
 
-	if err := CheckToken(wrongToken, secret); err == nil {
-		t.Error("Testing check token with wrong token: Wanted err, got nil")
+	db := sql.Connect(config.LoadTestConfig())
+
+	keyring := newTestKeyring(t, "secret")
+	otherKeyring := newTestKeyring(t, "other secret")
+
+	revocations, err := NewRevocationList(db)
+	if err != nil {
+		t.Fatal("Creating revocation list: Wanted nil, got", err)
 	}
 
-	db := sql.Connect(config.LoadTestConfig())
+	if err := CheckToken(wrongToken, keyring, revocations); err == nil {
+		t.Error("Testing check token with wrong token: Wanted err, got nil")
+	}
 
-	user, err := models.UserCreate("exampleTestCheckToken@example.com", "password123%A%", db)
+	user, err := models.UserCreate("exampleTestCheckToken@example.com", "password123%A%", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Error("Testing check token: Wanted nil, got", err)
 	}
@@ -34,29 +42,41 @@ func TestCheckToken(t *testing.T) {
 		}
 	}()
 
-	correctToken, err := GenerateToken(expiryDate, user.ID, secret)
+	correctToken, err := GenerateToken(expiryDate, user.ID, keyring)
 	if err != nil {
 		t.Error("Testing generate token: Wanted nil, got", err)
 	}
 
-	if err := CheckToken(correctToken, secret); err != nil {
+	if err := CheckToken(correctToken, keyring, revocations); err != nil {
 		t.Error("Testing check token with correct token: Wanted nil, got", err)
 	}
 
-	if err := CheckToken(correctToken, "wrong secret"); err == nil {
+	if err := CheckToken(correctToken, otherKeyring, revocations); err == nil {
 		t.Error("Testing check token with wrong secret: Wanted error, got nil")
 	}
 
-	if err := CheckToken("", secret); err == nil {
+	if err := CheckToken("", keyring, revocations); err == nil {
 		t.Error("Testing check token with empty token: Wanted error, got nil")
 	}
 
-	if err := CheckToken(correctToken, ""); err == nil {
-		t.Error("Testing check token with empty secret: Wanted error, got nil")
+	tokenID, err := NewTokenID()
+	if err != nil {
+		t.Fatal("Testing new token ID: Wanted nil, got", err)
 	}
 
-	if err := CheckToken("", ""); err == nil {
-		t.Error("Testing check token with empty token and secret: Wanted error, got nil")
+	revokedToken, err := GenerateSessionToken(expiryDate, user.ID, 1, tokenID, keyring)
+	if err != nil {
+		t.Error("Testing generate session token: Wanted nil, got", err)
+	}
+
+	revocations.Add(models.RevokedToken{ID: tokenID, ExpiresAt: expiryDate})
+
+	if err := CheckToken(revokedToken, keyring, revocations); !errors.Is(err, ErrTokenRevoked) {
+		t.Error("Testing check token with revoked token: Wanted", ErrTokenRevoked, "got", err)
+	}
+
+	if err := CheckToken(correctToken, keyring, revocations); err != nil {
+		t.Error("Testing check token with other token after revocation: Wanted nil, got", err)
 	}
 }
 
@@ -66,7 +86,7 @@ func TestGenerateToken(t *testing.T) {
 
 	db := sql.Connect(config.LoadTestConfig())
 
-	user, err := models.UserCreate("exampleTestGenerateToken@example.com", "password123%A%", db)
+	user, err := models.UserCreate("exampleTestGenerateToken@example.com", "password123%A%", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Error("Testing generate token: Wanted nil, got", err)
 	}
@@ -78,7 +98,7 @@ func TestGenerateToken(t *testing.T) {
 		}
 	}()
 
-	token, err := GenerateToken(expiryDate, user.ID, secret)
+	token, err := GenerateToken(expiryDate, user.ID, newTestKeyring(t, secret))
 	if err != nil {
 		t.Error("Testing generate token: Wanted nil, got", err)
 	}
@@ -102,6 +122,10 @@ func TestGenerateToken(t *testing.T) {
 		t.Error("Testing generate token: Wanted riley got", tokenUnwrapped.Claims.(jwt.MapClaims)["iss"])
 	}
 
+	if tokenUnwrapped.Claims.(jwt.MapClaims)["nbf"] == nil {
+		t.Error("Testing generate token: Wanted nbf claim, got none")
+	}
+
 	tokenExpiryDate := tokenUnwrapped.Claims.(jwt.MapClaims)["exp"].(float64)
 	expiryDateToCompare := float64(expiryDate.Unix())
 
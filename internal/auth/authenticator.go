@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"riley/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenAuthenticator authenticates requests carrying an access token
+// issued by GenerateToken
+type TokenAuthenticator struct {
+	db          *sql.DB
+	keyring     *Keyring
+	revocations *RevocationList
+}
+
+func NewTokenAuthenticator(keyring *Keyring, revocations *RevocationList, db *sql.DB) *TokenAuthenticator {
+	return &TokenAuthenticator{
+		db:          db,
+		keyring:     keyring,
+		revocations: revocations,
+	}
+}
+
+// Authenticate validates the token and loads the user it was issued to,
+// so that revoked tokens and deactivated users are rejected even if the
+// token has not expired
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	tokenString := BearerToken(r)
+	if tokenString == "" {
+		return Principal{}, ErrNoCredentials
+	}
+
+	claims, err := a.keyring.Parse(tokenString)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if _, ok := claims["typ"]; ok {
+		return Principal{}, ErrWrongTokenType
+	}
+
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return Principal{}, jwt.ErrTokenInvalidSubject
+	}
+
+	tokenID, _ := claims["jti"].(string)
+	if a.revocations.IsRevoked(tokenID) {
+		return Principal{}, ErrTokenRevoked
+	}
+
+	user, err := models.GetUserByID(uint64(userID), a.db)
+	if errors.Is(err, models.ErrUserNotFound) {
+		return Principal{}, jwt.ErrTokenInvalidSubject
+	} else if err != nil {
+		return Principal{}, err
+	}
+
+	principal := NewUserPrincipal(user, AUTH_METHOD_TOKEN)
+	principal.TokenID = tokenID
+
+	if sessionID, ok := claims["sid"].(float64); ok {
+		principal.SessionID = uint64(sessionID)
+	}
+
+	return principal, nil
+}
+
+// APIKeyPrefix starts every API key, so that they can be told apart from
+// access tokens and found by secret scanners
+const APIKeyPrefix = "rly_"
+
+// APIKeyAuthenticator authenticates requests carrying an API key
+type APIKeyAuthenticator struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewAPIKeyAuthenticator(db *sql.DB, logger *slog.Logger) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Authenticate looks up the API key and the user it belongs to
+//
+// The principal is limited to the scopes of the key, and only keeps the
+// admin role if the key has the admin scope
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	token := BearerToken(r)
+	if !strings.HasPrefix(token, APIKeyPrefix) {
+		return Principal{}, ErrNoCredentials
+	}
+
+	key, err := models.GetAPIKeyByHash(HashAPIKey(token), a.db)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if key.IsExpired() {
+		return Principal{}, models.ErrAPIKeyExpired
+	}
+
+	user, err := models.GetUserByID(key.UserID, a.db)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	// Recording the use is not worth failing the request over
+	err = key.Touch(a.db)
+	if err != nil {
+		a.logger.Error("Error recording API key use", "error", err.Error())
+	}
+
+	if user.Role == models.USER_ROLE_ADMIN && !slices.Contains(key.Scopes, models.API_KEY_SCOPE_ADMIN) {
+		user.Role = models.USER_ROLE_USER
+	}
+
+	principal := NewUserPrincipal(user, AUTH_METHOD_API_KEY)
+	principal.TokenID = strconv.FormatUint(key.ID, 10)
+	principal.Scopes = key.Scopes
+
+	// A nil slice would mean unrestricted
+	if principal.Scopes == nil {
+		principal.Scopes = []string{}
+	}
+
+	return principal, nil
+}
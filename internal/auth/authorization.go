@@ -1,7 +1,207 @@
 package auth
 
-import "net/url"
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
 
-func IsAuthorized(url *url.URL, userID uint64) bool {
-	return true
+	"riley/internal/models"
+)
+
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionWrite  Action = "write"
+	ActionDelete Action = "delete"
+)
+
+type Reason string
+
+const (
+	// ReasonOwner, ReasonShared, ReasonAdmin and ReasonNoResource allow
+	// the request
+	ReasonOwner      Reason = "owner"
+	ReasonShared     Reason = "shared"
+	ReasonAdmin      Reason = "admin"
+	ReasonNoResource Reason = "no_resource"
+
+	// ReasonNotFound denies the request as if the resource did not exist,
+	// which is also used when the user has no access to it at all so that
+	// hashes cannot be probed
+	ReasonNotFound Reason = "not_found"
+
+	// ReasonForbidden and ReasonReadOnly deny a request for a resource
+	// the user is allowed to know about
+	ReasonForbidden Reason = "forbidden"
+	ReasonReadOnly  Reason = "read_only"
+
+	// ReasonMissingScope denies a request the principal's API key has no
+	// scope for
+	ReasonMissingScope Reason = "missing_scope"
+
+	// ReasonUnverified denies a write request by a user who has not
+	// verified their email, if the policy requires it
+	ReasonUnverified Reason = "unverified"
+)
+
+// Policy holds the instance wide rules IsAuthorized applies on top of
+// roles, scopes and ownership
+type Policy struct {
+	// RequireVerifiedEmail restricts users to reading until they verify
+	// their email
+	RequireVerifiedEmail bool
+}
+
+// Decision is the outcome of an authorization check
+type Decision struct {
+	Reason  Reason
+	Allowed bool
+}
+
+// Resource is a file or a text targeted by a request
+type Resource struct {
+	Kind    string
+	Hash    string
+	OwnerID uint64
+}
+
+// IsAuthorized checks that a principal may perform the request
+//
+// The target resource is taken from the hash path value; requests without
+// one are only checked against the principal's roles and scopes, taking
+// routes under /texts as text routes, the listings /list and /trash as
+// routes for both kinds and every other route as a file route; listings
+// need a scope for one of the kinds and only return those. Write requests by readonly users are always denied, and so are
+// those by unverified users if the policy requires verified emails
+func IsAuthorized(r *http.Request, principal Principal, policy Policy, db *sql.DB) (Decision, error) {
+	action := ActionWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		action = ActionRead
+	case http.MethodDelete:
+		action = ActionDelete
+	}
+
+	if action != ActionRead && policy.RequireVerifiedEmail && !principal.EmailVerified {
+		return Decision{Reason: ReasonUnverified}, nil
+	}
+
+	hash := r.PathValue("hash")
+	if hash == "" {
+		if !slices.ContainsFunc(routeKinds(r.URL.Path), func(kind string) bool {
+			return principal.HasScope(RequiredScope(kind, action))
+		}) {
+			return Decision{Reason: ReasonMissingScope}, nil
+		}
+
+		if action != ActionRead && principal.HasRole(models.USER_ROLE_READONLY) {
+			return Decision{Reason: ReasonReadOnly}, nil
+		}
+
+		return Decision{Allowed: true, Reason: ReasonNoResource}, nil
+	}
+
+	kind, ownerID, err := models.GetItemOwner(hash, db)
+	if errors.Is(err, models.ErrItemNotFound) {
+		return Decision{Reason: ReasonNotFound}, nil
+	} else if err != nil {
+		return Decision{}, err
+	}
+
+	return Authorize(principal, action, Resource{Kind: kind, Hash: hash, OwnerID: ownerID}, db)
+}
+
+// Authorize checks that a principal may perform an action on a resource
+//
+// Admins may do anything, owners may do anything their role allows and
+// other users need an explicit share; delete is reserved to owners and
+// admins. Allowed requests also need a scope for the action on the kind
+// of resource, which is checked last so that missing scopes do not tell
+// whether a resource the principal cannot see exists
+func Authorize(principal Principal, action Action, resource Resource, db *sql.DB) (Decision, error) {
+	decision, err := authorize(principal, action, resource, db)
+	if err != nil || !decision.Allowed {
+		return decision, err
+	}
+
+	if !principal.HasScope(RequiredScope(resource.Kind, action)) {
+		return Decision{Reason: ReasonMissingScope}, nil
+	}
+
+	return decision, nil
+}
+
+func authorize(principal Principal, action Action, resource Resource, db *sql.DB) (Decision, error) {
+	readOnly := principal.HasRole(models.USER_ROLE_READONLY)
+
+	if principal.HasRole(models.USER_ROLE_ADMIN) {
+		return Decision{Allowed: true, Reason: ReasonAdmin}, nil
+	}
+
+	if principal.UserID == resource.OwnerID {
+		if action != ActionRead && readOnly {
+			return Decision{Reason: ReasonReadOnly}, nil
+		}
+
+		return Decision{Allowed: true, Reason: ReasonOwner}, nil
+	}
+
+	permission, err := models.GetSharePermission(resource.Hash, principal.UserID, db)
+	if errors.Is(err, models.ErrShareNotFound) {
+		return Decision{Reason: ReasonNotFound}, nil
+	} else if err != nil {
+		return Decision{}, err
+	}
+
+	switch {
+	case action == ActionRead:
+		return Decision{Allowed: true, Reason: ReasonShared}, nil
+	case action == ActionWrite && permission == models.SHARE_PERMISSION_WRITE && !readOnly:
+		return Decision{Allowed: true, Reason: ReasonShared}, nil
+	}
+
+	return Decision{Reason: ReasonForbidden}, nil
+}
+
+// routeKinds returns the kinds of resources a route without a hash acts on
+func routeKinds(path string) []string {
+	switch {
+	case path == "/list" || path == "/trash":
+		return []string{models.ITEM_KIND_FILE, models.ITEM_KIND_TEXT}
+	case path == "/texts" || strings.HasPrefix(path, "/texts/"):
+		return []string{models.ITEM_KIND_TEXT}
+	}
+
+	return []string{models.ITEM_KIND_FILE}
+}
+
+// ReadableKinds returns the kinds of resources the principal has the read
+// scope for, so that listings can leave out the others
+func ReadableKinds(principal Principal) []string {
+	kinds := []string{}
+	for _, kind := range []string{models.ITEM_KIND_FILE, models.ITEM_KIND_TEXT} {
+		if principal.HasScope(RequiredScope(kind, ActionRead)) {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	return kinds
+}
+
+// RequiredScope returns the API key scope needed for an action on a kind
+// of resource; deleting needs the write scope
+func RequiredScope(kind string, action Action) string {
+	switch {
+	case kind == models.ITEM_KIND_TEXT && action == ActionRead:
+		return models.API_KEY_SCOPE_TEXTS_READ
+	case kind == models.ITEM_KIND_TEXT:
+		return models.API_KEY_SCOPE_TEXTS_WRITE
+	case action == ActionRead:
+		return models.API_KEY_SCOPE_FILES_READ
+	}
+
+	return models.API_KEY_SCOPE_FILES_WRITE
 }
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"riley/internal/config"
+	"riley/internal/models"
+	"riley/internal/sql"
+)
+
+func TestAuthorize(t *testing.T) {
+	db := sql.Connect(config.LoadTestConfig())
+
+	users := map[string]*models.User{}
+
+	for _, name := range []string{"owner", "other", "shared", "admin"} {
+		user, err := models.UserCreate("exampleTestAuthorize"+name+"@example.com", "password123%A%", config.LoadTestConfig().Password, db)
+		if err != nil {
+			t.Fatal("Creating user: Wanted nil, got", err)
+		}
+
+		users[name] = &user
+
+		defer func() {
+			err = user.Delete(false, db)
+			if err != nil {
+				t.Error("Delete user during authorize test failed: Wanted nil, got", err)
+			}
+		}()
+	}
+
+	err := users["admin"].SetRole(models.USER_ROLE_ADMIN, db)
+	if err != nil {
+		t.Fatal("Setting admin role: Wanted nil, got", err)
+	}
+
+	text, err := models.CreateText("authorize", users["owner"].ID, time.Now().UTC().Add(time.Hour), []byte("authorize"), db)
+	if err != nil {
+		t.Fatal("Creating text: Wanted nil, got", err)
+	}
+
+	defer func() {
+		err = text.Delete(db)
+		if err != nil {
+			t.Error("Delete text during authorize test failed: Wanted nil, got", err)
+		}
+	}()
+
+	_, err = models.CreateShare(text.Hash, users["shared"].ID, models.SHARE_PERMISSION_READ, db)
+	if err != nil {
+		t.Fatal("Creating share: Wanted nil, got", err)
+	}
+
+	resource := Resource{Kind: models.ITEM_KIND_TEXT, Hash: text.Hash, OwnerID: text.UserID}
+
+	tests := []struct {
+		user    string
+		action  Action
+		reason  Reason
+		allowed bool
+	}{
+		{"owner", ActionDelete, ReasonOwner, true},
+		{"other", ActionRead, ReasonNotFound, false},
+		{"shared", ActionRead, ReasonShared, true},
+		{"shared", ActionDelete, ReasonForbidden, false},
+		{"admin", ActionDelete, ReasonAdmin, true},
+	}
+
+	for _, tt := range tests {
+		decision, err := Authorize(NewUserPrincipal(*users[tt.user], AUTH_METHOD_TOKEN), tt.action, resource, db)
+		if err != nil {
+			t.Error("Testing authorize for", tt.user, ": Wanted nil, got", err)
+		}
+
+		if decision.Allowed != tt.allowed || decision.Reason != tt.reason {
+			t.Error("Testing authorize for", tt.user, tt.action, ": Wanted", tt.allowed, tt.reason, "got", decision.Allowed, decision.Reason)
+		}
+	}
+
+	err = users["owner"].SetRole(models.USER_ROLE_READONLY, db)
+	if err != nil {
+		t.Fatal("Setting readonly role: Wanted nil, got", err)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, "/texts/"+text.Hash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.SetPathValue("hash", text.Hash)
+
+	decision, err := IsAuthorized(req, NewUserPrincipal(*users["owner"], AUTH_METHOD_TOKEN), Policy{}, db)
+	if err != nil {
+		t.Error("Testing is authorized for readonly owner: Wanted nil, got", err)
+	}
+
+	if decision.Allowed || decision.Reason != ReasonReadOnly {
+		t.Error("Testing is authorized for readonly owner: Wanted", ReasonReadOnly, "got", decision.Reason)
+	}
+
+	req.SetPathValue("hash", "missing")
+
+	decision, err = IsAuthorized(req, NewUserPrincipal(*users["other"], AUTH_METHOD_TOKEN), Policy{}, db)
+	if err != nil {
+		t.Error("Testing is authorized for missing hash: Wanted nil, got", err)
+	}
+
+	if decision.Allowed || decision.Reason != ReasonNotFound {
+		t.Error("Testing is authorized for missing hash: Wanted", ReasonNotFound, "got", decision.Reason)
+	}
+}
+
+func TestIsAuthorizedPolicy(t *testing.T) {
+	policy := Policy{RequireVerifiedEmail: true}
+	unverified := Principal{Roles: []string{models.USER_ROLE_USER}, UserID: 1}
+	verified := Principal{Roles: []string{models.USER_ROLE_USER}, UserID: 1, EmailVerified: true}
+
+	tests := []struct {
+		method    string
+		principal Principal
+		policy    Policy
+		reason    Reason
+	}{
+		{http.MethodGet, unverified, policy, ReasonNoResource},
+		{http.MethodPost, unverified, policy, ReasonUnverified},
+		{http.MethodPost, verified, policy, ReasonNoResource},
+		{http.MethodPost, unverified, Policy{}, ReasonNoResource},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "/upload", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decision, err := IsAuthorized(req, tt.principal, tt.policy, nil)
+		if err != nil || decision.Reason != tt.reason {
+			t.Error("Testing is authorized with", tt.method, "and", tt.policy, ": Wanted", tt.reason, "got", decision.Reason, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrDecrypt is returned for ciphertexts that were not encrypted with the
+// key of the cipher or were modified
+var ErrDecrypt = errors.New("cannot decrypt secret")
+
+// SecretCipher encrypts secrets riley must read back, such as TOTP
+// secrets, with AES-GCM
+type SecretCipher struct {
+	aead cipher.AEAD
+}
+
+// NewSecretCipher creates a cipher from a hex encoded 32 byte key
+func NewSecretCipher(hexKey string) (*SecretCipher, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretCipher{aead: aead}, nil
+}
+
+// Encrypt returns the base64 encoded nonce and ciphertext of the secret
+func (c *SecretCipher) Encrypt(secret string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(secret), nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the secret of a ciphertext returned by Encrypt
+func (c *SecretCipher) Decrypt(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+
+	nonce, sealed := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+
+	secret, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+
+	return string(secret), nil
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSecretCipher(t *testing.T) {
+	cipher, err := NewSecretCipher("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := cipher.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := cipher.Decrypt(encrypted)
+	if err != nil || secret != "secret" {
+		t.Error("Testing decrypt: Wanted secret, got", secret, err)
+	}
+
+	other, err := NewSecretCipher("1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = other.Decrypt(encrypted)
+	if !errors.Is(err, ErrDecrypt) {
+		t.Error("Testing decrypt with other key: Wanted", ErrDecrypt, "got", err)
+	}
+
+	_, err = NewSecretCipher("0001")
+	if err == nil {
+		t.Error("Testing short key: Wanted error, got nil")
+	}
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+
+	"riley/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted in the keyring
+const minRSAKeyBits = 2048
+
+// ErrUnknownKey is returned for tokens whose kid is not in the keyring
+var ErrUnknownKey = errors.New("unknown token key")
+
+// Keyring signs access tokens with its signing key and verifies them with
+// any of its keys, selected by the kid header
+//
+// Each key is pinned to its algorithm, so a token cannot pick how it is
+// verified
+type Keyring struct {
+	signing  *keyringKey
+	keys     map[string]*keyringKey
+	parser   *jwt.Parser
+	issuer   string
+	audience string
+}
+
+type keyringKey struct {
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+	id        string
+}
+
+// NewKeyring creates a keyring from the config
+func NewKeyring(c config.TokenConfig) (*Keyring, error) {
+	k := &Keyring{
+		keys:     map[string]*keyringKey{},
+		issuer:   c.Issuer,
+		audience: c.Audience,
+	}
+
+	methods := []string{}
+
+	for _, ck := range c.Keys {
+		if ck.ID == "" {
+			return nil, errors.New("token key without id")
+		}
+
+		if _, ok := k.keys[ck.ID]; ok {
+			return nil, fmt.Errorf("duplicate token key %q", ck.ID)
+		}
+
+		key, err := newKeyringKey(ck)
+		if err != nil {
+			return nil, fmt.Errorf("token key %q: %w", ck.ID, err)
+		}
+
+		k.keys[ck.ID] = key
+		methods = append(methods, key.method.Alg())
+	}
+
+	signing, ok := k.keys[c.SigningKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not in the keyring", c.SigningKeyID)
+	}
+
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", c.SigningKeyID)
+	}
+
+	k.signing = signing
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(c.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+
+	if c.Issuer != "" {
+		options = append(options, jwt.WithIssuer(c.Issuer))
+	}
+
+	if c.Audience != "" {
+		options = append(options, jwt.WithAudience(c.Audience))
+	}
+
+	k.parser = jwt.NewParser(options...)
+
+	return k, nil
+}
+
+func newKeyringKey(c config.TokenKey) (*keyringKey, error) {
+	key := &keyringKey{id: c.ID}
+
+	switch c.Algorithm {
+	case config.TOKEN_ALGORITHM_HS256:
+		if c.Secret == "" {
+			return nil, errors.New("secret is empty")
+		}
+
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(c.Secret)
+		key.verifyKey = []byte(c.Secret)
+	case config.TOKEN_ALGORITHM_RS256:
+		key.method = jwt.SigningMethodRS256
+
+		if c.PrivateKey != "" {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(c.PrivateKey))
+			if err != nil {
+				return nil, err
+			}
+
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		} else {
+			public, err := jwt.ParseRSAPublicKeyFromPEM([]byte(c.PublicKey))
+			if err != nil {
+				return nil, err
+			}
+
+			key.verifyKey = public
+		}
+
+		if key.verifyKey.(*rsa.PublicKey).N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+	case config.TOKEN_ALGORITHM_EDDSA:
+		key.method = jwt.SigningMethodEdDSA
+
+		if c.PrivateKey != "" {
+			private, err := jwt.ParseEdPrivateKeyFromPEM([]byte(c.PrivateKey))
+			if err != nil {
+				return nil, err
+			}
+
+			edPrivate, ok := private.(ed25519.PrivateKey)
+			if !ok {
+				return nil, jwt.ErrNotEdPrivateKey
+			}
+
+			key.signKey = edPrivate
+			key.verifyKey = edPrivate.Public()
+		} else {
+			public, err := jwt.ParseEdPublicKeyFromPEM([]byte(c.PublicKey))
+			if err != nil {
+				return nil, err
+			}
+
+			key.verifyKey = public
+		}
+	default:
+		return nil, fmt.Errorf("algorithm %q not supported", c.Algorithm)
+	}
+
+	return key, nil
+}
+
+// Sign signs the claims with the signing key, setting the kid header and
+// the iss and aud claims
+func (k *Keyring) Sign(claims jwt.MapClaims) (string, error) {
+	if k.issuer != "" {
+		claims["iss"] = k.issuer
+	}
+
+	if k.audience != "" {
+		claims["aud"] = k.audience
+	}
+
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.id
+
+	return token.SignedString(k.signing.signKey)
+}
+
+// Parse verifies a token with the key named by its kid and validates its
+// exp, nbf, iat, iss and aud claims
+func (k *Keyring) Parse(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := k.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKSet is a set of JSON Web Keys as served by a JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyring
+//
+// HS256 keys are shared secrets and are never published, so tokens
+// signed with them can only be verified by riley itself
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range k.keys {
+		jwk := JWK{
+			KeyID:     key.id,
+			Algorithm: key.method.Alg(),
+			Use:       "sig",
+		}
+
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	slices.SortFunc(set.Keys, func(a, b JWK) int {
+		return strings.Compare(a.KeyID, b.KeyID)
+	})
+
+	return set
+}
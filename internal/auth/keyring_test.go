@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"riley/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestKeyring(t *testing.T, secret string) *Keyring {
+	t.Helper()
+
+	c := config.LoadTestConfig().Token
+	c.Keys = []config.TokenKey{{ID: "test", Algorithm: config.TOKEN_ALGORITHM_HS256, Secret: secret}}
+	c.SigningKeyID = "test"
+
+	keyring, err := NewKeyring(c)
+	if err != nil {
+		t.Fatal("Creating keyring: Wanted nil, got", err)
+	}
+
+	return keyring
+}
+
+func encodePEM(t *testing.T, blockType string, der []byte, err error) string {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal("Encoding key: Wanted nil, got", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}))
+}
+
+func newTestKeys(t *testing.T) (config.TokenKey, config.TokenKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaDER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	rsaPEM := encodePEM(t, "PRIVATE KEY", rsaDER, err)
+
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	edPEM := encodePEM(t, "PRIVATE KEY", edDER, err)
+
+	return config.TokenKey{ID: "rsa", Algorithm: config.TOKEN_ALGORITHM_RS256, PrivateKey: rsaPEM},
+		config.TokenKey{ID: "ed", Algorithm: config.TOKEN_ALGORITHM_EDDSA, PrivateKey: edPEM}
+}
+
+func testClaims() jwt.MapClaims {
+	now := time.Now().UTC()
+
+	return jwt.MapClaims{
+		"exp": now.Add(time.Hour).Unix(),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"sub": 1,
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	hsKey := config.TokenKey{ID: "hs", Algorithm: config.TOKEN_ALGORITHM_HS256, Secret: "secret"}
+
+	c := config.LoadTestConfig().Token
+	c.Keys = []config.TokenKey{hsKey, rsaKey, edKey}
+
+	for _, kid := range []string{"hs", "rsa", "ed"} {
+		t.Run("sign and verify "+kid, func(t *testing.T) {
+			c.SigningKeyID = kid
+
+			keyring, err := NewKeyring(c)
+			if err != nil {
+				t.Fatal("Creating keyring: Wanted nil, got", err)
+			}
+
+			token, err := keyring.Sign(testClaims())
+			if err != nil {
+				t.Fatal("Signing token: Wanted nil, got", err)
+			}
+
+			claims, err := keyring.Parse(token)
+			if err != nil {
+				t.Fatal("Parsing token: Wanted nil, got", err)
+			}
+
+			if claims["iss"] != c.Issuer || claims["aud"] != c.Audience {
+				t.Error("Parsing token: Wanted iss and aud, got", claims)
+			}
+		})
+	}
+
+	t.Run("rotation", func(t *testing.T) {
+		c.Keys = []config.TokenKey{rsaKey}
+		c.SigningKeyID = "rsa"
+
+		old, err := NewKeyring(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		oldToken, err := old.Sign(testClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Keys = []config.TokenKey{rsaKey, edKey}
+		c.SigningKeyID = "ed"
+
+		rotated, err := NewKeyring(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = rotated.Parse(oldToken)
+		if err != nil {
+			t.Error("Parsing token of the old key: Wanted nil, got", err)
+		}
+
+		newToken, err := rotated.Sign(testClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = old.Parse(newToken)
+		if err == nil {
+			t.Error("Parsing token of the new key with the old keyring: Wanted error, got nil")
+		}
+
+		unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, testClaims())
+		unknown.Header["kid"] = "retired"
+
+		unknownToken, err := unknown.SignedString(old.keys["rsa"].signKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = old.Parse(unknownToken)
+		if !errors.Is(err, ErrUnknownKey) {
+			t.Error("Parsing token of a removed key: Wanted", ErrUnknownKey, "got", err)
+		}
+	})
+
+	t.Run("algorithm confusion", func(t *testing.T) {
+		c.Keys = []config.TokenKey{rsaKey}
+		c.SigningKeyID = "rsa"
+
+		keyring, err := NewKeyring(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// HS256 signed with the RSA public key, which verifiers that trust
+		// the alg header would accept
+		publicDER, err := x509.MarshalPKIXPublicKey(keyring.keys["rsa"].verifyKey)
+		publicPEM := encodePEM(t, "PUBLIC KEY", publicDER, err)
+
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+		forged.Header["kid"] = "rsa"
+		forged.Claims.(jwt.MapClaims)["iss"] = c.Issuer
+		forged.Claims.(jwt.MapClaims)["aud"] = c.Audience
+
+		forgedToken, err := forged.SignedString([]byte(publicPEM))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = keyring.Parse(forgedToken)
+		if err == nil {
+			t.Error("Parsing HS256 token for an RS256 key: Wanted error, got nil")
+		}
+
+		unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims())
+		unsigned.Header["kid"] = "rsa"
+
+		unsignedToken, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = keyring.Parse(unsignedToken)
+		if err == nil {
+			t.Error("Parsing unsigned token: Wanted error, got nil")
+		}
+	})
+
+	t.Run("claims", func(t *testing.T) {
+		keyring := newTestKeyring(t, "secret")
+
+		tests := []struct {
+			name  string
+			claim string
+			value any
+		}{
+			{"wrong issuer", "iss", "someone else"},
+			{"wrong audience", "aud", "someone else"},
+			{"not yet valid", "nbf", time.Now().Add(time.Hour).Unix()},
+			{"expired", "exp", time.Now().Add(-time.Hour).Unix()},
+		}
+
+		for _, tt := range tests {
+			claims := testClaims()
+			claims["iss"] = "riley"
+			claims["aud"] = "riley"
+			claims[tt.claim] = tt.value
+
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			token.Header["kid"] = "test"
+
+			tokenString, err := token.SignedString([]byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = keyring.Parse(tokenString)
+			if err == nil {
+				t.Error("Parsing token with", tt.name, ": Wanted error, got nil")
+			}
+		}
+	})
+
+	t.Run("jwks", func(t *testing.T) {
+		c.Keys = []config.TokenKey{hsKey, rsaKey, edKey}
+		c.SigningKeyID = "hs"
+
+		keyring, err := NewKeyring(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jwks := keyring.JWKS()
+		if len(jwks.Keys) != 2 {
+			t.Fatal("Testing JWKS: Wanted 2 keys, got", jwks.Keys)
+		}
+
+		if jwks.Keys[0].KeyID != "ed" || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[0].X == "" {
+			t.Error("Testing JWKS Ed25519 key: got", jwks.Keys[0])
+		}
+
+		if jwks.Keys[1].KeyID != "rsa" || jwks.Keys[1].KeyType != "RSA" || jwks.Keys[1].E != "AQAB" {
+			t.Error("Testing JWKS RSA key: got", jwks.Keys[1])
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		c.Keys = []config.TokenKey{hsKey}
+		c.SigningKeyID = "missing"
+
+		_, err := NewKeyring(c)
+		if err == nil {
+			t.Error("Creating keyring without signing key: Wanted error, got nil")
+		}
+
+		c.Keys = []config.TokenKey{{ID: "rsa", Algorithm: config.TOKEN_ALGORITHM_RS256}}
+		c.SigningKeyID = "rsa"
+
+		_, err = NewKeyring(c)
+		if err == nil {
+			t.Error("Creating keyring with RSA key without PEM: Wanted error, got nil")
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"riley/internal/models"
+)
+
+const (
+	AUTH_METHOD_TOKEN   = "token"
+	AUTH_METHOD_API_KEY = "api_key"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request does
+// not carry credentials it understands, so the next one can be tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is the authenticated identity behind a request
+//
+// Scopes restrict what the principal may do; a nil slice means the
+// principal is not restricted beyond its roles. SessionID is zero for
+// tokens that do not belong to a session
+type Principal struct {
+	AuthMethod    string
+	TokenID       string
+	Roles         []string
+	Scopes        []string
+	SessionID     uint64
+	UserID        uint64
+	EmailVerified bool
+}
+
+// HasScope checks if the principal may act within the scope
+//
+// Principals without scopes are unrestricted, and the admin scope
+// includes every other scope
+func (p Principal) HasScope(scope string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, models.API_KEY_SCOPE_ADMIN)
+}
+
+// NewUserPrincipal creates the principal of a user authenticated with the
+// given method
+func NewUserPrincipal(user models.User, method string) Principal {
+	return Principal{
+		AuthMethod:    method,
+		Roles:         []string{user.Role},
+		UserID:        user.ID,
+		EmailVerified: user.IsEmailVerified(),
+	}
+}
+
+// HasRole checks if the principal has the role
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Authenticator turns the credentials of a request into a principal
+//
+// Returns ErrNoCredentials if the request has no credentials of its kind
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// GetPrincipal returns the principal stored in ctx by the authentication
+// middleware
+func GetPrincipal(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+
+	return p, ok
+}
+
+// BearerToken returns the token of the Authorization header, with or
+// without the Bearer scheme
+func BearerToken(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	return header
+}
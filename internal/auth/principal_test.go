@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"riley/internal/models"
+)
+
+func TestPrincipalScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{"unrestricted", nil, models.API_KEY_SCOPE_FILES_WRITE, true},
+		{"no scopes", []string{}, models.API_KEY_SCOPE_FILES_READ, false},
+		{"matching scope", []string{models.API_KEY_SCOPE_FILES_READ}, models.API_KEY_SCOPE_FILES_READ, true},
+		{"other scope", []string{models.API_KEY_SCOPE_FILES_READ}, models.API_KEY_SCOPE_FILES_WRITE, false},
+		{"admin scope", []string{models.API_KEY_SCOPE_ADMIN}, models.API_KEY_SCOPE_TEXTS_WRITE, true},
+	}
+
+	for _, tt := range tests {
+		p := Principal{Scopes: tt.scopes}
+		if got := p.HasScope(tt.scope); got != tt.want {
+			t.Error("Testing has scope with", tt.name, ": Wanted", tt.want, "got", got)
+		}
+	}
+
+	scopes := []struct {
+		kind   string
+		action Action
+		want   string
+	}{
+		{models.ITEM_KIND_FILE, ActionRead, models.API_KEY_SCOPE_FILES_READ},
+		{models.ITEM_KIND_FILE, ActionDelete, models.API_KEY_SCOPE_FILES_WRITE},
+		{models.ITEM_KIND_TEXT, ActionRead, models.API_KEY_SCOPE_TEXTS_READ},
+		{models.ITEM_KIND_TEXT, ActionWrite, models.API_KEY_SCOPE_TEXTS_WRITE},
+	}
+
+	for _, tt := range scopes {
+		if got := RequiredScope(tt.kind, tt.action); got != tt.want {
+			t.Error("Testing required scope for", tt.kind, tt.action, ": Wanted", tt.want, "got", got)
+		}
+	}
+
+	listings := []struct {
+		path   string
+		scopes []string
+		want   Reason
+		kinds  []string
+	}{
+		{"/list", []string{models.API_KEY_SCOPE_FILES_READ}, ReasonNoResource, []string{models.ITEM_KIND_FILE}},
+		{"/list", []string{models.API_KEY_SCOPE_TEXTS_READ}, ReasonNoResource, []string{models.ITEM_KIND_TEXT}},
+		{"/trash", []string{models.API_KEY_SCOPE_FILES_WRITE}, ReasonMissingScope, []string{}},
+		{"/list", nil, ReasonNoResource, []string{models.ITEM_KIND_FILE, models.ITEM_KIND_TEXT}},
+	}
+
+	for _, tt := range listings {
+		p := Principal{Scopes: tt.scopes}
+
+		decision, err := IsAuthorized(httptest.NewRequest("GET", tt.path, nil), p, Policy{}, nil)
+		if err != nil || decision.Reason != tt.want {
+			t.Error("Testing listing", tt.path, "with", tt.scopes, ": Wanted", tt.want, "got", decision.Reason, err)
+		}
+
+		if got := ReadableKinds(p); !slices.Equal(got, tt.kinds) {
+			t.Error("Testing readable kinds with", tt.scopes, ": Wanted", tt.kinds, "got", got)
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := map[string]string{
+		"":                "",
+		"abc":             "abc",
+		"Bearer abc":      "abc",
+		"bearer rly_abc ": "rly_abc",
+	}
+
+	for header, want := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", header)
+
+		if got := BearerToken(r); got != want {
+			t.Error("Testing bearer token for", header, ": Wanted", want, "got", got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"riley/internal/models"
+)
+
+// RevocationList caches the IDs of revoked access tokens in memory
+//
+// Tokens revoked through this instance are added immediately; Sync picks
+// up the ones revoked by other instances
+type RevocationList struct {
+	now     func() time.Time
+	revoked map[string]time.Time
+	db      *sql.DB
+	mu      sync.RWMutex
+}
+
+// NewRevocationList creates a revocation list loaded from the database
+func NewRevocationList(db *sql.DB) (*RevocationList, error) {
+	l := &RevocationList{
+		now:     time.Now,
+		revoked: map[string]time.Time{},
+		db:      db,
+	}
+
+	return l, l.Sync()
+}
+
+// Sync merges the tokens revoked in the database into the cached list and
+// drops the ones that expired
+//
+// Cached tokens missing from the database are kept until they expire, so
+// that an Add racing with the query is not lost
+func (l *RevocationList) Sync() error {
+	now := l.now()
+
+	tokens, err := models.GetRevokedTokens(now, l.db)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, token := range tokens {
+		l.revoked[token.ID] = token.ExpiresAt
+	}
+
+	for id, expiresAt := range l.revoked {
+		if !expiresAt.After(now) {
+			delete(l.revoked, id)
+		}
+	}
+
+	return nil
+}
+
+// Add caches tokens that were revoked in the database
+func (l *RevocationList) Add(tokens ...models.RevokedToken) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, token := range tokens {
+		l.revoked[token.ID] = token.ExpiresAt
+	}
+}
+
+// IsRevoked checks if the token with the ID was revoked
+func (l *RevocationList) IsRevoked(tokenID string) bool {
+	if tokenID == "" {
+		return false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.revoked[tokenID]
+
+	return ok
+}
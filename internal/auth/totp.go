@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// totpPeriod is the number of seconds each TOTP code is valid for
+	totpPeriod = 30
+
+	// totpDigits is the length of TOTP codes
+	totpDigits = 6
+
+	// totpSkew is the number of periods before and after the current one
+	// whose codes are accepted, for clocks that drifted apart
+	totpSkew = 1
+
+	// recoveryCodeCount is the number of recovery codes issued at once
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random base32 encoded TOTP secret
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps enroll with, most
+// often from a QR code
+func TOTPURI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+// TOTPCode returns the RFC 6238 code of the secret at the time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	return totpCode(key, t.Unix()/totpPeriod), nil
+}
+
+// ValidateTOTP checks the code against the secret at the time, allowing
+// for clock skew
+//
+// If the code is valid, the counter it was generated for is returned, so
+// that the caller can reject codes that were already used
+func ValidateTOTP(secret string, code string, t time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	counter := t.Unix() / totpPeriod
+
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, counter+i)), []byte(code)) == 1 {
+			return counter + i, true
+		}
+	}
+
+	return 0, false
+}
+
+func totpCode(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// NewRecoveryCodes returns random single use recovery codes and the
+// hashes they are stored under
+func NewRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 10)
+
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored under,
+// ignoring case, spaces and dashes as users type them
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	return hashToken(code)
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"encoding/base32"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"riley/internal/models"
+)
+
+func TestTOTP(t *testing.T) {
+	// The SHA1 test vectors of RFC 6238, truncated to six digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(secret, time.Unix(tt.time, 0))
+		if err != nil || code != tt.code {
+			t.Error("Testing TOTP code at", tt.time, ": Wanted", tt.code, "got", code, err)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+
+	counter, ok := ValidateTOTP(secret, "005924", now)
+	if !ok || counter != 1234567890/30 {
+		t.Error("Testing TOTP validation: Wanted", 1234567890/30, "got", counter, ok)
+	}
+
+	_, ok = ValidateTOTP(secret, "005924", now.Add(30*time.Second))
+	if !ok {
+		t.Error("Testing TOTP validation of the previous code: Wanted true, got false")
+	}
+
+	_, ok = ValidateTOTP(secret, "005924", now.Add(2*time.Minute))
+	if ok {
+		t.Error("Testing TOTP validation of an old code: Wanted false, got true")
+	}
+
+	_, ok = ValidateTOTP(secret, "00592", now)
+	if ok {
+		t.Error("Testing TOTP validation of a short code: Wanted false, got true")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("riley", "user@example.com", "SECRET"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/riley:user@example.com" {
+		t.Error("Testing TOTP URI: got", uri)
+	}
+
+	if uri.Query().Get("secret") != "SECRET" || uri.Query().Get("issuer") != "riley" {
+		t.Error("Testing TOTP URI query: got", uri.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatal("Testing recovery codes: Wanted", recoveryCodeCount, "got", len(codes))
+	}
+
+	if HashRecoveryCode(codes[0]) != hashes[0] {
+		t.Error("Testing recovery code hash: Wanted", hashes[0], "got", HashRecoveryCode(codes[0]))
+	}
+
+	typed := codes[0][:8] + " " + codes[0][9:]
+	if HashRecoveryCode(typed) != hashes[0] {
+		t.Error("Testing recovery code hash of typed code: Wanted", hashes[0], "got", HashRecoveryCode(typed))
+	}
+}
+
+func TestMFAChallengeToken(t *testing.T) {
+	keyring := newTestKeyring(t, "secret")
+	revocations := &RevocationList{now: time.Now, revoked: map[string]time.Time{}}
+	expiryDate := time.Now().UTC().Add(5 * time.Minute)
+
+	challenge, err := GenerateMFAChallengeToken(expiryDate, 1, "challenge", keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, tokenID, err := ParseMFAChallengeToken(challenge, keyring, revocations)
+	if err != nil || userID != 1 || tokenID != "challenge" {
+		t.Error("Testing parse MFA challenge: Wanted 1 challenge, got", userID, tokenID, err)
+	}
+
+	err = CheckToken(challenge, keyring, revocations)
+	if !errors.Is(err, ErrWrongTokenType) {
+		t.Error("Testing MFA challenge as access token: Wanted", ErrWrongTokenType, "got", err)
+	}
+
+	access, err := GenerateToken(expiryDate, 1, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = ParseMFAChallengeToken(access, keyring, revocations)
+	if !errors.Is(err, ErrWrongTokenType) {
+		t.Error("Testing access token as MFA challenge: Wanted", ErrWrongTokenType, "got", err)
+	}
+
+	revocations.Add(models.RevokedToken{ID: "challenge", ExpiresAt: expiryDate})
+
+	_, _, err = ParseMFAChallengeToken(challenge, keyring, revocations)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Error("Testing revoked MFA challenge: Wanted", ErrTokenRevoked, "got", err)
+	}
+}
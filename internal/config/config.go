@@ -1,11 +1,336 @@
 package config
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type Config struct {
-	Storage     StorageConfigInterface
-	TokenSecret string
-	Postgres    PostgresConfig
+	Storage  StorageConfigInterface
+	Token    TokenConfig
+	Postgres PostgresConfig
+	// TrashRetention is how long deleted items can be restored before
+	// they are purged
+	TrashRetention time.Duration
+	RateLimit      RateLimitConfig
+	Session        SessionConfig
+	OIDC           OIDCConfig
+	MFA            MFAConfig
+	Mail           MailConfig
+	Account        AccountConfig
+	Lockout        LockoutConfig
+	// LoginEventRetention is how long login attempts are kept
+	LoginEventRetention time.Duration
+	Password            PasswordConfig
+}
+
+// PasswordConfig configures how passwords are hashed and which new
+// passwords are accepted
+type PasswordConfig struct {
+	Hasher PasswordHasherConfig
+	Policy PasswordPolicyConfig
+}
+
+// PasswordHasherConfig selects how new passwords are hashed
+//
+// Hashes made with another algorithm or other parameters keep working and
+// are replaced on the next successful login
+type PasswordHasherConfig struct {
+	// Algorithm is PASSWORD_HASHER_ARGON2ID or PASSWORD_HASHER_BCRYPT
+	Algorithm string
+	// Argon2Memory is the memory argon2id uses, in KiB
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+	BcryptCost    int
+}
+
+const (
+	PASSWORD_HASHER_ARGON2ID = "argon2id"
+	PASSWORD_HASHER_BCRYPT   = "bcrypt"
+)
+
+// PasswordPolicyConfig configures the requirements for new passwords
+//
+// Lengths are counted in characters, or in bytes if CountBytes is set; a
+// MaxLength of 0 means no limit
+type PasswordPolicyConfig struct {
+	// BreachedListPath is a file with one known breached password per
+	// line, which are rejected; none are if it is empty
+	BreachedListPath string
+	MinLength        int
+	MaxLength        int
+	RequireUpper     bool
+	RequireLower     bool
+	RequireNumber    bool
+	RequireSpecial   bool
+	// CountBytes is set by passwords.Policy for hashers that limit the
+	// password length in bytes
+	CountBytes bool
+}
+
+func defaultPasswordConfig() PasswordConfig {
+	return PasswordConfig{
+		Hasher: PasswordHasherConfig{
+			Algorithm:     PASSWORD_HASHER_ARGON2ID,
+			Argon2Memory:  64 * 1024,
+			Argon2Time:    3,
+			Argon2Threads: 2,
+			BcryptCost:    10,
+		},
+		Policy: PasswordPolicyConfig{
+			MinLength:      8,
+			MaxLength:      64,
+			RequireUpper:   true,
+			RequireLower:   true,
+			RequireNumber:  true,
+			RequireSpecial: true,
+		},
+	}
+}
+
+// LockoutConfig configures how an account is locked after failed logins
+//
+// Once Threshold attempts failed, every further failure locks the account
+// for twice as long as the previous one, starting at BaseDuration and up
+// to MaxDuration
+type LockoutConfig struct {
+	Threshold    int
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+	// ResetAfter is how long after the last failure the count starts over
+	ResetAfter time.Duration
+}
+
+func defaultLockoutConfig() LockoutConfig {
+	return LockoutConfig{
+		Threshold:    5,
+		BaseDuration: 30 * time.Second,
+		MaxDuration:  time.Hour,
+		ResetAfter:   24 * time.Hour,
+	}
+}
+
+// MailConfig configures how riley sends emails
+type MailConfig struct {
+	// Sender is MAIL_SENDER_SMTP, or MAIL_SENDER_MEMORY to keep emails in
+	// memory instead of sending them
+	Sender string
+	From   string
+	SMTP   SMTPConfig
+}
+
+type SMTPConfig struct {
+	Host     string
+	Username string
+	Password string
+	Port     int
+}
+
+const (
+	MAIL_SENDER_SMTP   = "smtp"
+	MAIL_SENDER_MEMORY = "memory"
+)
+
+// AccountConfig configures email verification and password resets
+type AccountConfig struct {
+	// BaseURL is where the links in emails point to, with the token
+	// added as a query parameter
+	BaseURL string
+	// VerificationTTL is how long an email verification link is valid
+	VerificationTTL time.Duration
+	// PasswordResetTTL is how long a password reset link is valid
+	PasswordResetTTL time.Duration
+	// RequireVerifiedEmail restricts users to reading until they verify
+	// their email
+	RequireVerifiedEmail bool
+}
+
+func defaultAccountConfig() AccountConfig {
+	return AccountConfig{
+		BaseURL:          "http://localhost:8080",
+		VerificationTTL:  24 * time.Hour,
+		PasswordResetTTL: time.Hour,
+	}
+}
+
+// MFAConfig configures two-factor authentication with TOTP codes
+type MFAConfig struct {
+	// EncryptionKey is the hex encoded 32 byte AES key TOTP secrets are
+	// encrypted with in the database. It has no default outside of tests
+	EncryptionKey string
+	// Issuer names riley in authenticator apps
+	Issuer string
+	// ChallengeTTL is how long a user has to enter their code after
+	// their password
+	ChallengeTTL time.Duration
+}
+
+// TEST_MFA_ENCRYPTION_KEY is the MFA encryption key of the test config;
+// since it is published, it must never be used to store real secrets
+const TEST_MFA_ENCRYPTION_KEY = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func defaultMFAConfig() MFAConfig {
+	return MFAConfig{
+		Issuer:       "riley",
+		ChallengeTTL: 5 * time.Minute,
+	}
+}
+
+func defaultTestMFAConfig() MFAConfig {
+	c := defaultMFAConfig()
+	c.EncryptionKey = TEST_MFA_ENCRYPTION_KEY
+
+	return c
+}
+
+// OIDCConfig configures login through an OpenID Connect provider, which is
+// disabled when Issuer is empty
+type OIDCConfig struct {
+	// Issuer is the URL the provider's discovery document is fetched from
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the URL of the callback route registered with the
+	// provider
+	RedirectURL string
+	Scopes      []string
+	// LoginTimeout is how long a user has to complete a login at the
+	// provider
+	LoginTimeout time.Duration
+}
+
+func defaultOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		Scopes:       []string{"openid", "email", "profile"},
+		LoginTimeout: 10 * time.Minute,
+	}
+}
+
+// TokenConfig holds the keys access tokens are signed and verified with
+//
+// Keys are rotated by adding the new key, switching SigningKeyID to it
+// and removing the old key once the tokens it signed have expired
+type TokenConfig struct {
+	Issuer   string
+	Audience string
+	// SigningKeyID is the kid of the key new tokens are signed with;
+	// the other keys only verify
+	SigningKeyID string
+	Keys         []TokenKey
+	// Leeway is the clock skew allowed when checking exp, nbf and iat
+	Leeway time.Duration
+}
+
+// TokenKey is a key of the token keyring
+//
+// HS256 keys use Secret. RS256 and EdDSA keys use a PEM encoded
+// PrivateKey, or only a PublicKey for keys that verify but no longer sign
+type TokenKey struct {
+	ID         string
+	Algorithm  string
+	Secret     string
+	PrivateKey string
+	PublicKey  string
+}
+
+const (
+	TOKEN_ALGORITHM_HS256 = "HS256"
+	TOKEN_ALGORITHM_RS256 = "RS256"
+	TOKEN_ALGORITHM_EDDSA = "EdDSA"
+)
+
+func defaultTokenConfig() TokenConfig {
+	return TokenConfig{
+		Issuer:       "riley",
+		Audience:     "riley",
+		SigningKeyID: "default",
+		Keys: []TokenKey{
+			{ID: "default", Algorithm: TOKEN_ALGORITHM_HS256, Secret: "secret"},
+		},
+		Leeway: 30 * time.Second,
+	}
+}
+
+type SessionConfig struct {
+	// AccessTokenTTL is how long an access token is valid; expired tokens
+	// are replaced using the session's refresh token
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long a session lasts after login
+	RefreshTokenTTL time.Duration
+	// RevocationSyncInterval is how often revoked tokens are reloaded
+	// from the database, so that revocations made by other instances
+	// are picked up
+	RevocationSyncInterval time.Duration
+}
+
+func defaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		AccessTokenTTL:         15 * time.Minute,
+		RefreshTokenTTL:        30 * 24 * time.Hour,
+		RevocationSyncInterval: 30 * time.Second,
+	}
+}
+
+// RateLimitPolicy is a token bucket refilled at Rate tokens per second up
+// to Burst tokens
+//
+// PerUser policies key buckets by the authenticated user and fall back to
+// the client IP for anonymous requests
+type RateLimitPolicy struct {
+	Rate    float64
+	Burst   int
+	PerUser bool
+}
+
+type RateLimitConfig struct {
+	// Policies are looked up by route name, with RATE_LIMIT_POLICY_DEFAULT
+	// used for routes without their own policy
+	Policies map[string]RateLimitPolicy
+	// TrustedProxies are the CIDRs allowed to set X-Forwarded-For
+	TrustedProxies []string
+	// CleanupInterval is how often idle buckets are evicted
+	CleanupInterval time.Duration
+	// Store is where limits are kept, RATE_LIMIT_STORE_POSTGRES shares
+	// them between instances
+	Store string
+	// StoreTimeout is how long to wait for a shared store before falling
+	// back to in-memory limits
+	StoreTimeout time.Duration
+	// MaxBuckets bounds the number of buckets kept in memory
+	MaxBuckets int
+}
+
+const (
+	RATE_LIMIT_POLICY_DEFAULT  = "default"
+	RATE_LIMIT_POLICY_LOGIN    = "login"
+	RATE_LIMIT_POLICY_SIGNUP   = "signup"
+	RATE_LIMIT_POLICY_DOWNLOAD = "download"
+	RATE_LIMIT_POLICY_REFRESH  = "refresh"
+	// RATE_LIMIT_POLICY_CLIENT limits authenticated routes per client IP
+	// before the credentials are checked
+	RATE_LIMIT_POLICY_CLIENT = "client"
+
+	RATE_LIMIT_STORE_MEMORY   = "memory"
+	RATE_LIMIT_STORE_POSTGRES = "postgres"
+)
+
+func defaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Policies: map[string]RateLimitPolicy{
+			RATE_LIMIT_POLICY_DEFAULT:  {Rate: 10, Burst: 60, PerUser: true},
+			RATE_LIMIT_POLICY_LOGIN:    {Rate: 5.0 / 60, Burst: 5},
+			RATE_LIMIT_POLICY_SIGNUP:   {Rate: 1.0 / 60, Burst: 3},
+			RATE_LIMIT_POLICY_DOWNLOAD: {Rate: 50, Burst: 200, PerUser: true},
+			RATE_LIMIT_POLICY_REFRESH:  {Rate: 1.0 / 60, Burst: 10},
+			RATE_LIMIT_POLICY_CLIENT:   {Rate: 50, Burst: 300},
+		},
+		TrustedProxies:  []string{"127.0.0.1/32", "::1/128"},
+		CleanupInterval: time.Minute,
+		Store:           RATE_LIMIT_STORE_POSTGRES,
+		StoreTimeout:    50 * time.Millisecond,
+		MaxBuckets:      100_000,
+	}
 }
 
 type PostgresConfig struct {
@@ -26,23 +351,37 @@ type StorageConfig struct {
 	StorageType string
 	Local       LocalConfig
 	AzureBlob   AzureBlobConfig
+	S3          S3Config
 }
 
 func (sc *StorageConfig) LoadConfig() error {
-	switch sc.StorageType {
-	case STORAGE_TYPE_LOCAL:
-		return sc.Local.LoadConfig()
-	case STORAGE_TYPE_AZURE_BLOB:
-		return sc.AzureBlob.LoadConfig()
-	default:
+	section := sc.Section()
+	if section == nil {
 		return errors.New("invalid storage type")
 	}
+
+	return section.LoadConfig()
 }
 
 func (sc *StorageConfig) GetStorageType() string {
 	return sc.StorageType
 }
 
+// Section returns the config of the selected storage type, or nil if the
+// storage type is invalid
+func (sc *StorageConfig) Section() StorageConfigInterface {
+	switch sc.StorageType {
+	case STORAGE_TYPE_LOCAL:
+		return &sc.Local
+	case STORAGE_TYPE_AZURE_BLOB:
+		return &sc.AzureBlob
+	case STORAGE_TYPE_S3:
+		return &sc.S3
+	default:
+		return nil
+	}
+}
+
 type LocalConfig struct {
 	Directory string
 }
@@ -57,8 +396,16 @@ func (lc *LocalConfig) GetStorageType() string {
 
 type AzureBlobConfig struct {
 	AccountName string
-	AccountKey  string
-	Container   string
+	// AccountKey is the base64 Shared Key of the account
+	AccountKey string
+	Container  string
+	// Endpoint is the blob service URL, defaulting to
+	// https://<AccountName>.blob.core.windows.net; set it to
+	// http://127.0.0.1:10000/<AccountName> for the Azurite emulator
+	Endpoint string
+	// BlockSize is the size of the blocks large files are uploaded in,
+	// and the most memory an upload holds at once
+	BlockSize int64
 }
 
 func (abc *AzureBlobConfig) LoadConfig() error {
@@ -69,14 +416,62 @@ func (abc *AzureBlobConfig) GetStorageType() string {
 	return STORAGE_TYPE_AZURE_BLOB
 }
 
+// S3Config configures an S3-compatible object store, such as AWS S3 or
+// MinIO
+type S3Config struct {
+	// Endpoint is the URL of the service, defaulting to
+	// https://s3.<Region>.amazonaws.com
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket in the path, as in
+	// <Endpoint>/<Bucket>/<key>, instead of in the host name; MinIO
+	// needs it unless it is set up with a domain
+	PathStyle bool
+	// PartSize is the size of the parts large files are uploaded in, at
+	// least 5MB, and the most memory an upload holds at once
+	PartSize int64
+	// PresignExpiry is how long presigned download URLs are valid for;
+	// downloads are streamed through riley instead if it is zero
+	PresignExpiry time.Duration
+}
+
+func (s3c *S3Config) LoadConfig() error {
+	return nil
+}
+
+func (s3c *S3Config) GetStorageType() string {
+	return STORAGE_TYPE_S3
+}
+
 const (
 	STORAGE_TYPE_LOCAL      = "local"
 	STORAGE_TYPE_AZURE_BLOB = "azure_blob"
+	STORAGE_TYPE_S3         = "s3"
 )
 
 func LoadConfig() *Config {
 	return &Config{
-		TokenSecret: "secret",
+		Token:               defaultTokenConfig(),
+		TrashRetention:      7 * 24 * time.Hour,
+		RateLimit:           defaultRateLimitConfig(),
+		Session:             defaultSessionConfig(),
+		OIDC:                defaultOIDCConfig(),
+		MFA:                 defaultMFAConfig(),
+		Account:             defaultAccountConfig(),
+		Lockout:             defaultLockoutConfig(),
+		LoginEventRetention: 90 * 24 * time.Hour,
+		Password:            defaultPasswordConfig(),
+		Mail: MailConfig{
+			Sender: MAIL_SENDER_SMTP,
+			From:   "riley@localhost",
+			SMTP: SMTPConfig{
+				Host: "localhost",
+				Port: 25,
+			},
+		},
 		Postgres: PostgresConfig{
 			Port:     5432,
 			Host:     "localhost",
@@ -91,14 +486,39 @@ func LoadConfig() *Config {
 				AccountName: "account",
 				AccountKey:  "key",
 				Container:   "container",
+				BlockSize:   4 << 20,
 			},
 		},
 	}
 }
 
+// defaultTestPasswordConfig hashes with cheaper argon2id parameters, so
+// that tests creating users stay fast
+func defaultTestPasswordConfig() PasswordConfig {
+	c := defaultPasswordConfig()
+	c.Hasher.Argon2Memory = 8 * 1024
+	c.Hasher.Argon2Time = 1
+	c.Hasher.Argon2Threads = 1
+
+	return c
+}
+
 func LoadTestConfig() *Config {
 	return &Config{
-		TokenSecret: "secret",
+		Token:               defaultTokenConfig(),
+		TrashRetention:      7 * 24 * time.Hour,
+		RateLimit:           defaultRateLimitConfig(),
+		Session:             defaultSessionConfig(),
+		OIDC:                defaultOIDCConfig(),
+		MFA:                 defaultTestMFAConfig(),
+		Account:             defaultAccountConfig(),
+		Lockout:             defaultLockoutConfig(),
+		LoginEventRetention: 90 * 24 * time.Hour,
+		Password:            defaultTestPasswordConfig(),
+		Mail: MailConfig{
+			Sender: MAIL_SENDER_MEMORY,
+			From:   "riley@localhost",
+		},
 		Postgres: PostgresConfig{
 			Port:     5432,
 			Host:     "localhost",
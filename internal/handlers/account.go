@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/auth"
+	"riley/internal/mail"
+	"riley/internal/models"
+)
+
+var (
+	errEmailAlreadyVerified = apperror.New(apperror.CodeConflict, "Email address is already verified")
+	errPasswordManagement   = apperror.New(apperror.CodeForbidden, "The password cannot be changed with an API key")
+	errVerificationAPIKey   = apperror.New(apperror.CodeForbidden, "Verification emails cannot be requested with an API key")
+)
+
+// VerifyEmail verifies the email of the user a verification link was sent
+// to
+//
+// The body is {"token": "..."}, with the token from the link
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	jsonBody := &struct {
+		Token string `json:"token"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	user, token, err := h.useAccountToken(models.ACCOUNT_TOKEN_PURPOSE_EMAIL_VERIFICATION, jsonBody.Token)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = user.VerifyEmail(token.Email, h.SQLDatabase)
+	if errors.Is(err, models.ErrUserNotFound) {
+		h.writeError(w, r, models.ErrInvalidAccountToken)
+		return
+	} else if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerificationEmail sends the caller a new email verification link
+func (h *Handler) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errVerificationAPIKey)
+	if !ok {
+		return
+	}
+
+	user, err := models.GetUserByID(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if user.IsEmailVerified() {
+		h.writeError(w, r, errEmailAlreadyVerified)
+		return
+	}
+
+	err = h.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RequestPasswordReset sends a password reset link to the email
+//
+// The body is {"email": "..."}. The response is the same whether or not
+// a user has the email, so that it cannot be used to find accounts
+func (h *Handler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	jsonBody := &struct {
+		Email string `json:"email"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	user, err := models.GetUserByEmail(strings.TrimSpace(jsonBody.Email), h.SQLDatabase)
+	if err == nil {
+		err = h.sendPasswordResetEmail(r.Context(), user)
+	}
+
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with a password reset link
+//
+// The body is {"token": "...", "password": "..."}. Every session of the
+// user is revoked, as the reset may be locking out someone who knew the
+// old password
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	jsonBody := &struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	// Checked before the token is used up, so that a rejected password
+	// can be retried with the same link
+	err = models.ValidatePassword(jsonBody.Password, h.Config.Password)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	user, token, err := h.useAccountToken(models.ACCOUNT_TOKEN_PURPOSE_PASSWORD_RESET, jsonBody.Token)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if token.Email != user.Email {
+		h.writeError(w, r, models.ErrInvalidAccountToken)
+		return
+	}
+
+	err = user.SetPassword(jsonBody.Password, h.Config.Password, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = models.InvalidateAccountTokens(user.ID, models.ACCOUNT_TOKEN_PURPOSE_PASSWORD_RESET, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	revoked, err := models.RevokeUserSessions(user.ID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.Revocations.Add(revoked...)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword changes the password of the caller
+//
+// The body is {"current_password": "...", "new_password": "..."}. The
+// other sessions of the caller are revoked
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errPasswordManagement)
+	if !ok {
+		return
+	}
+
+	jsonBody := &struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	user, err := models.GetUserByID(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = user.CheckPassword(jsonBody.CurrentPassword, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = user.SetPassword(jsonBody.NewPassword, h.Config.Password, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = models.InvalidateAccountTokens(user.ID, models.ACCOUNT_TOKEN_PURPOSE_PASSWORD_RESET, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	revoked, err := models.RevokeOtherUserSessions(user.ID, principal.SessionID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.Revocations.Add(revoked...)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) sendVerificationEmail(ctx context.Context, user models.User) error {
+	link, err := h.accountLink(models.ACCOUNT_TOKEN_PURPOSE_EMAIL_VERIFICATION, "/verify-email", h.Config.Account.VerificationTTL, user)
+	if err != nil {
+		return err
+	}
+
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: "Open the link below to verify your email address for riley:\n\n" +
+			link + "\n\n" +
+			"If you did not sign up, you can ignore this email.\n",
+	})
+}
+
+func (h *Handler) sendPasswordResetEmail(ctx context.Context, user models.User) error {
+	link, err := h.accountLink(models.ACCOUNT_TOKEN_PURPOSE_PASSWORD_RESET, "/reset-password", h.Config.Account.PasswordResetTTL, user)
+	if err != nil {
+		return err
+	}
+
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Open the link below to choose a new password for riley:\n\n" +
+			link + "\n\n" +
+			"If you did not ask for a password reset, you can ignore this email.\n",
+	})
+}
+
+// accountLink records a single use token for the purpose and returns the
+// link with it to send to the user
+func (h *Handler) accountLink(purpose string, path string, ttl time.Duration, user models.User) (string, error) {
+	tokenID, err := auth.NewTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().UTC().Add(ttl)
+
+	token, err := auth.GenerateAccountToken(purpose, expiresAt, user.ID, tokenID, h.Keyring)
+	if err != nil {
+		return "", err
+	}
+
+	err = models.CreateAccountToken(models.AccountToken{
+		ExpiresAt: expiresAt,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ID:        tokenID,
+		UserID:    user.ID,
+	}, h.SQLDatabase)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(h.Config.Account.BaseURL, "/") + path + "?" + url.Values{"token": {token}}.Encode(), nil
+}
+
+// useAccountToken validates a token sent by email for the purpose, marks
+// it as used and returns its user
+func (h *Handler) useAccountToken(purpose string, tokenString string) (models.User, models.AccountToken, error) {
+	userID, tokenID, err := auth.ParseAccountToken(purpose, tokenString, h.Keyring)
+	if err != nil {
+		return models.User{}, models.AccountToken{}, models.ErrInvalidAccountToken.WithCause(err)
+	}
+
+	token, err := models.UseAccountToken(tokenID, purpose, userID, h.SQLDatabase)
+	if err != nil {
+		return models.User{}, token, err
+	}
+
+	user, err := models.GetUserByID(userID, h.SQLDatabase)
+	if errors.Is(err, models.ErrUserNotFound) {
+		return user, token, models.ErrInvalidAccountToken
+	}
+
+	return user, token, err
+}
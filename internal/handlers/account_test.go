@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"riley/internal/mail"
+	"riley/internal/models"
+)
+
+var linkPattern = regexp.MustCompile(`http\S+`)
+
+// linkToken returns the token of the link in the last email sent to the
+// address
+func linkToken(t *testing.T, h *Handler, to string) string {
+	t.Helper()
+
+	message, ok := h.Mailer.(*mail.MemorySender).Last(to)
+	if !ok {
+		t.Fatal("no email sent to", to)
+	}
+
+	link, err := url.Parse(linkPattern.FindString(message.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return link.Query().Get("token")
+}
+
+func TestAccount(t *testing.T) {
+	h := createHandler()
+
+	post := func(handler http.HandlerFunc, body any, token string) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+			req = authenticate(t, h, req)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	email := "testaccount@example.com"
+
+	rr := post(h.Signup, map[string]string{"email": email, "password": "password123%A%"}, "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	signup := authResponse{}
+
+	err := json.Unmarshal(rr.Body.Bytes(), &signup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{ID: signup.User.ID}
+	defer user.Delete(false, h.SQLDatabase)
+
+	t.Run("verify email", func(t *testing.T) {
+		token := linkToken(t, h, email)
+
+		rr := post(h.VerifyEmail, map[string]string{"token": token}, "")
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusNoContent, rr.Body.String())
+		}
+
+		verified, err := models.GetUserByID(user.ID, h.SQLDatabase)
+		if err != nil || !verified.IsEmailVerified() {
+			t.Fatalf("user is not verified: %v", err)
+		}
+
+		rr = post(h.VerifyEmail, map[string]string{"token": token}, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("handler returned wrong status code for used token: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+
+		rr = post(h.ResendVerificationEmail, nil, signup.AccessToken)
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("handler returned wrong status code for verified user: got %v want %v", rr.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("password reset", func(t *testing.T) {
+		rr := post(h.RequestPasswordReset, map[string]string{"email": "testaccountmissing@example.com"}, "")
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code for unknown email: got %v want %v", rr.Code, http.StatusAccepted)
+		}
+
+		rr = post(h.RequestPasswordReset, map[string]string{"email": email}, "")
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+		}
+
+		token := linkToken(t, h, email)
+
+		rr = post(h.ResetPassword, map[string]string{"token": token, "password": "short"}, "")
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("handler returned wrong status code for invalid password: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+
+		// A verification token cannot reset the password
+		rr = post(h.ResetPassword, map[string]string{"token": signup.AccessToken, "password": "newPassword123%"}, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("handler returned wrong status code for access token: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+
+		rr = post(h.ResetPassword, map[string]string{"token": token, "password": "newPassword123%"}, "")
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusNoContent, rr.Body.String())
+		}
+
+		rr = post(h.Refresh, map[string]string{"refresh_token": signup.RefreshToken}, "")
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code for refresh after reset: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+
+		rr = post(h.Login, map[string]string{"email": email, "password": "newPassword123%"}, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code for login with new password: got %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("change password", func(t *testing.T) {
+		rr := post(h.Login, map[string]string{"email": email, "password": "newPassword123%"}, "")
+
+		login := authResponse{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &login)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr = post(h.ChangePassword, map[string]string{"current_password": "wrong", "new_password": "otherPassword123%"}, login.AccessToken)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code for wrong password: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+
+		rr = post(h.ChangePassword, map[string]string{"current_password": "newPassword123%", "new_password": "otherPassword123%"}, login.AccessToken)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusNoContent, rr.Body.String())
+		}
+
+		rr = post(h.Refresh, map[string]string{"refresh_token": login.RefreshToken}, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler revoked the current session: got %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+}
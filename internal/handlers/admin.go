@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/auth"
+	"riley/internal/handlers/middlewares"
+	"riley/internal/models"
+)
+
+var (
+	errInvalidUserID      = apperror.New(apperror.CodeBadRequest, "Invalid user ID")
+	errInvalidUserSearch  = apperror.New(apperror.CodeBadRequest, "Invalid user search")
+	errInvalidAuditFilter = apperror.New(apperror.CodeBadRequest, "Invalid audit log filter")
+	errAdminSelf          = apperror.New(apperror.CodeBadRequest, "Admins cannot suspend, delete or change the role of their own account")
+)
+
+type adminUserResponse struct {
+	CreatedAt       time.Time  `json:"created_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Email           string     `json:"email"`
+	Role            string     `json:"role"`
+	ID              uint64     `json:"id"`
+	StorageBytes    uint64     `json:"storage_bytes"`
+	Files           uint64     `json:"files"`
+	Texts           uint64     `json:"texts"`
+	TrashedItems    uint64     `json:"trashed_items"`
+	Active          bool       `json:"active"`
+}
+
+type auditEntryResponse struct {
+	CreatedAt    time.Time      `json:"created_at"`
+	ActorID      *uint64        `json:"actor_id"`
+	TargetUserID *uint64        `json:"target_user_id"`
+	Details      map[string]any `json:"details"`
+	ActorEmail   string         `json:"actor_email"`
+	Action       string         `json:"action"`
+	TargetEmail  string         `json:"target_email"`
+	IP           string         `json:"ip"`
+	ID           uint64         `json:"id"`
+}
+
+// ListUsers returns a page of users, newest first, with what they store
+//
+// The query parameters q (part of the email), role and active filter the
+// users; before and limit page through them. The response has next, the
+// before of the next page. Only admins can call it
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	_, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	search := models.UserSearch{
+		Query: query.Get("q"),
+		Role:  query.Get("role"),
+	}
+
+	var err error
+
+	if v := query.Get("active"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			h.writeError(w, r, errInvalidUserSearch.WithMessage("Invalid active"))
+			return
+		}
+
+		search.Active = &active
+	}
+
+	if v := query.Get("before"); v != "" {
+		search.Before, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			h.writeError(w, r, errInvalidUserSearch.WithMessage("Invalid before"))
+			return
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		search.Limit, err = strconv.Atoi(v)
+		if err != nil || search.Limit < 1 {
+			h.writeError(w, r, errInvalidUserSearch.WithMessage("Invalid limit"))
+			return
+		}
+	}
+
+	page, err := models.SearchUsers(search, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Users []adminUserResponse `json:"users"`
+		Total uint64              `json:"total"`
+		Next  uint64              `json:"next,omitempty"`
+	}{
+		Users: make([]adminUserResponse, 0, len(page.Users)),
+		Total: page.Total,
+		Next:  page.Next,
+	}
+
+	for _, user := range page.Users {
+		response.Users = append(response.Users, newAdminUserResponse(user))
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// GetUser returns a user with what they store
+//
+// Only admins can call it
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	_, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, newAdminUserResponse(user))
+}
+
+// SuspendUser stops a user from logging in and revokes their sessions,
+// without deleting what they store
+//
+// Only admins can call it, for other users
+func (h *Handler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserActive(w, r, false)
+}
+
+// UnsuspendUser lets a suspended user log in again
+//
+// Only admins can call it, for other users
+func (h *Handler) UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserActive(w, r, true)
+}
+
+func (h *Handler) setUserActive(w http.ResponseWriter, r *http.Request, active bool) {
+	principal, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == principal.UserID {
+		h.writeError(w, r, errAdminSelf)
+		return
+	}
+
+	err := user.SetActive(active, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	action := models.AUDIT_ACTION_USER_UNSUSPEND
+	if !active {
+		action = models.AUDIT_ACTION_USER_SUSPEND
+
+		err = h.revokeUserSessions(user.ID)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+	}
+
+	err = h.audit(r, principal, action, &user.User, nil)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, newAdminUserResponse(user))
+}
+
+// ForcePasswordReset replaces the password of a user with a random one,
+// revokes their sessions and sends them a password reset link
+//
+// Only admins can call it
+func (h *Handler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := user.ScramblePassword(h.Config.Password.Hasher, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = h.revokeUserSessions(user.ID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = h.audit(r, principal, models.AUDIT_ACTION_USER_PASSWORD_RESET, &user.User, nil)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = h.sendPasswordResetEmail(r.Context(), user.User)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// SetUserRole changes the role of a user
+//
+// The body is {"role": "admin"}, with the role user, admin or readonly.
+// Only admins can call it, for other users
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == principal.UserID {
+		h.writeError(w, r, errAdminSelf)
+		return
+	}
+
+	jsonBody := &struct {
+		Role string `json:"role"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	previous := user.Role
+
+	err = user.SetRole(jsonBody.Role, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = h.audit(r, principal, models.AUDIT_ACTION_USER_ROLE, &user.User, map[string]any{
+		"from": previous,
+		"to":   user.Role,
+	})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, newAdminUserResponse(user))
+}
+
+// DeleteUser permanently deletes a user with all their files and texts,
+// including their content in storage
+//
+// Only admins can call it, for other users
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == principal.UserID {
+		h.writeError(w, r, errAdminSelf)
+		return
+	}
+
+	// The sessions are deleted with the user, so their access tokens are
+	// revoked first
+	err := h.revokeUserSessions(user.ID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = user.Purge(r.Context(), h.Storage, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = h.audit(r, principal, models.AUDIT_ACTION_USER_DELETE, &user.User, map[string]any{
+		"storage_bytes": user.StorageBytes,
+		"files":         user.Files,
+		"texts":         user.Texts,
+	})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAuditLog returns the actions admins took, newest first
+//
+// The query parameters action, actor_id and target_user_id filter the
+// entries; before and limit page through them. Only admins can call it
+func (h *Handler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	_, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.AuditFilter{Action: query.Get("action")}
+
+	var err error
+
+	for name, value := range map[string]*uint64{
+		"actor_id":       &filter.ActorID,
+		"target_user_id": &filter.TargetUserID,
+		"before":         &filter.Before,
+	} {
+		if v := query.Get(name); v != "" {
+			*value, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				h.writeError(w, r, errInvalidAuditFilter.WithMessage("Invalid "+name))
+				return
+			}
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 1 {
+			h.writeError(w, r, errInvalidAuditFilter.WithMessage("Invalid limit"))
+			return
+		}
+	}
+
+	entries, err := models.GetAuditEntries(filter, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Entries []auditEntryResponse `json:"entries"`
+	}{
+		Entries: make([]auditEntryResponse, 0, len(entries)),
+	}
+
+	for _, entry := range entries {
+		response.Entries = append(response.Entries, auditEntryResponse{
+			CreatedAt:    entry.CreatedAt,
+			ActorID:      entry.ActorID,
+			TargetUserID: entry.TargetUserID,
+			Details:      entry.Details,
+			ActorEmail:   entry.ActorEmail,
+			Action:       entry.Action,
+			TargetEmail:  entry.TargetEmail,
+			IP:           entry.IP,
+			ID:           entry.ID,
+		})
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// adminTargetUser returns the user of the id path value
+//
+// If there is none, the error response is written and false is returned
+func (h *Handler) adminTargetUser(w http.ResponseWriter, r *http.Request) (models.UserSummary, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.writeError(w, r, errInvalidUserID.WithCause(err))
+		return models.UserSummary{}, false
+	}
+
+	user, err := models.GetUserSummary(id, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return user, false
+	}
+
+	return user, true
+}
+
+// revokeUserSessions revokes every session of the user
+func (h *Handler) revokeUserSessions(userID uint64) error {
+	revoked, err := models.RevokeUserSessions(userID, h.SQLDatabase)
+	if err != nil {
+		return err
+	}
+
+	h.Revocations.Add(revoked...)
+
+	return nil
+}
+
+// audit records an action the admin took, on the target user if it is
+// not nil
+func (h *Handler) audit(r *http.Request, principal auth.Principal, action string, target *models.User, details map[string]any) error {
+	entry := models.AuditEntry{
+		ActorID: &principal.UserID,
+		Details: details,
+		Action:  action,
+		IP:      middlewares.GetClientIP(r.Context()),
+	}
+
+	if target != nil {
+		entry.TargetUserID = &target.ID
+		entry.TargetEmail = target.Email
+	}
+
+	return models.CreateAuditEntry(entry, h.SQLDatabase)
+}
+
+func newAdminUserResponse(user models.UserSummary) adminUserResponse {
+	return adminUserResponse{
+		CreatedAt:       user.CreatedAt,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		Email:           user.Email,
+		Role:            user.Role,
+		ID:              user.ID,
+		StorageBytes:    user.StorageBytes,
+		Files:           user.Files,
+		Texts:           user.Texts,
+		TrashedItems:    user.TrashedItems,
+		Active:          user.Active,
+	}
+}
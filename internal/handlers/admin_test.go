@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+func TestAdminUsers(t *testing.T) {
+	h := createHandler()
+
+	admin, err := models.UserCreate("testadminusers@example.com", "password123%A%", h.Config.Password, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = admin.Delete(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = admin.SetRole(models.USER_ROLE_ADMIN, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := models.UserCreate("testadminuserstarget@example.com", "password123%A%", h.Config.Password, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("admin")
+
+	f := models.File{
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+		Name:      "admin.txt",
+		Size:      uint64(len(content)),
+		UserID:    user.ID,
+	}
+
+	_, err = f.CreateFile(context.Background(), bytes.NewReader(content), h.Storage, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := auth.GenerateToken(time.Now().UTC().Add(time.Hour), admin.ID, h.Keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.GenerateToken(time.Now().UTC().Add(time.Hour), user.ID, h.Keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := strconv.FormatUint(user.ID, 10)
+
+	request := func(handler http.HandlerFunc, method string, target string, body []byte, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.SetPathValue("id", id)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, authenticate(t, h, req))
+
+		return rr
+	}
+
+	t.Run("requires admin", func(t *testing.T) {
+		rr := request(h.ListUsers, "GET", "/admin/users", nil, userToken)
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		rr := request(h.ListUsers, "GET", "/admin/users?q=testadminuserstarget&limit=10", nil, adminToken)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		response := struct {
+			Users []adminUserResponse `json:"users"`
+			Total uint64              `json:"total"`
+		}{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.Total != 1 || len(response.Users) != 1 || response.Users[0].ID != user.ID {
+			t.Fatalf("handler returned wrong users: got %+v", response)
+		}
+
+		if response.Users[0].Files != 1 || response.Users[0].StorageBytes != uint64(len(content)) {
+			t.Fatalf("handler returned wrong usage: got %+v", response.Users[0])
+		}
+	})
+
+	t.Run("cannot suspend self", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.SetPathValue("id", strconv.FormatUint(admin.ID, 10))
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+
+		rr := httptest.NewRecorder()
+		h.SuspendUser(rr, authenticate(t, h, req))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("suspend", func(t *testing.T) {
+		rr := request(h.SuspendUser, "POST", "/", nil, adminToken)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+userToken)
+
+		_, err := auth.NewTokenAuthenticator(h.Keyring, h.Revocations, h.SQLDatabase).Authenticate(req)
+		if err == nil {
+			t.Fatal("suspended user was authenticated")
+		}
+
+		rr = request(h.UnsuspendUser, "POST", "/", nil, adminToken)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("role", func(t *testing.T) {
+		rr := request(h.SetUserRole, "PUT", "/", []byte(`{"role": "readonly"}`), adminToken)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		rr = request(h.SetUserRole, "PUT", "/", []byte(`{"role": "owner"}`), adminToken)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("force password reset", func(t *testing.T) {
+		rr := request(h.ForcePasswordReset, "POST", "/", nil, adminToken)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+		}
+
+		userID, err := models.UserCheckLogin(user.Email, "password123%A%", h.Config.Password.Hasher, h.SQLDatabase)
+		if err != nil || userID != 0 {
+			t.Fatalf("old password still works: got %d, %v", userID, err)
+		}
+
+		linkToken(t, h, user.Email)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		rr := request(h.DeleteUser, "DELETE", "/", nil, adminToken)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+
+		_, err := models.GetUserSummary(user.ID, h.SQLDatabase)
+		if !errors.Is(err, models.ErrUserNotFound) {
+			t.Fatalf("deleted user was found: %v", err)
+		}
+
+		files, err := models.GetFilesByUserID(user.ID, h.SQLDatabase)
+		if err != nil || len(files) != 0 {
+			t.Fatalf("files of deleted user were found: %v, %v", files, err)
+		}
+	})
+
+	t.Run("audit log", func(t *testing.T) {
+		rr := request(h.ListAuditLog, "GET", "/admin/audit?target_user_id="+id, nil, adminToken)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		response := struct {
+			Entries []auditEntryResponse `json:"entries"`
+		}{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{
+			models.AUDIT_ACTION_USER_DELETE,
+			models.AUDIT_ACTION_USER_PASSWORD_RESET,
+			models.AUDIT_ACTION_USER_ROLE,
+			models.AUDIT_ACTION_USER_UNSUSPEND,
+			models.AUDIT_ACTION_USER_SUSPEND,
+		}
+
+		if len(response.Entries) != len(want) {
+			t.Fatalf("handler returned wrong entries: got %+v", response.Entries)
+		}
+
+		for i, entry := range response.Entries {
+			if entry.Action != want[i] || entry.TargetEmail != user.Email || entry.ActorEmail != admin.Email {
+				t.Fatalf("handler returned wrong entry %d: got %+v want %s", i, entry, want[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+var (
+	errInvalidAPIKeyID  = apperror.New(apperror.CodeBadRequest, "Invalid API key ID")
+	errAPIKeyManagement = apperror.New(apperror.CodeForbidden, "API keys cannot be managed with an API key")
+)
+
+type apiKeyResponse struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	ID         uint64     `json:"id"`
+}
+
+// CreateAPIKey creates an API key for the caller
+//
+// The body is {"name": "...", "scopes": ["..."], "expires_at": "..."},
+// with expires_at in RFC 3339 and optional. The key is only returned in
+// this response
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errAPIKeyManagement)
+	if !ok {
+		return
+	}
+
+	jsonBody := &struct {
+		ExpiresAt string   `json:"expires_at"`
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	var expiresAt *time.Time
+	if jsonBody.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, jsonBody.ExpiresAt)
+		if err != nil {
+			h.writeError(w, r, errInvalidExpiresAt.WithCause(err))
+			return
+		}
+
+		expiresAt = &t
+	}
+
+	key, prefix, keyHash, err := auth.NewAPIKey()
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	apiKey, err := models.CreateAPIKey(models.APIKey{
+		ExpiresAt: expiresAt,
+		Name:      jsonBody.Name,
+		Prefix:    prefix,
+		Scopes:    jsonBody.Scopes,
+		UserID:    principal.UserID,
+	}, keyHash, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := newAPIKeyResponse(apiKey)
+	response.Key = key
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.writeJSON(w, http.StatusCreated, response)
+}
+
+// ListAPIKeys returns the caller's API keys, without the keys themselves
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errAPIKeyManagement)
+	if !ok {
+		return
+	}
+
+	keys, err := models.GetUserAPIKeys(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		APIKeys []apiKeyResponse `json:"api_keys"`
+	}{
+		APIKeys: make([]apiKeyResponse, 0, len(keys)),
+	}
+
+	for _, key := range keys {
+		response.APIKeys = append(response.APIKeys, newAPIKeyResponse(key))
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// DeleteAPIKey deletes one of the caller's API keys
+func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errAPIKeyManagement)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.writeError(w, r, errInvalidAPIKeyID.WithCause(err))
+		return
+	}
+
+	err = models.DeleteAPIKey(id, principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newAPIKeyResponse(key models.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		ID:         key.ID,
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+func TestAPIKeys(t *testing.T) {
+	h := createHandler()
+
+	user, err := models.UserCreate("testapikeys@example.com", "password123%A%", h.Config.Password, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = user.Delete(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	token, err := auth.GenerateToken(time.Now().UTC().Add(time.Hour), user.ID, h.Keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(handler http.HandlerFunc, method string, id string, body []byte, credentials string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/api-keys/"+id, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.SetPathValue("id", id)
+		req.Header.Set("Authorization", "Bearer "+credentials)
+
+		principal, err := auth.NewAPIKeyAuthenticator(h.SQLDatabase, h.Logger).Authenticate(req)
+		if errors.Is(err, auth.ErrNoCredentials) {
+			req = authenticate(t, h, req)
+		} else if err != nil {
+			t.Fatal(err)
+		} else {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	rr := request(h.CreateAPIKey, "POST", "", []byte(`{"name": "ci", "scopes": ["files:write", "files:read"]}`), token)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+
+	created := apiKeyResponse{}
+
+	err = json.Unmarshal(rr.Body.Bytes(), &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(created.Key, auth.APIKeyPrefix) || !strings.HasPrefix(created.Key, created.Prefix) {
+		t.Fatalf("handler returned wrong key: got %+v", created)
+	}
+
+	t.Run("authenticate", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+created.Key)
+
+		principal, err := auth.NewAPIKeyAuthenticator(h.SQLDatabase, h.Logger).Authenticate(req)
+		if err != nil {
+			t.Fatal("Authenticating with API key: Wanted nil, got", err)
+		}
+
+		if principal.UserID != user.ID || principal.AuthMethod != auth.AUTH_METHOD_API_KEY {
+			t.Fatalf("Authenticating with API key: got %+v", principal)
+		}
+
+		resource := auth.Resource{Kind: models.ITEM_KIND_TEXT, OwnerID: user.ID}
+
+		decision, err := auth.Authorize(principal, auth.ActionWrite, resource, h.SQLDatabase)
+		if err != nil || decision.Reason != auth.ReasonMissingScope {
+			t.Error("Authorizing text write without scope: Wanted", auth.ReasonMissingScope, "got", decision.Reason, err)
+		}
+
+		resource.Kind = models.ITEM_KIND_FILE
+
+		decision, err = auth.Authorize(principal, auth.ActionWrite, resource, h.SQLDatabase)
+		if err != nil || !decision.Allowed {
+			t.Error("Authorizing file write with scope: Wanted allowed, got", decision.Reason, err)
+		}
+	})
+
+	t.Run("manage with api key", func(t *testing.T) {
+		rr := request(h.ListAPIKeys, "GET", "", nil, created.Key)
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("logout all with api key", func(t *testing.T) {
+		rr := request(h.LogoutAll, "POST", "", nil, created.Key)
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+
+		rr = request(h.ListSessions, "GET", "", nil, created.Key)
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+
+		rr = request(h.ListLogins, "GET", "", nil, created.Key)
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("invalid scope", func(t *testing.T) {
+		rr := request(h.CreateAPIKey, "POST", "", []byte(`{"name": "ci", "scopes": ["everything"]}`), token)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("list and delete", func(t *testing.T) {
+		rr := request(h.ListAPIKeys, "GET", "", nil, token)
+		if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), created.Key) {
+			t.Fatalf("handler returned wrong response: got %v %s", rr.Code, rr.Body.String())
+		}
+
+		id := strconv.FormatUint(created.ID, 10)
+
+		rr = request(h.DeleteAPIKey, "DELETE", id, nil, token)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+created.Key)
+
+		_, err := auth.NewAPIKeyAuthenticator(h.SQLDatabase, h.Logger).Authenticate(req)
+		if err == nil {
+			t.Error("Authenticating with deleted API key: Wanted error, got nil")
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"riley/internal/auth"
+	"riley/internal/handlers/middlewares"
+	"riley/internal/models"
+)
+
+const tokenTypeBearer = "Bearer"
+
+type authUser struct {
+	CreatedAt time.Time `json:"created_at"`
+	Email     string    `json:"email"`
+	ID        uint64    `json:"id"`
+}
+
+type authResponse struct {
+	ExpiresAt             time.Time `json:"expires_at"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+	AccessToken           string    `json:"access_token"`
+	RefreshToken          string    `json:"refresh_token"`
+	TokenType             string    `json:"token_type"`
+	User                  authUser  `json:"user"`
+}
+
+// sessionTokens are the tokens issued when a session starts or is
+// refreshed; the access token is signed once the session ID is known
+type sessionTokens struct {
+	AccessTokenExpiresAt time.Time
+	AccessTokenID        string
+	RefreshToken         string
+	RefreshTokenHash     string
+}
+
+func (h *Handler) newSessionTokens() (sessionTokens, error) {
+	tokenID, err := auth.NewTokenID()
+	if err != nil {
+		return sessionTokens{}, err
+	}
+
+	refreshToken, refreshTokenHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return sessionTokens{}, err
+	}
+
+	return sessionTokens{
+		AccessTokenExpiresAt: time.Now().UTC().Add(h.Config.Session.AccessTokenTTL),
+		AccessTokenID:        tokenID,
+		RefreshToken:         refreshToken,
+		RefreshTokenHash:     refreshTokenHash,
+	}, nil
+}
+
+// startSession creates a session for the user and writes its tokens
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, status int, user models.User) {
+	response, err := h.createSession(r, user)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.writeJSON(w, status, response)
+}
+
+// createSession creates a session for the user and returns the body
+// written by startSession
+func (h *Handler) createSession(r *http.Request, user models.User) (authResponse, error) {
+	tokens, err := h.newSessionTokens()
+	if err != nil {
+		return authResponse{}, err
+	}
+
+	session, err := models.CreateSession(models.Session{
+		ExpiresAt:            time.Now().UTC().Add(h.Config.Session.RefreshTokenTTL),
+		AccessTokenExpiresAt: tokens.AccessTokenExpiresAt,
+		AccessTokenID:        tokens.AccessTokenID,
+		UserAgent:            r.UserAgent(),
+		IP:                   middlewares.GetClientIP(r.Context()),
+		UserID:               user.ID,
+	}, tokens.RefreshTokenHash, h.SQLDatabase)
+	if err != nil {
+		return authResponse{}, err
+	}
+
+	return h.newAuthResponse(user, session, tokens)
+}
+
+// writeAuthResponse signs the access token of the session and writes it,
+// together with the refresh token and the user, as the JSON body shared
+// by login, signup and refresh
+func (h *Handler) writeAuthResponse(w http.ResponseWriter, r *http.Request, status int, user models.User, session models.Session, tokens sessionTokens) {
+	response, err := h.newAuthResponse(user, session, tokens)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.writeJSON(w, status, response)
+}
+
+func (h *Handler) newAuthResponse(user models.User, session models.Session, tokens sessionTokens) (authResponse, error) {
+	token, err := auth.GenerateSessionToken(tokens.AccessTokenExpiresAt, user.ID, session.ID, tokens.AccessTokenID, h.Keyring)
+	if err != nil {
+		return authResponse{}, err
+	}
+
+	return authResponse{
+		ExpiresAt:             tokens.AccessTokenExpiresAt,
+		RefreshTokenExpiresAt: session.ExpiresAt,
+		AccessToken:           token,
+		RefreshToken:          tokens.RefreshToken,
+		TokenType:             tokenTypeBearer,
+		User: authUser{
+			CreatedAt: user.CreatedAt,
+			Email:     user.Email,
+			ID:        user.ID,
+		},
+	}, nil
+}
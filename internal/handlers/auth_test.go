@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+func TestSignupAndLogin(t *testing.T) {
+	h := createHandler()
+
+	credentials := []byte(`{"email": "testsignuplogin@example.com", "password": "password123%A%"}`)
+
+	post := func(handler http.HandlerFunc, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	rr := post(h.Signup, credentials)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	signup := authResponse{}
+
+	err := json.Unmarshal(rr.Body.Bytes(), &signup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		user := models.User{ID: signup.User.ID}
+
+		err = user.Delete(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	t.Run("signup response", func(t *testing.T) {
+		if signup.TokenType != "Bearer" || signup.User.Email != "testsignuplogin@example.com" {
+			t.Fatalf("handler returned wrong body: got %+v", signup)
+		}
+
+		userID, err := auth.GetUserIDFromToken(signup.AccessToken, h.Keyring)
+		if err != nil || userID != signup.User.ID {
+			t.Fatalf("handler returned invalid token: %v", err)
+		}
+	})
+
+	t.Run("duplicate signup", func(t *testing.T) {
+		rr := post(h.Signup, credentials)
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+		}
+
+		response := struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil || response.Error.Code != "conflict" {
+			t.Fatalf("handler returned wrong error body: %s", rr.Body.String())
+		}
+	})
+
+	t.Run("login", func(t *testing.T) {
+		rr := post(h.Login, credentials)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		login := authResponse{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &login)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if login.User.ID != signup.User.ID || login.AccessToken == "" {
+			t.Fatalf("handler returned wrong body: got %+v", login)
+		}
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		rr := post(h.Refresh, []byte(`{"refresh_token": "`+signup.RefreshToken+`"}`))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		refresh := authResponse{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &refresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if refresh.RefreshToken == signup.RefreshToken || refresh.AccessToken == signup.AccessToken {
+			t.Fatalf("handler did not rotate tokens: got %+v", refresh)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signup.AccessToken)
+
+		_, err = auth.NewTokenAuthenticator(h.Keyring, h.Revocations, h.SQLDatabase).Authenticate(req)
+		if !errors.Is(err, auth.ErrTokenRevoked) {
+			t.Fatalf("replaced access token: wanted %v, got %v", auth.ErrTokenRevoked, err)
+		}
+
+		// Reusing a rotated refresh token revokes the whole session
+		rr = post(h.Refresh, []byte(`{"refresh_token": "`+signup.RefreshToken+`"}`))
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code for reused token: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+
+		rr = post(h.Refresh, []byte(`{"refresh_token": "`+refresh.RefreshToken+`"}`))
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code after reuse: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		rr := post(h.Login, credentials)
+
+		login := authResponse{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &login)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("POST", "/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		req = authenticate(t, h, req)
+
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(h.Logout).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+
+		_, err = auth.NewTokenAuthenticator(h.Keyring, h.Revocations, h.SQLDatabase).Authenticate(req)
+		if !errors.Is(err, auth.ErrTokenRevoked) {
+			t.Fatalf("access token after logout: wanted %v, got %v", auth.ErrTokenRevoked, err)
+		}
+
+		rr = post(h.Refresh, []byte(`{"refresh_token": "`+login.RefreshToken+`"}`))
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code for refresh after logout: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	})
+}
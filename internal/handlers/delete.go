@@ -1,7 +1,71 @@
 package handlers
 
-import "net/http"
+import (
+	"net/http"
 
+	"riley/internal/apperror"
+	"riley/internal/models"
+)
+
+var errMissingHashes = apperror.New(apperror.CodeValidationFailed, "At least one hash is required")
+
+type hashesRequest struct {
+	Hash   string   `json:"hash"`
+	Hashes []string `json:"hashes"`
+}
+
+// Delete moves one or more of the caller's files and texts to the trash
+//
+// The body is either {"hash": "..."} or {"hashes": ["...", "..."]}; if any
+// hash is not owned by the caller nothing is deleted
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
-	// ...
+	h.updateItems(w, r, func(userID uint64, hashes []string) error {
+		return models.TrashItems(userID, hashes, h.SQLDatabase)
+	})
+}
+
+// Restore takes one or more of the caller's items out of the trash, as
+// long as they were deleted within the retention window
+func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	h.updateItems(w, r, func(userID uint64, hashes []string) error {
+		return models.RestoreItems(userID, hashes, h.Config.TrashRetention, h.SQLDatabase)
+	})
+}
+
+// Trash lists the caller's deleted items, accepting the same query
+// parameters as List
+func (h *Handler) Trash(w http.ResponseWriter, r *http.Request) {
+	h.listItems(w, r, true)
+}
+
+func (h *Handler) updateItems(w http.ResponseWriter, r *http.Request, update func(userID uint64, hashes []string) error) {
+	principal, ok := h.principal(w, r)
+	if !ok {
+		return
+	}
+
+	jsonBody := &hashesRequest{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if jsonBody.Hash != "" {
+		jsonBody.Hashes = append(jsonBody.Hashes, jsonBody.Hash)
+	}
+
+	if len(jsonBody.Hashes) == 0 {
+		h.writeError(w, r, errMissingHashes)
+		return
+	}
+
+	err = update(principal.UserID, jsonBody.Hashes)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -1,7 +1,65 @@
 package handlers
 
-import "net/http"
+import (
+	"fmt"
+	"mime"
+	"net/http"
 
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+// Download streams a file to its owner, or to users it is shared with
+//
+// If the storage backend hands out presigned URLs, the client is
+// redirected to download the file from it directly instead
+//
+// Range, If-Range, If-None-Match and If-Modified-Since are handled by
+// http.ServeContent, using the file hash as a strong ETag
 func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
-	// ...
+	principal, ok := h.principal(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := models.GetFileByHash(r.PathValue("hash"), h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	resource := auth.Resource{Kind: models.ITEM_KIND_FILE, Hash: file.Hash, OwnerID: file.UserID}
+	if !h.authorize(w, r, principal, auth.ActionRead, resource, models.ErrFileNotFound) {
+		return
+	}
+
+	if file.IsExpired() {
+		h.writeError(w, r, models.ErrFileExpired)
+		return
+	}
+
+	presignedURL, err := file.PresignedURL(r.Context(), h.Storage)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if presignedURL != "" {
+		http.Redirect(w, r, presignedURL, http.StatusFound)
+		return
+	}
+
+	content, err := file.Open(r.Context(), h.Storage)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, file.Hash))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": file.Name,
+	}))
+
+	http.ServeContent(w, r, file.Name, file.UpdatedAt, content)
 }
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+func TestDownload(t *testing.T) {
+	h := createHandler()
+
+	user, err := models.UserCreate("testdownload@example.com", "password123%A%", h.Config.Password, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = user.Delete(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	other, err := models.UserCreate("testdownloadother@example.com", "password123%A%", h.Config.Password, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = other.Delete(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	content := []byte("download test content")
+
+	f := models.File{
+		Name:      "download.txt",
+		Size:      uint64(len(content)),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+	}
+
+	file, err := f.CreateFile(context.Background(), bytes.NewReader(content), h.Storage, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = file.Delete(context.Background(), h.Storage, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	token, err := auth.GenerateToken(time.Now().UTC().Add(time.Hour), user.ID, h.Keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherToken, err := auth.GenerateToken(time.Now().UTC().Add(time.Hour), other.ID, h.Keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	download := func(token string, headers map[string]string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/download/"+file.Hash, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.SetPathValue("hash", file.Hash)
+		req.Header.Set("Authorization", token)
+		req = authenticate(t, h, req)
+
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.Download).ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	t.Run("full download", func(t *testing.T) {
+		rr := download(token, nil)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		if rr.Body.String() != string(content) {
+			t.Fatalf("handler returned wrong body: got %q", rr.Body.String())
+		}
+
+		if rr.Header().Get("ETag") != fmt.Sprintf(`"%s"`, file.Hash) {
+			t.Fatalf("handler returned wrong ETag: got %s", rr.Header().Get("ETag"))
+		}
+
+		if rr.Header().Get("Content-Disposition") != `attachment; filename=download.txt` {
+			t.Fatalf("handler returned wrong Content-Disposition: got %s", rr.Header().Get("Content-Disposition"))
+		}
+	})
+
+	t.Run("range download", func(t *testing.T) {
+		rr := download(token, map[string]string{"Range": "bytes=0-7"})
+
+		if rr.Code != http.StatusPartialContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusPartialContent)
+		}
+
+		if rr.Body.String() != "download" {
+			t.Fatalf("handler returned wrong body: got %q", rr.Body.String())
+		}
+	})
+
+	t.Run("if-none-match", func(t *testing.T) {
+		rr := download(token, map[string]string{"If-None-Match": fmt.Sprintf(`"%s"`, file.Hash)})
+
+		if rr.Code != http.StatusNotModified {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotModified)
+		}
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		rr := download(otherToken, nil)
+
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
@@ -3,12 +3,103 @@ package handlers
 import (
 	"database/sql"
 	"log/slog"
+	"net/http"
 
+	"riley/internal/auth"
 	"riley/internal/config"
+	"riley/internal/mail"
+	"riley/internal/models"
+	"riley/internal/oidc"
+	"riley/internal/storage"
 )
 
 type Handler struct {
 	SQLDatabase *sql.DB
 	Config      *config.Config
 	Logger      *slog.Logger
+	Keyring     *auth.Keyring
+	Revocations *auth.RevocationList
+	// OIDC is the identity provider users can log in with, or nil if
+	// OIDC login is disabled
+	OIDC *oidc.Provider
+	// Cipher encrypts the TOTP secrets of users
+	Cipher *auth.SecretCipher
+	Mailer mail.Sender
+	// Storage holds the file content, built from Config.Storage
+	Storage storage.StorageInterface
+}
+
+// principal returns the principal set by the authentication middleware
+//
+// If there is none, the error response is written and false is returned
+func (h *Handler) principal(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	principal, ok := auth.GetPrincipal(r.Context())
+	if !ok {
+		h.writeError(w, r, errUnauthorized)
+	}
+
+	return principal, ok
+}
+
+// tokenPrincipal returns the principal of a request that must not be
+// authenticated with an API key, such as one managing credentials, so
+// that a leaked key cannot be used to take over the account
+//
+// If it is, the forbidden error response is written and false is returned
+func (h *Handler) tokenPrincipal(w http.ResponseWriter, r *http.Request, forbidden error) (auth.Principal, bool) {
+	principal, ok := h.principal(w, r)
+	if !ok {
+		return principal, false
+	}
+
+	if principal.AuthMethod == auth.AUTH_METHOD_API_KEY {
+		h.writeError(w, r, forbidden)
+		return principal, false
+	}
+
+	return principal, true
+}
+
+// adminPrincipal returns the principal of an admin
+//
+// If the caller is not an admin, the error response is written and false
+// is returned
+func (h *Handler) adminPrincipal(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	principal, ok := h.principal(w, r)
+	if !ok {
+		return principal, false
+	}
+
+	if !principal.HasRole(models.USER_ROLE_ADMIN) {
+		h.writeError(w, r, errForbidden)
+		return principal, false
+	}
+
+	return principal, true
+}
+
+// authorize checks that the principal may perform the action on the
+// resource
+//
+// If not, the error response is written and false is returned; resources
+// the principal cannot see are reported with notFound
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, principal auth.Principal, action auth.Action, resource auth.Resource, notFound error) bool {
+	decision, err := auth.Authorize(principal, action, resource, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return false
+	}
+
+	switch {
+	case decision.Allowed:
+		return true
+	case decision.Reason == auth.ReasonNotFound:
+		h.writeError(w, r, notFound)
+	case decision.Reason == auth.ReasonMissingScope:
+		h.writeError(w, r, errMissingScope)
+	default:
+		h.writeError(w, r, errForbidden)
+	}
+
+	return false
 }
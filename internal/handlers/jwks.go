@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// JWKS publishes the public keys access tokens are signed with, so that
+// other services can verify them
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	h.writeJSON(w, http.StatusOK, h.Keyring.JWKS())
+}
@@ -1,7 +1,148 @@
 package handlers
 
-import "net/http"
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+type listItem struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"`
+	Kind      string     `json:"kind"`
+	Hash      string     `json:"hash"`
+	Name      string     `json:"name"`
+	Size      uint64     `json:"size"`
+	Expired   bool       `json:"expired"`
+}
+
+type listResponse struct {
+	Items      []listItem `json:"items"`
+	NextCursor string     `json:"next_cursor"`
+	Total      uint64     `json:"total"`
+	TotalFiles uint64     `json:"total_files"`
+	TotalTexts uint64     `json:"total_texts"`
+}
+
+// List returns a page of the caller's files and texts
+//
+// Supported query parameters are kind, name, status (active or expired),
+// created_after and created_before (RFC 3339), sort (created_at,
+// expires_at, size or name), order (asc or desc), limit and cursor
 func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
-	// ...
+	h.listItems(w, r, false)
+}
+
+func (h *Handler) listItems(w http.ResponseWriter, r *http.Request, deleted bool) {
+	principal, ok := h.principal(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	opts.Deleted = deleted
+
+	// API keys only list the kinds they have a read scope for
+	kinds := auth.ReadableKinds(principal)
+	switch {
+	case len(kinds) == 0 || (opts.Kind != "" && !slices.Contains(kinds, opts.Kind)):
+		h.writeError(w, r, errMissingScope)
+		return
+	case len(kinds) == 1:
+		opts.Kind = kinds[0]
+	}
+
+	page, err := models.ListItems(principal.UserID, opts, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := listResponse{
+		Items:      make([]listItem, 0, len(page.Items)),
+		NextCursor: page.NextCursor,
+		Total:      page.Total,
+		TotalFiles: page.TotalFiles,
+		TotalTexts: page.TotalTexts,
+	}
+
+	for _, item := range page.Items {
+		li := listItem{
+			CreatedAt: item.CreatedAt,
+			ExpiresAt: item.ExpiresAt,
+			DeletedAt: item.DeletedAt,
+			Kind:      item.Kind,
+			Hash:      item.Hash,
+			Name:      item.Name,
+			Size:      item.Size,
+			Expired:   item.IsExpired(),
+		}
+
+		if item.DeletedAt != nil {
+			purgeAt := item.DeletedAt.Add(h.Config.TrashRetention)
+			li.PurgeAt = &purgeAt
+		}
+
+		response.Items = append(response.Items, li)
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+func parseListOptions(r *http.Request) (models.ListOptions, error) {
+	query := r.URL.Query()
+
+	opts := models.ListOptions{
+		Kind:   query.Get("kind"),
+		Name:   query.Get("name"),
+		Status: query.Get("status"),
+		SortBy: query.Get("sort"),
+		Cursor: query.Get("cursor"),
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, models.ErrInvalidListOptions.WithMessage("Order must be asc or desc")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			return opts, models.ErrInvalidListOptions.WithMessage("Limit must be a positive integer")
+		}
+
+		opts.Limit = l
+	}
+
+	var err error
+
+	if createdAfter := query.Get("created_after"); createdAfter != "" {
+		opts.CreatedAfter, err = time.Parse(time.RFC3339, createdAfter)
+		if err != nil {
+			return opts, models.ErrInvalidListOptions.WithMessage("created_after must be an RFC 3339 time")
+		}
+	}
+
+	if createdBefore := query.Get("created_before"); createdBefore != "" {
+		opts.CreatedBefore, err = time.Parse(time.RFC3339, createdBefore)
+		if err != nil {
+			return opts, models.ErrInvalidListOptions.WithMessage("created_before must be an RFC 3339 time")
+		}
+	}
+
+	return opts, nil
 }
@@ -1,11 +1,8 @@
 package handlers
 
 import (
-	"encoding/json"
-	"io"
 	"net/http"
 
-	"riley/internal/auth"
 	"riley/internal/models"
 )
 
@@ -13,80 +10,34 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	jsonBody := &struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
-	}{
-		Email:    `json:"email"`,
-		Password: `json:"password"`,
-	}
+	}{}
 
-	body, err := io.ReadAll(r.Body)
+	err := decodeJSON(r, jsonBody)
 	if err != nil {
-		h.Logger.Error("Error reading body", "error", err.Error())
-
-		w.WriteHeader(http.StatusInternalServerError)
-
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
+		h.writeError(w, r, err)
 		return
 	}
 
-	err = json.Unmarshal(body, jsonBody)
-	if err != nil {
-		h.Logger.Error("Error marshalling body into JSON", "error", err.Error())
-
-		w.WriteHeader(http.StatusInternalServerError)
-
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
+	if !h.checkLockout(w, r, jsonBody.Email, models.LOGIN_METHOD_PASSWORD) {
 		return
 	}
 
-	userID, err := models.UserCheckLogin(jsonBody.Email, jsonBody.Password, h.SQLDatabase)
+	userID, err := models.UserCheckLogin(jsonBody.Email, jsonBody.Password, h.Config.Password.Hasher, h.SQLDatabase)
 	if err != nil {
-		h.Logger.Error("Error checking login", "error", err.Error())
-
-		w.WriteHeader(http.StatusInternalServerError)
-
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
+		h.writeError(w, r, err)
 		return
 	}
 
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-
-		_, err = w.Write([]byte("Unauthorized"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
+		h.loginFailed(w, r, jsonBody.Email, models.LOGIN_METHOD_PASSWORD, models.LOGIN_FAILURE_INVALID_CREDENTIALS, models.ErrInvalidCredentials)
 		return
 	}
 
-	token, err := auth.GenerateToken(auth.TokenDefaultExpiryDate(), userID, h.Config.TokenSecret)
+	user, err := models.GetUserByID(userID, h.SQLDatabase)
 	if err != nil {
-		h.Logger.Error("Error generating token", "error", err.Error())
-
-		w.WriteHeader(http.StatusInternalServerError)
-
-		_, err := w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
+		h.writeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Authorization", token)
-
-	w.WriteHeader(http.StatusOK)
+	h.startLogin(w, r, http.StatusOK, user, models.LOGIN_METHOD_PASSWORD)
 }
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/handlers/middlewares"
+	"riley/internal/models"
+)
+
+var (
+	errInvalidLoginFilter = apperror.New(apperror.CodeBadRequest, "Invalid login filter")
+	errLoginsAPIKey       = apperror.New(apperror.CodeForbidden, "Logins cannot be listed with an API key")
+)
+
+type loginEventResponse struct {
+	CreatedAt     time.Time `json:"created_at"`
+	UserID        *uint64   `json:"user_id"`
+	Email         string    `json:"email"`
+	Method        string    `json:"method"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	IP            string    `json:"ip"`
+	UserAgent     string    `json:"user_agent"`
+	ID            uint64    `json:"id"`
+	Success       bool      `json:"success"`
+}
+
+// ListLogins returns the caller's recent login attempts, newest first
+//
+// The query parameters before and limit page through older attempts
+func (h *Handler) ListLogins(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errLoginsAPIKey)
+	if !ok {
+		return
+	}
+
+	filter, err := loginEventFilter(r, false)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	filter.UserID = principal.UserID
+
+	h.writeLoginEvents(w, r, filter)
+}
+
+// AdminListLogins returns the recent login attempts of every user, newest
+// first
+//
+// The query parameters user_id, email and success filter the attempts,
+// before and limit page through them. Only admins can call it
+func (h *Handler) AdminListLogins(w http.ResponseWriter, r *http.Request) {
+	_, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := loginEventFilter(r, true)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeLoginEvents(w, r, filter)
+}
+
+func (h *Handler) writeLoginEvents(w http.ResponseWriter, r *http.Request, filter models.LoginEventFilter) {
+	events, err := models.GetLoginEvents(filter, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Logins []loginEventResponse `json:"logins"`
+	}{
+		Logins: make([]loginEventResponse, 0, len(events)),
+	}
+
+	for _, event := range events {
+		response.Logins = append(response.Logins, loginEventResponse{
+			CreatedAt:     event.CreatedAt,
+			UserID:        event.UserID,
+			Email:         event.Email,
+			Method:        event.Method,
+			FailureReason: event.FailureReason,
+			IP:            event.IP,
+			UserAgent:     event.UserAgent,
+			ID:            event.ID,
+			Success:       event.Success,
+		})
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// loginEventFilter reads the filter from the query; the user filters are
+// only read for admins
+func loginEventFilter(r *http.Request, admin bool) (models.LoginEventFilter, error) {
+	query := r.URL.Query()
+	filter := models.LoginEventFilter{}
+
+	var err error
+
+	if v := query.Get("before"); v != "" {
+		filter.Before, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return filter, errInvalidLoginFilter.WithMessage("Invalid before")
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 1 {
+			return filter, errInvalidLoginFilter.WithMessage("Invalid limit")
+		}
+	}
+
+	if v := query.Get("success"); v != "" {
+		success, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errInvalidLoginFilter.WithMessage("Invalid success")
+		}
+
+		filter.Success = &success
+	}
+
+	if !admin {
+		return filter, nil
+	}
+
+	if v := query.Get("user_id"); v != "" {
+		filter.UserID, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return filter, errInvalidLoginFilter.WithMessage("Invalid user_id")
+		}
+	}
+
+	filter.Email = query.Get("email")
+
+	return filter, nil
+}
+
+// checkLockout writes ErrAccountLocked, with a Retry-After header, if
+// logins with the email are locked
+//
+// Returns false if they are
+func (h *Handler) checkLockout(w http.ResponseWriter, r *http.Request, email string, method string) bool {
+	lockedUntil, err := models.GetLoginLockout(email, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return false
+	}
+
+	retryAfter := time.Until(lockedUntil)
+	if retryAfter <= 0 {
+		return true
+	}
+
+	h.recordLogin(r, models.LoginEvent{
+		Email:         email,
+		Method:        method,
+		FailureReason: models.LOGIN_FAILURE_LOCKED,
+	})
+
+	w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+	h.writeError(w, r, models.ErrAccountLocked)
+
+	return false
+}
+
+// loginFailed counts a failed login with the email towards its lockout,
+// records it and writes err
+func (h *Handler) loginFailed(w http.ResponseWriter, r *http.Request, email string, method string, reason string, err error) {
+	_, lockErr := models.RecordLoginFailure(email, h.Config.Lockout, h.SQLDatabase)
+	if lockErr != nil {
+		h.writeError(w, r, lockErr)
+		return
+	}
+
+	h.recordLogin(r, models.LoginEvent{
+		Email:         email,
+		Method:        method,
+		FailureReason: reason,
+	})
+
+	h.writeError(w, r, err)
+}
+
+// loginSucceeded clears the failed logins of the user and records the
+// login
+func (h *Handler) loginSucceeded(r *http.Request, user models.User, method string) error {
+	err := models.ResetLoginFailures(user.Email, h.SQLDatabase)
+	if err != nil {
+		return err
+	}
+
+	h.recordLogin(r, models.LoginEvent{
+		UserID:  &user.ID,
+		Email:   user.Email,
+		Method:  method,
+		Success: true,
+	})
+
+	return nil
+}
+
+// recordLogin records a login attempt with the client of the request
+//
+// Errors are only logged, so that a failing audit log does not lock users
+// out
+func (h *Handler) recordLogin(r *http.Request, event models.LoginEvent) {
+	event.IP = middlewares.GetClientIP(r.Context())
+	event.UserAgent = r.UserAgent()
+
+	err := models.CreateLoginEvent(event, h.SQLDatabase)
+	if err != nil {
+		h.Logger.Error("Error recording login", "error", err.Error())
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"riley/internal/models"
+)
+
+func TestLoginLockout(t *testing.T) {
+	h := createHandler()
+	h.Config.Lockout.Threshold = 2
+	h.Config.Lockout.BaseDuration = time.Minute
+
+	email := "testloginlockout@example.com"
+
+	post := func(handler http.HandlerFunc, password string) *httptest.ResponseRecorder {
+		body := []byte(`{"email": "` + email + `", "password": "` + password + `"}`)
+
+		req, err := http.NewRequest("POST", "/", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("User-Agent", "lockout")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	rr := post(h.Signup, "password123%A%")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	signup := authResponse{}
+
+	err := json.Unmarshal(rr.Body.Bytes(), &signup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err := models.ResetLoginFailures(email, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user := models.User{ID: signup.User.ID}
+
+		err = user.Delete(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	t.Run("wrong password", func(t *testing.T) {
+		for range h.Config.Lockout.Threshold {
+			rr := post(h.Login, "wrongpassword123%A%")
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("locked", func(t *testing.T) {
+		rr := post(h.Login, "password123%A%")
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+		}
+
+		if rr.Header().Get("Retry-After") == "" {
+			t.Fatal("handler did not set Retry-After")
+		}
+	})
+
+	t.Run("unknown email is locked too", func(t *testing.T) {
+		unknown := "testloginlockoutunknown@example.com"
+
+		defer func() {
+			err := models.ResetLoginFailures(unknown, h.SQLDatabase)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		body := []byte(`{"email": "` + unknown + `", "password": "wrongpassword123%A%"}`)
+
+		var rr *httptest.ResponseRecorder
+		for range h.Config.Lockout.Threshold + 1 {
+			req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+
+			rr = httptest.NewRecorder()
+			h.Login(rr, req)
+		}
+
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+		}
+	})
+
+	t.Run("list logins", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/account/logins", nil)
+		req.Header.Set("Authorization", "Bearer "+signup.AccessToken)
+
+		rr := httptest.NewRecorder()
+		h.ListLogins(rr, authenticate(t, h, req))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		response := struct {
+			Logins []loginEventResponse `json:"logins"`
+		}{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reasons := []string{}
+		for _, login := range response.Logins {
+			reasons = append(reasons, login.FailureReason)
+		}
+
+		want := []string{
+			models.LOGIN_FAILURE_LOCKED,
+			models.LOGIN_FAILURE_INVALID_CREDENTIALS,
+			models.LOGIN_FAILURE_INVALID_CREDENTIALS,
+			"",
+		}
+
+		if len(reasons) != len(want) {
+			t.Fatalf("handler returned wrong logins: got %v want %v", reasons, want)
+		}
+
+		for i := range want {
+			if reasons[i] != want[i] {
+				t.Fatalf("handler returned wrong logins: got %v want %v", reasons, want)
+			}
+		}
+	})
+
+	t.Run("admin logins", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin/logins", nil)
+		req.Header.Set("Authorization", "Bearer "+signup.AccessToken)
+
+		rr := httptest.NewRecorder()
+		h.AdminListLogins(rr, authenticate(t, h, req))
+
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+}
@@ -0,0 +1,548 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+var (
+	errInvalidMFAToken       = apperror.New(apperror.CodeUnauthorized, "Invalid or expired MFA token")
+	errMFAEnrollmentRequired = apperror.New(apperror.CodeBadRequest, "Two-factor authentication must be set up first")
+	errMFAManagement         = apperror.New(apperror.CodeForbidden, "Two-factor authentication cannot be managed with an API key")
+)
+
+type mfaChallengeResponse struct {
+	ExpiresAt          time.Time `json:"expires_at"`
+	MFAToken           string    `json:"mfa_token"`
+	MFARequired        bool      `json:"mfa_required"`
+	EnrollmentRequired bool      `json:"enrollment_required"`
+}
+
+type totpEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type mfaPolicyResponse struct {
+	Required bool `json:"required"`
+}
+
+// startLogin starts a session for a user who passed the first factor, or
+// writes an MFA challenge if they have to enter a second one
+//
+// Users with two-factor authentication, and all users if admins require
+// it, get a challenge. Users who still have to set it up get one with
+// enrollment_required, to set it up through LoginMFAEnroll
+//
+// The login is only recorded, with the method, once a session starts
+func (h *Handler) startLogin(w http.ResponseWriter, r *http.Request, status int, user models.User, method string) {
+	mfa, err := models.GetMFA(user.ID, h.SQLDatabase)
+	if err != nil && !errors.Is(err, models.ErrMFANotFound) {
+		h.writeError(w, r, err)
+		return
+	}
+
+	enabled := err == nil && mfa.IsEnabled()
+
+	if !enabled {
+		required, err := models.IsMFARequired(h.SQLDatabase)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+
+		if !required {
+			err = h.loginSucceeded(r, user, method)
+			if err != nil {
+				h.writeError(w, r, err)
+				return
+			}
+
+			h.startSession(w, r, status, user)
+			return
+		}
+	}
+
+	tokenID, err := auth.NewTokenID()
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(h.Config.MFA.ChallengeTTL)
+
+	token, err := auth.GenerateMFAChallengeToken(expiresAt, user.ID, tokenID, h.Keyring)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.writeJSON(w, status, mfaChallengeResponse{
+		ExpiresAt:          expiresAt,
+		MFAToken:           token,
+		MFARequired:        true,
+		EnrollmentRequired: !enabled,
+	})
+}
+
+// LoginMFA exchanges an MFA challenge and a second factor for a session
+//
+// The body is {"mfa_token": "...", "code": "123456"}, or
+// {"mfa_token": "...", "recovery_code": "..."}. For a challenge with
+// enrollment_required, the code confirms the enrollment started by
+// LoginMFAEnroll and the response includes the recovery codes
+func (h *Handler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	jsonBody := &struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	user, tokenID, ok := h.mfaChallengeUser(w, r, jsonBody.MFAToken)
+	if !ok {
+		return
+	}
+
+	if !h.checkLockout(w, r, user.Email, models.LOGIN_METHOD_MFA) {
+		return
+	}
+
+	mfa, err := models.GetMFA(user.ID, h.SQLDatabase)
+	if errors.Is(err, models.ErrMFANotFound) {
+		h.writeError(w, r, errMFAEnrollmentRequired)
+		return
+	} else if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if mfa.IsEnabled() {
+		err = h.checkSecondFactor(mfa, jsonBody.Code, jsonBody.RecoveryCode)
+		if err != nil {
+			h.mfaLoginFailed(w, r, user, err)
+			return
+		}
+
+		err = h.completeMFALogin(r, user, tokenID)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+
+		h.startSession(w, r, http.StatusOK, user)
+		return
+	}
+
+	recoveryCodes, err := h.enableMFA(mfa, jsonBody.Code)
+	if err != nil {
+		h.mfaLoginFailed(w, r, user, err)
+		return
+	}
+
+	err = h.completeMFALogin(r, user, tokenID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response, err := h.createSession(r, user)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.writeJSON(w, http.StatusOK, struct {
+		authResponse
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{response, recoveryCodes})
+}
+
+// LoginMFAEnroll starts the TOTP enrollment of a user who has to set up
+// two-factor authentication to log in
+//
+// The body is {"mfa_token": "..."}
+func (h *Handler) LoginMFAEnroll(w http.ResponseWriter, r *http.Request) {
+	jsonBody := &struct {
+		MFAToken string `json:"mfa_token"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	user, _, ok := h.mfaChallengeUser(w, r, jsonBody.MFAToken)
+	if !ok {
+		return
+	}
+
+	h.enrollTOTP(w, r, user)
+}
+
+// EnrollTOTP starts the TOTP enrollment of the caller
+//
+// The response has the secret and the otpauth:// URI to show as a QR
+// code. The enrollment is pending until ConfirmTOTP
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errMFAManagement)
+	if !ok {
+		return
+	}
+
+	user, err := models.GetUserByID(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.enrollTOTP(w, r, user)
+}
+
+// ConfirmTOTP enables the pending TOTP enrollment of the caller
+//
+// The body is {"code": "123456"}. The recovery codes are only returned in
+// this response
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errMFAManagement)
+	if !ok {
+		return
+	}
+
+	jsonBody := &struct {
+		Code string `json:"code"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	mfa, err := models.GetMFA(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if mfa.IsEnabled() {
+		h.writeError(w, r, models.ErrMFAAlreadyEnabled)
+		return
+	}
+
+	recoveryCodes, err := h.enableMFA(mfa, jsonBody.Code)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
+
+// DisableTOTP turns off two-factor authentication for the caller
+//
+// The body is {"code": "123456"} or {"recovery_code": "..."}
+func (h *Handler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errMFAManagement)
+	if !ok {
+		return
+	}
+
+	jsonBody := &struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	mfa, err := models.GetMFA(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if mfa.IsEnabled() {
+		err = h.checkSecondFactor(mfa, jsonBody.Code, jsonBody.RecoveryCode)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+	}
+
+	err = models.DisableMFA(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the caller
+//
+// The body is {"code": "123456"}
+func (h *Handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errMFAManagement)
+	if !ok {
+		return
+	}
+
+	jsonBody := &struct {
+		Code string `json:"code"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	mfa, err := models.GetMFA(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if !mfa.IsEnabled() {
+		h.writeError(w, r, models.ErrMFANotFound)
+		return
+	}
+
+	err = h.checkSecondFactor(mfa, jsonBody.Code, "")
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	recoveryCodes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = models.ReplaceRecoveryCodes(principal.UserID, hashes, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
+
+// GetMFAPolicy returns whether every user must use two-factor
+// authentication
+//
+// Only admins can call it
+func (h *Handler) GetMFAPolicy(w http.ResponseWriter, r *http.Request) {
+	_, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	required, err := models.IsMFARequired(h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, mfaPolicyResponse{Required: required})
+}
+
+// SetMFAPolicy sets whether every user must use two-factor authentication
+//
+// The body is {"required": true}. Only admins can call it; users without
+// two-factor authentication set it up on their next login
+func (h *Handler) SetMFAPolicy(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.adminPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	jsonBody := &mfaPolicyResponse{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = models.SetMFARequired(jsonBody.Required, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = h.audit(r, principal, models.AUDIT_ACTION_MFA_POLICY, nil, map[string]any{"required": jsonBody.Required})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, jsonBody)
+}
+
+func (h *Handler) enrollTOTP(w http.ResponseWriter, r *http.Request, user models.User) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	encrypted, err := h.Cipher.Encrypt(secret)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = models.CreatePendingMFA(user.ID, encrypted, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.writeJSON(w, http.StatusOK, totpEnrollmentResponse{
+		Secret: secret,
+		URI:    auth.TOTPURI(h.Config.MFA.Issuer, user.Email, secret),
+	})
+}
+
+// enableMFA confirms a pending enrollment with a code and returns the new
+// recovery codes
+func (h *Handler) enableMFA(mfa models.MFA, code string) ([]string, error) {
+	counter, err := h.validateTOTP(mfa, code)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = models.EnableMFA(mfa.UserID, counter, hashes, h.SQLDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// checkSecondFactor checks a TOTP code or, if given, a recovery code and
+// marks it as used
+func (h *Handler) checkSecondFactor(mfa models.MFA, code string, recoveryCode string) error {
+	if recoveryCode != "" {
+		return models.UseRecoveryCode(mfa.UserID, auth.HashRecoveryCode(recoveryCode), h.SQLDatabase)
+	}
+
+	counter, err := h.validateTOTP(mfa, code)
+	if err != nil {
+		return err
+	}
+
+	return models.UseTOTPCounter(mfa.UserID, counter, h.SQLDatabase)
+}
+
+func (h *Handler) validateTOTP(mfa models.MFA, code string) (int64, error) {
+	secret, err := h.Cipher.Decrypt(mfa.Secret)
+	if err != nil {
+		return 0, err
+	}
+
+	counter, ok := auth.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return 0, models.ErrInvalidMFACode
+	}
+
+	return counter, nil
+}
+
+// mfaChallengeUser returns the user and token ID of an MFA challenge
+//
+// If it is invalid, the error response is written and false is returned
+func (h *Handler) mfaChallengeUser(w http.ResponseWriter, r *http.Request, token string) (models.User, string, bool) {
+	userID, tokenID, err := auth.ParseMFAChallengeToken(token, h.Keyring, h.Revocations)
+	if err != nil {
+		h.writeError(w, r, errInvalidMFAToken.WithCause(err))
+		return models.User{}, "", false
+	}
+
+	user, err := models.GetUserByID(userID, h.SQLDatabase)
+	if errors.Is(err, models.ErrUserNotFound) {
+		h.writeError(w, r, errInvalidMFAToken)
+		return user, "", false
+	} else if err != nil {
+		h.writeError(w, r, err)
+		return user, "", false
+	}
+
+	return user, tokenID, true
+}
+
+// revokeMFAChallenge revokes a challenge once it started a session, so
+// that it cannot be used for another one
+func (h *Handler) revokeMFAChallenge(tokenID string) error {
+	token := models.RevokedToken{
+		ExpiresAt: time.Now().UTC().Add(h.Config.MFA.ChallengeTTL),
+		ID:        tokenID,
+	}
+
+	err := models.RevokeTokens([]models.RevokedToken{token}, h.SQLDatabase)
+	if err != nil {
+		return err
+	}
+
+	h.Revocations.Add(token)
+
+	return nil
+}
+
+// completeMFALogin revokes the challenge of a user who entered their
+// second factor and records the login
+func (h *Handler) completeMFALogin(r *http.Request, user models.User, tokenID string) error {
+	err := h.revokeMFAChallenge(tokenID)
+	if err != nil {
+		return err
+	}
+
+	return h.loginSucceeded(r, user, models.LOGIN_METHOD_MFA)
+}
+
+// mfaLoginFailed writes err, counting wrong codes towards the lockout of
+// the user so that a stolen password does not allow guessing codes
+func (h *Handler) mfaLoginFailed(w http.ResponseWriter, r *http.Request, user models.User, err error) {
+	if !errors.Is(err, models.ErrInvalidMFACode) {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.loginFailed(w, r, user.Email, models.LOGIN_METHOD_MFA, models.LOGIN_FAILURE_INVALID_CODE, err)
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+func TestMFA(t *testing.T) {
+	h := createHandler()
+
+	credentials := []byte(`{"email": "testmfa@example.com", "password": "password123%A%"}`)
+
+	user, err := models.UserCreate("testmfa@example.com", "password123%A%", h.Config.Password, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = user.Delete(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	token, err := auth.GenerateToken(time.Now().UTC().Add(time.Hour), user.ID, h.Keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(handler http.HandlerFunc, body []byte, authenticated bool) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if authenticated {
+			req.Header.Set("Authorization", "Bearer "+token)
+			req = authenticate(t, h, req)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		err := json.Unmarshal(rr.Body.Bytes(), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code := func(secret string, offset time.Duration) []byte {
+		c, err := auth.TOTPCode(secret, time.Now().Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return []byte(c)
+	}
+
+	rr := request(h.EnrollTOTP, nil, true)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	enrollment := totpEnrollmentResponse{}
+	decode(rr, &enrollment)
+
+	rr = request(h.ConfirmTOTP, []byte(`{"code": "000000"}`), true)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("handler returned wrong status code for wrong code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	rr = request(h.ConfirmTOTP, append(append([]byte(`{"code": "`), code(enrollment.Secret, -30*time.Second)...), `"}`...), true)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	recovery := recoveryCodesResponse{}
+	decode(rr, &recovery)
+
+	if len(recovery.RecoveryCodes) == 0 {
+		t.Fatal("handler returned no recovery codes")
+	}
+
+	login := func() mfaChallengeResponse {
+		rr := request(h.Login, credentials, false)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		challenge := mfaChallengeResponse{}
+		decode(rr, &challenge)
+
+		if !challenge.MFARequired || challenge.EnrollmentRequired || challenge.MFAToken == "" {
+			t.Fatalf("handler returned wrong challenge: got %+v", challenge)
+		}
+
+		return challenge
+	}
+
+	t.Run("code", func(t *testing.T) {
+		challenge := login()
+
+		body, _ := json.Marshal(map[string]string{"mfa_token": challenge.MFAToken, "code": string(code(enrollment.Secret, 0))})
+
+		rr := request(h.LoginMFA, body, false)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		session := authResponse{}
+		decode(rr, &session)
+
+		if session.User.ID != user.ID || session.AccessToken == "" {
+			t.Fatalf("handler returned wrong body: got %+v", session)
+		}
+
+		// Neither the code nor the challenge can be used again
+		rr = request(h.LoginMFA, body, false)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code for reused challenge: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+
+		body, _ = json.Marshal(map[string]string{"mfa_token": login().MFAToken, "code": string(code(enrollment.Secret, 0))})
+
+		rr = request(h.LoginMFA, body, false)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code for reused code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"mfa_token": login().MFAToken, "recovery_code": recovery.RecoveryCodes[0]})
+
+		rr := request(h.LoginMFA, body, false)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		body, _ = json.Marshal(map[string]string{"mfa_token": login().MFAToken, "recovery_code": recovery.RecoveryCodes[0]})
+
+		rr = request(h.LoginMFA, body, false)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code for used recovery code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("challenge as access token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+login().MFAToken)
+
+		_, err := auth.NewTokenAuthenticator(h.Keyring, h.Revocations, h.SQLDatabase).Authenticate(req)
+		if err == nil {
+			t.Fatal("MFA challenge authenticated as access token")
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"recovery_code": recovery.RecoveryCodes[1]})
+
+		rr := request(h.DisableTOTP, body, true)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+
+		rr = request(h.Login, credentials, false)
+
+		session := authResponse{}
+		decode(rr, &session)
+
+		if session.AccessToken == "" {
+			t.Fatalf("handler returned a challenge after disabling: got %s", rr.Body.String())
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		err := models.SetMFARequired(true, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer models.SetMFARequired(false, h.SQLDatabase)
+
+		rr := request(h.Login, credentials, false)
+
+		challenge := mfaChallengeResponse{}
+		decode(rr, &challenge)
+
+		if !challenge.EnrollmentRequired {
+			t.Fatalf("handler returned wrong challenge: got %s", rr.Body.String())
+		}
+
+		body, _ := json.Marshal(map[string]string{"mfa_token": challenge.MFAToken})
+
+		rr = request(h.LoginMFAEnroll, body, false)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		enrollment := totpEnrollmentResponse{}
+		decode(rr, &enrollment)
+
+		body, _ = json.Marshal(map[string]string{"mfa_token": challenge.MFAToken, "code": string(code(enrollment.Secret, 0))})
+
+		rr = request(h.LoginMFA, body, false)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		session := struct {
+			AccessToken   string   `json:"access_token"`
+			RecoveryCodes []string `json:"recovery_codes"`
+		}{}
+		decode(rr, &session)
+
+		if session.AccessToken == "" || len(session.RecoveryCodes) == 0 {
+			t.Fatalf("handler returned wrong body: got %s", rr.Body.String())
+		}
+	})
+
+	t.Run("policy requires admin", func(t *testing.T) {
+		rr := request(h.SetMFAPolicy, []byte(`{"required": true}`), true)
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+}
@@ -1,41 +1,83 @@
 package middlewares
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 
+	"riley/internal/apperror"
 	"riley/internal/auth"
-	"riley/internal/config"
 )
 
-func Authorization(next http.HandlerFunc) http.HandlerFunc {
+var (
+	errUnauthorized = apperror.New(apperror.CodeUnauthorized, "Unauthorized")
+	errForbidden    = apperror.New(apperror.CodeForbidden, "Forbidden")
+	errNotFound     = apperror.New(apperror.CodeNotFound, "Not found")
+	errMissingScope = apperror.New(apperror.CodeForbidden, "API key is missing the required scope")
+	errUnverified   = apperror.New(apperror.CodeForbidden, "Email address must be verified")
+)
+
+// Authentication resolves the principal of the request with the first
+// authenticator that recognises its credentials and stores it in the
+// request context
+func Authentication(authenticators []auth.Authenticator, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		secret := config.LoadConfig().TokenSecret
+		for _, authenticator := range authenticators {
+			principal, err := authenticator.Authenticate(r)
+			if errors.Is(err, auth.ErrNoCredentials) {
+				continue
+			}
 
-		userID, err := auth.GetUserIDFromToken(token, secret)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+			if err != nil {
+				_ = apperror.Write(w, GetRequestID(r.Context()), errUnauthorized.WithCause(err))
+				return
+			}
+
+			next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
 
-		// Check that user is authorized to access the resource
-		if !auth.IsAuthorized(r.URL, userID) {
-			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
-		next(w, r)
+		_ = apperror.Write(w, GetRequestID(r.Context()), errUnauthorized)
 	}
 }
 
-func Authentication(next http.HandlerFunc) http.HandlerFunc {
+// Authorization checks that the principal set by Authentication may
+// perform the request
+func Authorization(db *sql.DB, policy auth.Policy, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		secret := config.LoadConfig().TokenSecret
+		principal, ok := auth.GetPrincipal(r.Context())
+		if !ok {
+			_ = apperror.Write(w, GetRequestID(r.Context()), errUnauthorized)
+			return
+		}
 
-		if err := auth.CheckToken(token, secret); err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+		decision, err := auth.IsAuthorized(r, principal, policy, db)
+		if err != nil {
+			_ = apperror.Write(w, GetRequestID(r.Context()), err)
 			return
 		}
+
+		if !decision.Allowed {
+			_ = apperror.Write(w, GetRequestID(r.Context()), decisionError(decision))
+			return
+		}
+
+		next(w, r)
 	}
 }
+
+// decisionError maps a denied decision to the error returned to the
+// client; resources the user cannot see are reported as missing
+func decisionError(decision auth.Decision) error {
+	switch decision.Reason {
+	case auth.ReasonNotFound:
+		return errNotFound
+	case auth.ReasonMissingScope:
+		return errMissingScope
+	case auth.ReasonUnverified:
+		return errUnverified
+	}
+
+	return errForbidden
+}
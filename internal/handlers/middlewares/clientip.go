@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+)
+
+type clientIPKey struct{}
+
+// ClientIP stores the address of the client in the request context,
+// resolved with the trusted proxies of the rate limiter
+func ClientIP(limiter *RateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := limiter.clientIP(r).String()
+
+		next(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	}
+}
+
+// GetClientIP returns the client address stored in the context by
+// ClientIP, or an empty string if there is none
+func GetClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+
+	return ip
+}
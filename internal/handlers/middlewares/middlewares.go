@@ -1,11 +1,90 @@
 package middlewares
 
-import "net/http"
+import (
+	"database/sql"
+	"net/http"
 
-func DefaultMiddlewares(handler http.HandlerFunc) http.Handler {
-	return Authorization(
-		Authentication(
-			RateLimiter(
+	"riley/internal/auth"
+	"riley/internal/config"
+)
+
+// Middlewares holds the dependencies shared by the middleware chains of
+// every route
+type Middlewares struct {
+	db             *sql.DB
+	limiter        *RateLimiter
+	policy         auth.Policy
+	authenticators []auth.Authenticator
+}
+
+// New creates the middleware chains; requests are authenticated by the
+// first authenticator that recognises their credentials and authorized
+// under the policy
+func New(db *sql.DB, limiter *RateLimiter, policy auth.Policy, authenticators ...auth.Authenticator) *Middlewares {
+	return &Middlewares{
+		db:             db,
+		limiter:        limiter,
+		policy:         policy,
+		authenticators: authenticators,
+	}
+}
+
+// Default wraps handlers that require an authenticated principal allowed
+// to perform the request
+//
+// Requests are limited per client IP before they are authenticated, and
+// by the route policy after
+func (m *Middlewares) Default(policy string, handler http.HandlerFunc) http.Handler {
+	return RequestID(
+		ClientIP(
+			m.limiter,
+			m.limiter.LimitClient(
+				config.RATE_LIMIT_POLICY_CLIENT,
+				Authentication(
+					m.authenticators,
+					Authorization(
+						m.db,
+						m.policy,
+						m.limiter.Limit(
+							policy,
+							handler,
+						),
+					),
+				),
+			),
+		),
+	)
+}
+
+// Authenticated wraps handlers that require an authenticated principal
+// but only act on the principal's own account, such as logout, so that
+// they are available to every role
+func (m *Middlewares) Authenticated(policy string, handler http.HandlerFunc) http.Handler {
+	return RequestID(
+		ClientIP(
+			m.limiter,
+			m.limiter.LimitClient(
+				config.RATE_LIMIT_POLICY_CLIENT,
+				Authentication(
+					m.authenticators,
+					m.limiter.Limit(
+						policy,
+						handler,
+					),
+				),
+			),
+		),
+	)
+}
+
+// Public wraps handlers that do not require a token, such as login and
+// signup
+func (m *Middlewares) Public(policy string, handler http.HandlerFunc) http.Handler {
+	return RequestID(
+		ClientIP(
+			m.limiter,
+			m.limiter.Limit(
+				policy,
 				handler,
 			),
 		),
@@ -1,9 +1,239 @@
 package middlewares
 
-import "net/http"
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/auth"
+	"riley/internal/config"
+)
+
+var errRateLimited = apperror.New(apperror.CodeRateLimited, "Too many requests")
+
+// RateLimitStore keeps the state of the rate limits
+//
+// Take counts a request against key and reports whether it is allowed;
+// Evict drops state that no longer affects any decision
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, policy config.RateLimitPolicy, now time.Time) (RateLimitDecision, error)
+	Evict(ctx context.Context, now time.Time) error
+}
+
+type RateLimitDecision struct {
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+	Limit      int
+	Remaining  int
+	Allowed    bool
+}
+
+// RateLimiter limits requests per route policy, keyed by client IP or
+// authenticated user
+type RateLimiter struct {
+	now            func() time.Time
+	store          RateLimitStore
+	logger         *slog.Logger
+	stop           chan struct{}
+	policies       map[string]config.RateLimitPolicy
+	trustedProxies []netip.Prefix
+}
+
+// NewRateLimiter creates a rate limiter backed by store and starts evicting
+// idle state every CleanupInterval until Stop is called
+func NewRateLimiter(c config.RateLimitConfig, store RateLimitStore, logger *slog.Logger) (*RateLimiter, error) {
+	rl := &RateLimiter{
+		now:      time.Now,
+		store:    store,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		policies: c.Policies,
+	}
+
+	if _, ok := rl.policies[config.RATE_LIMIT_POLICY_DEFAULT]; !ok {
+		return nil, fmt.Errorf("rate limit policy %q is required", config.RATE_LIMIT_POLICY_DEFAULT)
+	}
+
+	for name, policy := range rl.policies {
+		if policy.Rate <= 0 || policy.Burst <= 0 {
+			return nil, fmt.Errorf("invalid rate limit policy %q", name)
+		}
+	}
+
+	for _, cidr := range c.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+
+		rl.trustedProxies = append(rl.trustedProxies, prefix.Masked())
+	}
+
+	if c.CleanupInterval > 0 {
+		go rl.cleanup(c.CleanupInterval)
+	}
+
+	return rl, nil
+}
+
+// NewRateLimitStore creates the store selected in the config
+//
+// The Postgres store falls back to an in-memory store when the database
+// does not answer within StoreTimeout
+func NewRateLimitStore(c config.RateLimitConfig, db *sql.DB, logger *slog.Logger) (RateLimitStore, error) {
+	switch c.Store {
+	case config.RATE_LIMIT_STORE_MEMORY:
+		return NewMemoryRateLimitStore(c.MaxBuckets), nil
+	case config.RATE_LIMIT_STORE_POSTGRES:
+		return NewFallbackRateLimitStore(
+			NewPostgresRateLimitStore(db),
+			NewMemoryRateLimitStore(c.MaxBuckets),
+			c.StoreTimeout,
+			logger,
+		), nil
+	}
+
+	return nil, fmt.Errorf("rate limit store %q not implemented", c.Store)
+}
+
+// Stop stops the eviction of idle state
+func (rl *RateLimiter) Stop() {
+	close(rl.stop)
+}
+
+// Limit applies the named policy to a handler
+//
+// Every response carries RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers; rejected requests also carry Retry-After. If
+// the store fails the request is let through
+func (rl *RateLimiter) Limit(policyName string, next http.HandlerFunc) http.HandlerFunc {
+	return rl.limit(policyName, true, next)
+}
+
+// LimitClient applies the named policy to a handler per client IP, even
+// if the request is authenticated
+//
+// It goes in front of Authentication, so that requests with invalid
+// credentials are limited too
+func (rl *RateLimiter) LimitClient(policyName string, next http.HandlerFunc) http.HandlerFunc {
+	return rl.limit(policyName, false, next)
+}
+
+// limit applies the policy, keyed by the authenticated user if perUser is
+// set and the policy allows it, and by client IP otherwise
+func (rl *RateLimiter) limit(policyName string, perUser bool, next http.HandlerFunc) http.HandlerFunc {
+	policy, ok := rl.policies[policyName]
+	if !ok {
+		policyName = config.RATE_LIMIT_POLICY_DEFAULT
+		policy = rl.policies[policyName]
+	}
 
-func RateLimiter(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// ...
+		key := policyName + ":ip:" + rl.clientIP(r).String()
+		if principal, ok := auth.GetPrincipal(r.Context()); ok && perUser && policy.PerUser {
+			key = policyName + ":user:" + strconv.FormatUint(principal.UserID, 10)
+		}
+
+		d, err := rl.store.Take(r.Context(), key, policy, rl.now())
+		if err != nil {
+			rl.logger.Error("Error checking rate limit", "error", err.Error())
+
+			next(w, r)
+
+			return
+		}
+
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Burst, seconds(time.Duration(float64(policy.Burst)/policy.Rate*float64(time.Second)))))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(d.ResetAfter)))
+
+		if !d.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(d.RetryAfter)))
+			_ = apperror.Write(w, GetRequestID(r.Context()), errRateLimited)
+
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (rl *RateLimiter) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.stop:
+			return
+		case <-ticker.C:
+			err := rl.store.Evict(context.Background(), rl.now())
+			if err != nil {
+				rl.logger.Error("Error evicting rate limits", "error", err.Error())
+			}
+		}
+	}
+}
+
+// clientIP returns the address of the client
+//
+// X-Forwarded-For is only honoured when the request comes from a trusted
+// proxy, and is read right to left so that a client cannot spoof its
+// address by prepending entries
+func (rl *RateLimiter) clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.IPv4Unspecified()
+	}
+
+	addr = addr.Unmap()
+	if !rl.isTrustedProxy(addr) {
+		return addr
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		addr = hop.Unmap()
+		if !rl.isTrustedProxy(addr) {
+			break
+		}
 	}
+
+	return addr
+}
+
+func (rl *RateLimiter) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range rl.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seconds rounds a duration up to whole seconds, as used by the rate limit
+// headers
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
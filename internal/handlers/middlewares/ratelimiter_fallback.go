@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"riley/internal/config"
+)
+
+// FallbackRateLimitStore uses a primary store, usually a shared one, and
+// switches to a fallback store for requests where the primary fails or
+// does not answer within the timeout
+//
+// Limits enforced by the fallback are per instance, which is preferred
+// over stalling every request behind a slow database
+type FallbackRateLimitStore struct {
+	primary  RateLimitStore
+	fallback RateLimitStore
+	logger   *slog.Logger
+	timeout  time.Duration
+}
+
+func NewFallbackRateLimitStore(primary RateLimitStore, fallback RateLimitStore, timeout time.Duration, logger *slog.Logger) *FallbackRateLimitStore {
+	return &FallbackRateLimitStore{
+		primary:  primary,
+		fallback: fallback,
+		logger:   logger,
+		timeout:  timeout,
+	}
+}
+
+func (s *FallbackRateLimitStore) Take(ctx context.Context, key string, policy config.RateLimitPolicy, now time.Time) (RateLimitDecision, error) {
+	primaryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	d, err := s.primary.Take(primaryCtx, key, policy, now)
+	if err == nil {
+		return d, nil
+	}
+
+	s.logger.Warn("Rate limit store failed, using fallback", "error", err.Error())
+
+	return s.fallback.Take(ctx, key, policy, now)
+}
+
+func (s *FallbackRateLimitStore) Evict(ctx context.Context, now time.Time) error {
+	err := s.fallback.Evict(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	return s.primary.Evict(ctx, now)
+}
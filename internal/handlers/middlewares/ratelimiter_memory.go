@@ -0,0 +1,108 @@
+package middlewares
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"riley/internal/config"
+)
+
+// MemoryRateLimitStore keeps token buckets in process memory
+//
+// Limits are not shared between instances, so it is meant for single
+// instance deployments and as a fallback for shared stores
+type MemoryRateLimitStore struct {
+	buckets    map[string]*bucket
+	maxBuckets int
+	mu         sync.Mutex
+}
+
+type bucket struct {
+	updatedAt time.Time
+	policy    config.RateLimitPolicy
+	tokens    float64
+}
+
+// NewMemoryRateLimitStore creates a store holding at most maxBuckets
+// buckets, or an unbounded number if maxBuckets is zero
+func NewMemoryRateLimitStore(maxBuckets int) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:    map[string]*bucket{},
+		maxBuckets: maxBuckets,
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, policy config.RateLimitPolicy, now time.Time) (RateLimitDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		if s.maxBuckets > 0 && len(s.buckets) >= s.maxBuckets {
+			s.evict(now)
+		}
+
+		b = &bucket{tokens: float64(policy.Burst), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	b.policy = policy
+
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	b.tokens = math.Min(float64(policy.Burst), b.tokens+elapsed*policy.Rate)
+	b.updatedAt = now
+
+	d := RateLimitDecision{Limit: policy.Burst}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - b.tokens) / policy.Rate * float64(time.Second))
+	}
+
+	d.Remaining = int(b.tokens)
+	d.ResetAfter = time.Duration((float64(policy.Burst) - b.tokens) / policy.Rate * float64(time.Second))
+
+	return d, nil
+}
+
+func (s *MemoryRateLimitStore) Evict(_ context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(now)
+
+	return nil
+}
+
+// evict removes buckets that have refilled completely, since they behave
+// exactly like a new bucket; if that is not enough, buckets last used
+// before the average are dropped so memory stays bounded
+//
+// The caller must hold s.mu
+func (s *MemoryRateLimitStore) evict(now time.Time) {
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.updatedAt).Seconds()*b.policy.Rate >= float64(b.policy.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+
+	if s.maxBuckets <= 0 || len(s.buckets) < s.maxBuckets {
+		return
+	}
+
+	var mean int64
+	for _, b := range s.buckets {
+		mean += b.updatedAt.UnixNano() / int64(len(s.buckets))
+	}
+
+	cutoff := time.Unix(0, mean)
+	for key, b := range s.buckets {
+		if !b.updatedAt.After(cutoff) {
+			delete(s.buckets, key)
+		}
+	}
+}
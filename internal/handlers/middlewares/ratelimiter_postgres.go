@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+
+	"riley/internal/config"
+)
+
+// PostgresRateLimitStore shares limits between instances through the
+// rate_limits table
+//
+// It uses a sliding window counter: the policy allows Burst requests per
+// Burst/Rate seconds, and the previous fixed window is weighted by how
+// much of it still overlaps the sliding window. Requests over the limit are
+// counted as well, so clients that keep retrying stay limited
+type PostgresRateLimitStore struct {
+	db *sql.DB
+}
+
+func NewPostgresRateLimitStore(db *sql.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db}
+}
+
+func (s *PostgresRateLimitStore) Take(ctx context.Context, key string, policy config.RateLimitPolicy, now time.Time) (RateLimitDecision, error) {
+	window := time.Duration(float64(policy.Burst) / policy.Rate * float64(time.Second))
+	windowStart := now.UTC().Truncate(window)
+	elapsed := now.Sub(windowStart)
+
+	var (
+		current  int
+		previous int
+	)
+
+	query := "" +
+		"INSERT INTO rate_limits (key, window_start, expires_at, count) " +
+		"VALUES ($1, $2, $3, 1) " +
+		"ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limits.count + 1 " +
+		"RETURNING count, COALESCE((SELECT count FROM rate_limits WHERE key = $1 AND window_start = $4), 0)"
+	err := s.db.QueryRowContext(
+		ctx, query, key, windowStart, windowStart.Add(2*window), windowStart.Add(-window),
+	).Scan(
+		&current, &previous,
+	)
+	if err != nil {
+		return RateLimitDecision{}, err
+	}
+
+	weight := 1 - elapsed.Seconds()/window.Seconds()
+	estimate := float64(previous)*weight + float64(current)
+
+	d := RateLimitDecision{
+		Limit:      policy.Burst,
+		Allowed:    estimate <= float64(policy.Burst),
+		Remaining:  max(0, policy.Burst-int(math.Ceil(estimate))),
+		ResetAfter: window - elapsed,
+	}
+
+	if !d.Allowed {
+		d.RetryAfter = window - elapsed
+	}
+
+	return d, nil
+}
+
+func (s *PostgresRateLimitStore) Evict(ctx context.Context, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM rate_limits WHERE expires_at < $1", now.UTC())
+
+	return err
+}
@@ -0,0 +1,53 @@
+package middlewares
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"riley/internal/config"
+	"riley/internal/sql"
+)
+
+func TestPostgresRateLimitStore(t *testing.T) {
+	db := sql.Connect(config.LoadTestConfig())
+	store := NewPostgresRateLimitStore(db)
+
+	policy := config.RateLimitPolicy{Rate: 1, Burst: 2}
+	key := "test:ip:" + time.Now().String()
+
+	// Start at the beginning of a window so the previous one does not count
+	now := time.Now().UTC().Truncate(2 * time.Second)
+
+	defer func() {
+		_, err := db.Exec("DELETE FROM rate_limits WHERE key = $1", key)
+		if err != nil {
+			t.Fatalf("Cleanup returned an error: %s", err)
+		}
+	}()
+
+	for i, allowed := range []bool{true, true, false} {
+		d, err := store.Take(context.Background(), key, policy, now)
+		if err != nil {
+			t.Fatalf("Take returned an error: %s", err)
+		}
+
+		if d.Allowed != allowed {
+			t.Fatalf("request %d: wanted allowed %v, got %v", i, allowed, d.Allowed)
+		}
+	}
+
+	err := store.Evict(context.Background(), now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Evict returned an error: %s", err)
+	}
+
+	d, err := store.Take(context.Background(), key, policy, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Take returned an error: %s", err)
+	}
+
+	if !d.Allowed || d.Remaining != 1 {
+		t.Fatalf("wanted a fresh window after eviction, got %+v", d)
+	}
+}
@@ -0,0 +1,212 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"riley/internal/auth"
+	"riley/internal/config"
+)
+
+var testRateLimitPolicies = map[string]config.RateLimitPolicy{
+	config.RATE_LIMIT_POLICY_DEFAULT: {Rate: 1, Burst: 2, PerUser: true},
+	config.RATE_LIMIT_POLICY_LOGIN:   {Rate: 1, Burst: 1},
+	config.RATE_LIMIT_POLICY_CLIENT:  {Rate: 1, Burst: 3},
+}
+
+func newTestRateLimiter(t *testing.T, store RateLimitStore) (*RateLimiter, *time.Time) {
+	rl, err := NewRateLimiter(config.RateLimitConfig{
+		Policies:       testRateLimitPolicies,
+		TrustedProxies: []string{"10.0.0.0/8"},
+	}, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+
+	return rl, &now
+}
+
+func limitedRequest(rl *RateLimiter, policy string, remoteAddr string, forwardedFor string, userID uint64) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+
+	if userID != 0 {
+		req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{UserID: userID}))
+	}
+
+	rr := httptest.NewRecorder()
+	rl.Limit(policy, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})(rr, req)
+
+	return rr
+}
+
+func TestRateLimiter(t *testing.T) {
+	rl, now := newTestRateLimiter(t, NewMemoryRateLimitStore(0))
+
+	t.Run("burst then reject", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			rr := limitedRequest(rl, config.RATE_LIMIT_POLICY_DEFAULT, "192.0.2.1:1234", "", 0)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("request %d: wanted %d, got %d", i, http.StatusOK, rr.Code)
+			}
+		}
+
+		rr := limitedRequest(rl, config.RATE_LIMIT_POLICY_DEFAULT, "192.0.2.1:1234", "", 0)
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("wanted %d, got %d", http.StatusTooManyRequests, rr.Code)
+		}
+
+		if rr.Header().Get("Retry-After") != "1" || rr.Header().Get("RateLimit-Remaining") != "0" {
+			t.Fatalf("wrong rate limit headers: %v", rr.Header())
+		}
+
+		*now = now.Add(time.Second)
+
+		rr = limitedRequest(rl, config.RATE_LIMIT_POLICY_DEFAULT, "192.0.2.1:1234", "", 0)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wanted %d after refill, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("policies and users are separate", func(t *testing.T) {
+		rr := limitedRequest(rl, config.RATE_LIMIT_POLICY_LOGIN, "192.0.2.1:1234", "", 0)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wanted %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		rr = limitedRequest(rl, config.RATE_LIMIT_POLICY_DEFAULT, "192.0.2.1:1234", "", 42)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wanted %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+}
+
+// rejectingAuthenticator fails every request, as for an invalid token
+type rejectingAuthenticator struct{}
+
+func (rejectingAuthenticator) Authenticate(r *http.Request) (auth.Principal, error) {
+	return auth.Principal{}, errors.New("invalid token")
+}
+
+func TestRateLimiterInvalidCredentials(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, NewMemoryRateLimitStore(0))
+	m := New(nil, rl, auth.Policy{}, rejectingAuthenticator{})
+
+	handler := m.Default(config.RATE_LIMIT_POLICY_DEFAULT, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for i := 0; i < testRateLimitPolicies[config.RATE_LIMIT_POLICY_CLIENT].Burst; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Authorization", "Bearer invalid")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d: wanted %d, got %d", i, http.StatusUnauthorized, rr.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Authorization", "Bearer invalid")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("wanted %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+}
+
+func TestRateLimiterClientIP(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, NewMemoryRateLimitStore(0))
+
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor string
+		want         string
+	}{
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "203.0.113.9, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+
+		if tt.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+
+		if got := rl.clientIP(req).String(); got != tt.want {
+			t.Errorf("clientIP(%s, %q): wanted %s, got %s", tt.remoteAddr, tt.forwardedFor, tt.want, got)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreEvict(t *testing.T) {
+	store := NewMemoryRateLimitStore(2)
+	rl, now := newTestRateLimiter(t, store)
+
+	limitedRequest(rl, config.RATE_LIMIT_POLICY_DEFAULT, "192.0.2.1:1234", "", 0)
+	limitedRequest(rl, config.RATE_LIMIT_POLICY_DEFAULT, "192.0.2.2:1234", "", 0)
+
+	*now = now.Add(time.Minute)
+
+	limitedRequest(rl, config.RATE_LIMIT_POLICY_DEFAULT, "192.0.2.3:1234", "", 0)
+
+	if len(store.buckets) != 1 {
+		t.Fatalf("wanted refilled buckets to be evicted, got %d buckets", len(store.buckets))
+	}
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(ctx context.Context, _ string, _ config.RateLimitPolicy, _ time.Time) (RateLimitDecision, error) {
+	<-ctx.Done()
+
+	return RateLimitDecision{}, ctx.Err()
+}
+
+func (failingRateLimitStore) Evict(context.Context, time.Time) error {
+	return errors.New("unavailable")
+}
+
+func TestFallbackRateLimitStore(t *testing.T) {
+	store := NewFallbackRateLimitStore(
+		failingRateLimitStore{},
+		NewMemoryRateLimitStore(0),
+		10*time.Millisecond,
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+	)
+	rl, _ := newTestRateLimiter(t, store)
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		codes = append(codes, limitedRequest(rl, config.RATE_LIMIT_POLICY_DEFAULT, "192.0.2.1:1234", "", 0).Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("wanted fallback store to enforce the limit, got %v", codes)
+	}
+}
@@ -0,0 +1,40 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+type requestIDKey struct{}
+
+// Incoming request IDs are only reused when they look like an ID, so that
+// clients cannot inject arbitrary text into logs and responses
+var requestIDPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// RequestID assigns every request an ID, reusing a well formed
+// X-Request-ID header when present, and echoes it in the response
+func RequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 16)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	}
+}
+
+// GetRequestID returns the request ID stored in the context by RequestID,
+// or an empty string if there is none
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
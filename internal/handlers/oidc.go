@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/models"
+	"riley/internal/oidc"
+)
+
+// oidcStateCookie binds a login to the browser that started it, so that a
+// callback cannot be replayed in someone else's browser
+const oidcStateCookie = "riley_oidc_state"
+
+var (
+	errOIDCDisabled      = apperror.New(apperror.CodeNotFound, "OIDC login is not enabled")
+	errOIDCFailed        = apperror.New(apperror.CodeUnauthorized, "OIDC login failed")
+	errOIDCEmailRequired = apperror.New(apperror.CodeUnauthorized, "The identity provider did not return an email address")
+	errOIDCUnverified    = apperror.New(apperror.CodeConflict, "A user with this email already exists; the identity provider must verify the email to link it")
+)
+
+// OIDCLogin redirects to the identity provider to log in
+//
+// The state, nonce and PKCE code verifier are stored until the provider
+// redirects back to OIDCCallback
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		h.writeError(w, r, errOIDCDisabled)
+		return
+	}
+
+	login := models.OIDCLogin{
+		ExpiresAt: time.Now().UTC().Add(h.Config.OIDC.LoginTimeout),
+	}
+
+	var err error
+	for _, value := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		*value, err = oidc.RandomString()
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+	}
+
+	authURL, err := h.OIDC.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	err = models.CreateOIDCLogin(login, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    login.State,
+		Path:     "/login/oidc",
+		MaxAge:   int(h.Config.OIDC.LoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a login at the identity provider and starts a
+// session, like Login
+//
+// Users are matched by the issuer and subject of the ID token. The first
+// login links an existing user with the same email, if the provider has
+// verified it, or creates a new user
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		h.writeError(w, r, errOIDCDisabled)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		h.writeError(w, r, models.ErrOIDCLoginNotFound)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	login, err := models.ConsumeOIDCLogin(state, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if idpError := query.Get("error"); idpError != "" {
+		h.writeError(w, r, errOIDCFailed.WithDetails(map[string]any{"error": idpError}))
+		return
+	}
+
+	rawIDToken, err := h.OIDC.Exchange(r.Context(), query.Get("code"), login.CodeVerifier)
+	if errors.Is(err, oidc.ErrTokenExchange) {
+		h.writeError(w, r, errOIDCFailed.WithCause(err))
+		return
+	} else if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	claims, err := h.OIDC.VerifyIDToken(r.Context(), rawIDToken, login.Nonce)
+	if errors.Is(err, oidc.ErrInvalidIDToken) {
+		h.writeError(w, r, errOIDCFailed.WithCause(err))
+		return
+	} else if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	user, err := h.oidcUser(claims)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.startLogin(w, r, http.StatusOK, user, models.LOGIN_METHOD_OIDC)
+}
+
+// oidcUser returns the user linked to the identity in the claims, linking
+// or creating one on the first login
+func (h *Handler) oidcUser(claims oidc.Claims) (models.User, error) {
+	identity, err := models.GetIdentity(h.OIDC.Issuer(), claims.Subject, h.SQLDatabase)
+	if err == nil {
+		return models.GetUserByID(identity.UserID, h.SQLDatabase)
+	} else if !errors.Is(err, models.ErrIdentityNotFound) {
+		return models.User{}, err
+	}
+
+	identity = models.Identity{
+		Issuer:  h.OIDC.Issuer(),
+		Subject: claims.Subject,
+		Email:   strings.ToLower(strings.TrimSpace(claims.Email)),
+	}
+
+	if identity.Email == "" {
+		return models.User{}, errOIDCEmailRequired
+	}
+
+	user, err := models.GetUserByEmail(identity.Email, h.SQLDatabase)
+	if errors.Is(err, models.ErrUserNotFound) {
+		user, err = models.UserCreateFromIdentity(identity, claims.EmailVerified, h.Config.Password.Hasher, h.SQLDatabase)
+		if errors.Is(err, models.ErrUserExists) {
+			// The email belongs to a deactivated user
+			return models.User{}, errOIDCUnverified
+		}
+
+		return user, err
+	} else if err != nil {
+		return models.User{}, err
+	}
+
+	// Anyone can claim an unverified email at some providers, which would
+	// let them take over the account
+	if !claims.EmailVerified {
+		return models.User{}, errOIDCUnverified
+	}
+
+	identity.UserID = user.ID
+
+	_, err = models.CreateIdentity(identity, h.SQLDatabase)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if !user.IsEmailVerified() {
+		err = user.VerifyEmail(identity.Email, h.SQLDatabase)
+		if err != nil {
+			return models.User{}, err
+		}
+	}
+
+	return user, nil
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"riley/internal/models"
+	"riley/internal/oidc"
+	"riley/internal/oidc/oidctest"
+)
+
+func createOIDCHandler(t *testing.T) (*Handler, *oidctest.Server) {
+	t.Helper()
+
+	server := oidctest.NewServer(t)
+
+	h := createHandler()
+	h.Config.OIDC.Issuer = server.Issuer()
+	h.Config.OIDC.ClientID = oidctest.ClientID
+	h.Config.OIDC.ClientSecret = oidctest.ClientSecret
+	h.Config.OIDC.RedirectURL = "http://riley.test/login/oidc/callback"
+	h.OIDC = oidc.NewProvider(h.Config.OIDC, server.Client())
+
+	return h, server
+}
+
+// oidcLogin runs a login through the provider and returns the response of
+// the callback
+func oidcLogin(t *testing.T, h *Handler, server *oidctest.Server) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.OIDCLogin).ServeHTTP(rr, httptest.NewRequest("GET", "/login/oidc", nil))
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+	}
+
+	client := server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	for _, cookie := range rr.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.OIDCCallback).ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestOIDCLogin(t *testing.T) {
+	h, server := createOIDCHandler(t)
+
+	t.Run("new user", func(t *testing.T) {
+		server.SetUser(oidctest.User{Subject: "oidc-new", Email: "testoidcnew@example.com", EmailVerified: true})
+
+		rr := oidcLogin(t, h, server)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		login := authResponse{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &login)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user := models.User{ID: login.User.ID}
+		defer user.Delete(false, h.SQLDatabase)
+
+		if login.User.Email != "testoidcnew@example.com" || login.AccessToken == "" {
+			t.Fatalf("handler returned wrong body: got %+v", login)
+		}
+
+		// The second login finds the user through the identity
+		rr = oidcLogin(t, h, server)
+
+		again := authResponse{}
+
+		err = json.Unmarshal(rr.Body.Bytes(), &again)
+		if err != nil || again.User.ID != login.User.ID {
+			t.Fatalf("handler returned another user: got %s", rr.Body.String())
+		}
+	})
+
+	t.Run("existing user", func(t *testing.T) {
+		user, err := models.UserCreate("testoidcexisting@example.com", "password123%A%", h.Config.Password, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer user.Delete(false, h.SQLDatabase)
+
+		server.SetUser(oidctest.User{Subject: "oidc-existing", Email: user.Email, EmailVerified: false})
+
+		rr := oidcLogin(t, h, server)
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("handler returned wrong status code for unverified email: got %v want %v", rr.Code, http.StatusConflict)
+		}
+
+		server.SetUser(oidctest.User{Subject: "oidc-existing", Email: user.Email, EmailVerified: true})
+
+		rr = oidcLogin(t, h, server)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		login := authResponse{}
+
+		err = json.Unmarshal(rr.Body.Bytes(), &login)
+		if err != nil || login.User.ID != user.ID {
+			t.Fatalf("handler did not link the existing user: got %s", rr.Body.String())
+		}
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/login/oidc/callback?code=code&state=forged", nil)
+		req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "other"})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.OIDCCallback).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		h := createHandler()
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.OIDCLogin).ServeHTTP(rr, httptest.NewRequest("GET", "/login/oidc", nil))
+
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
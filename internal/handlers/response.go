@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"riley/internal/apperror"
+	"riley/internal/handlers/middlewares"
+)
+
+// writeError writes err as a JSON error envelope
+//
+// Errors without an apperror code are logged and reported to the client
+// as internal errors
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	requestID := middlewares.GetRequestID(r.Context())
+
+	e := apperror.From(err)
+	if e.Code == apperror.CodeInternal {
+		h.Logger.Error("Internal server error", "error", err.Error(), "request_id", requestID)
+	}
+
+	err = apperror.Write(w, requestID, e)
+	if err != nil {
+		h.Logger.Error("Error writing response", "error", err.Error())
+	}
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		h.Logger.Error("Error writing response", "error", err.Error())
+	}
+}
+
+// decodeJSON reads the request body into v
+//
+// Malformed bodies are reported as bad requests rather than internal
+// errors
+func decodeJSON(r *http.Request, v any) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errTooLarge.WithCause(err)
+	} else if err != nil {
+		return errInvalidJSON.WithCause(err)
+	}
+
+	return nil
+}
+
+var (
+	errForbidden    = apperror.New(apperror.CodeForbidden, "Forbidden")
+	errMissingScope = apperror.New(apperror.CodeForbidden, "API key is missing the required scope")
+	errInvalidJSON  = apperror.New(apperror.CodeBadRequest, "Invalid JSON body")
+	errTooLarge     = apperror.New(apperror.CodeTooLarge, "Request body is too large")
+	errUnauthorized = apperror.New(apperror.CodeUnauthorized, "Unauthorized")
+)
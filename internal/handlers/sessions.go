@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/auth"
+	"riley/internal/handlers/middlewares"
+	"riley/internal/models"
+)
+
+var (
+	errMissingRefreshToken = apperror.New(apperror.CodeValidationFailed, "A refresh token is required")
+	errInvalidSessionID    = apperror.New(apperror.CodeBadRequest, "Invalid session ID")
+	errSessionManagement   = apperror.New(apperror.CodeForbidden, "Sessions cannot be managed with an API key")
+)
+
+type sessionResponse struct {
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	ID         uint64    `json:"id"`
+	Current    bool      `json:"current"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token
+//
+// The body is {"refresh_token": "..."}; the refresh token can only be
+// used once
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	jsonBody := &struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if jsonBody.RefreshToken == "" {
+		h.writeError(w, r, errMissingRefreshToken)
+		return
+	}
+
+	tokens, err := h.newSessionTokens()
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	session, previous, err := models.RotateSession(
+		auth.HashRefreshToken(jsonBody.RefreshToken),
+		tokens.RefreshTokenHash,
+		models.Session{
+			AccessTokenExpiresAt: tokens.AccessTokenExpiresAt,
+			AccessTokenID:        tokens.AccessTokenID,
+			UserAgent:            r.UserAgent(),
+			IP:                   middlewares.GetClientIP(r.Context()),
+		},
+		h.SQLDatabase,
+	)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.Revocations.Add(previous)
+
+	user, err := models.GetUserByID(session.UserID, h.SQLDatabase)
+	if errors.Is(err, models.ErrUserNotFound) {
+		h.writeError(w, r, models.ErrInvalidRefreshToken)
+		return
+	} else if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeAuthResponse(w, r, http.StatusOK, user, session, tokens)
+}
+
+// Logout revokes the caller's session, so that neither its access token
+// nor its refresh token can be used anymore
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errSessionManagement)
+	if !ok {
+		return
+	}
+
+	if principal.SessionID != 0 {
+		revoked, err := models.RevokeSession(principal.SessionID, principal.UserID, h.SQLDatabase)
+		switch {
+		case err == nil:
+			h.Revocations.Add(revoked)
+		case !errors.Is(err, models.ErrSessionNotFound):
+			h.writeError(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll revokes every session of the caller
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errSessionManagement)
+	if !ok {
+		return
+	}
+
+	revoked, err := models.RevokeUserSessions(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.Revocations.Add(revoked...)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListSessions returns the caller's active sessions, flagging the one
+// the request was made with
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errSessionManagement)
+	if !ok {
+		return
+	}
+
+	sessions, err := models.GetUserSessions(principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Sessions []sessionResponse `json:"sessions"`
+	}{
+		Sessions: make([]sessionResponse, 0, len(sessions)),
+	}
+
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, sessionResponse{
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			ID:         session.ID,
+			Current:    session.ID == principal.SessionID,
+		})
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// DeleteSession revokes one of the caller's sessions
+func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.tokenPrincipal(w, r, errSessionManagement)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.writeError(w, r, errInvalidSessionID.WithCause(err))
+		return
+	}
+
+	revoked, err := models.RevokeSession(id, principal.UserID, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.Revocations.Add(revoked)
+
+	w.WriteHeader(http.StatusNoContent)
+}
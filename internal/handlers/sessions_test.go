@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"riley/internal/models"
+)
+
+func TestSessions(t *testing.T) {
+	h := createHandler()
+
+	credentials := []byte(`{"email": "testsessions@example.com", "password": "password123%A%"}`)
+
+	start := func(handler http.HandlerFunc, userAgent string) authResponse {
+		req, err := http.NewRequest("POST", "/", bytes.NewReader(credentials))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("User-Agent", userAgent)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		response := authResponse{}
+
+		err = json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return response
+	}
+
+	laptop := start(h.Signup, "laptop")
+
+	defer func() {
+		user := models.User{ID: laptop.User.ID}
+
+		err := user.Delete(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	phone := start(h.Login, "phone")
+
+	request := func(handler http.HandlerFunc, method string, id string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/sessions/"+id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.SetPathValue("id", id)
+		req.Header.Set("Authorization", "Bearer "+phone.AccessToken)
+		req = authenticate(t, h, req)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	list := func() []sessionResponse {
+		rr := request(h.ListSessions, "GET", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		response := struct {
+			Sessions []sessionResponse `json:"sessions"`
+		}{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return response.Sessions
+	}
+
+	sessions := list()
+	if len(sessions) != 2 {
+		t.Fatalf("handler returned wrong sessions: got %+v", sessions)
+	}
+
+	var laptopID uint64
+	for _, session := range sessions {
+		switch session.UserAgent {
+		case "phone":
+			if !session.Current {
+				t.Error("Testing current session: Wanted true, got false")
+			}
+		case "laptop":
+			laptopID = session.ID
+		}
+	}
+
+	if laptopID == 0 {
+		t.Fatalf("handler returned no laptop session: got %+v", sessions)
+	}
+
+	t.Run("revoke", func(t *testing.T) {
+		rr := request(h.DeleteSession, "DELETE", strconv.FormatUint(laptopID, 10))
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+
+		sessions := list()
+		if len(sessions) != 1 || sessions[0].UserAgent != "phone" {
+			t.Fatalf("handler returned wrong sessions after revoke: got %+v", sessions)
+		}
+
+		rr = request(h.DeleteSession, "DELETE", strconv.FormatUint(laptopID, 10))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("handler returned wrong status code for revoked session: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		rr := request(h.DeleteSession, "DELETE", "laptop")
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("refresh while suspended", func(t *testing.T) {
+		refresh := func() *httptest.ResponseRecorder {
+			body := []byte(`{"refresh_token": "` + phone.RefreshToken + `"}`)
+
+			req, err := http.NewRequest("POST", "/token/refresh", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			h.Refresh(rr, req)
+
+			return rr
+		}
+
+		user := models.User{ID: phone.User.ID}
+
+		err := user.SetActive(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := refresh()
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+
+		err = user.SetActive(true, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The rejected refresh did not use up the refresh token
+		rr = refresh()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+}
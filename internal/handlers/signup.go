@@ -1,11 +1,9 @@
 package handlers
 
 import (
-	"encoding/json"
-	"io"
 	"net/http"
 
-	"riley/internal/auth"
+	"riley/internal/handlers/middlewares"
 	"riley/internal/models"
 )
 
@@ -15,82 +13,24 @@ func (h *Handler) Signup(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}{}
 
-	body, err := io.ReadAll(r.Body)
+	err := decodeJSON(r, jsonBody)
 	if err != nil {
-		h.Logger.Error("Error reading body", "error", err.Error())
-
-		w.WriteHeader(http.StatusInternalServerError)
-
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
-		return
-	}
-
-	err = json.Unmarshal(body, jsonBody)
-	if err != nil {
-		h.Logger.Error("Error marshalling body into JSON", "error", err.Error())
-
-		w.WriteHeader(http.StatusInternalServerError)
-
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
-		return
-	}
-
-	err = models.UserExists(jsonBody.Email, h.SQLDatabase)
-	if err != nil {
-		w.WriteHeader(http.StatusConflict)
-
-		_, err = w.Write([]byte("User already exists"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
+		h.writeError(w, r, err)
 		return
 	}
 
-	user, err := models.UserCreate(jsonBody.Email, jsonBody.Password, h.SQLDatabase)
+	user, err := models.UserCreate(jsonBody.Email, jsonBody.Password, h.Config.Password, h.SQLDatabase)
 	if err != nil {
-		h.Logger.Error("Error creating user", "error", err.Error())
-
-		w.WriteHeader(http.StatusInternalServerError)
-
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
+		h.writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-
-	token, err := auth.GenerateToken(
-		auth.TokenDefaultExpiryDate(),
-		user.ID,
-		h.Config.TokenSecret,
-	)
+	// The account works without a verified email, so a failed email only
+	// means the user has to ask for another one
+	err = h.sendVerificationEmail(r.Context(), user)
 	if err != nil {
-		h.Logger.Error("Error generating token", "error", err.Error())
-
-		w.WriteHeader(http.StatusInternalServerError)
-
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
-
-		return
+		h.Logger.Error("Error sending verification email", "error", err.Error(), "request_id", middlewares.GetRequestID(r.Context()))
 	}
 
-	_, err = w.Write([]byte(token))
-	if err != nil {
-		h.Logger.Error("Error writing response", "error", err.Error())
-	}
+	h.startLogin(w, r, http.StatusCreated, user, models.LOGIN_METHOD_PASSWORD)
 }
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+// Maximum text size is 1MB
+const maxTextSize = 1 << 20
+
+type textResponse struct {
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Hash      string    `json:"hash"`
+	Name      string    `json:"name"`
+	Content   string    `json:"content,omitempty"`
+	Size      uint64    `json:"size"`
+}
+
+// CreateText stores a new text for the caller
+//
+// The body is {"name": "...", "content": "...", "expires_at": "..."}, with
+// expires_at in RFC 3339 and defaulting to 24 hours from now
+func (h *Handler) CreateText(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.principal(w, r)
+	if !ok {
+		return
+	}
+
+	jsonBody := &struct {
+		ExpiresAt string `json:"expires_at"`
+		Name      string `json:"name"`
+		Content   string `json:"content"`
+	}{}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxTextSize)
+
+	err := decodeJSON(r, jsonBody)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(24 * time.Hour)
+	if jsonBody.ExpiresAt != "" {
+		expiresAt, err = time.Parse(time.RFC3339, jsonBody.ExpiresAt)
+		if err != nil {
+			h.writeError(w, r, errInvalidExpiresAt.WithCause(err))
+			return
+		}
+	}
+
+	text, err := models.CreateText(jsonBody.Name, principal.UserID, expiresAt.UTC(), []byte(jsonBody.Content), h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeText(w, http.StatusCreated, text, false)
+}
+
+// GetText returns a text's metadata and content as JSON
+func (h *Handler) GetText(w http.ResponseWriter, r *http.Request) {
+	text, ok := h.findText(w, r, auth.ActionRead)
+	if !ok {
+		return
+	}
+
+	h.writeText(w, http.StatusOK, text, true)
+}
+
+// GetRawText returns a text's content as text/plain
+func (h *Handler) GetRawText(w http.ResponseWriter, r *http.Request) {
+	text, ok := h.findText(w, r, auth.ActionRead)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	_, err := w.Write(text.Data)
+	if err != nil {
+		h.Logger.Error("Error writing response", "error", err.Error())
+	}
+}
+
+// DeleteText moves a text to the trash
+func (h *Handler) DeleteText(w http.ResponseWriter, r *http.Request) {
+	text, ok := h.findText(w, r, auth.ActionDelete)
+	if !ok {
+		return
+	}
+
+	err := models.TrashItems(text.UserID, []string{text.Hash}, h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findText loads the text named by the hash path value and checks that
+// the caller may perform the action on it, and for reads that it has not
+// expired, so that owners can still delete expired texts
+//
+// If the text cannot be used, the error response is written and false is
+// returned
+func (h *Handler) findText(w http.ResponseWriter, r *http.Request, action auth.Action) (models.Text, bool) {
+	principal, ok := h.principal(w, r)
+	if !ok {
+		return models.Text{}, false
+	}
+
+	text, err := models.GetTextByHash(r.PathValue("hash"), h.SQLDatabase)
+	if err != nil {
+		h.writeError(w, r, err)
+		return models.Text{}, false
+	}
+
+	resource := auth.Resource{Kind: models.ITEM_KIND_TEXT, Hash: text.Hash, OwnerID: text.UserID}
+	if !h.authorize(w, r, principal, action, resource, models.ErrTextNotFound) {
+		return models.Text{}, false
+	}
+
+	if action == auth.ActionRead && text.IsExpired() {
+		h.writeError(w, r, models.ErrTextExpired)
+		return models.Text{}, false
+	}
+
+	return text, true
+}
+
+func (h *Handler) writeText(w http.ResponseWriter, status int, text models.Text, withContent bool) {
+	response := textResponse{
+		CreatedAt: text.CreatedAt,
+		ExpiresAt: text.ExpiresAt,
+		Hash:      text.Hash,
+		Name:      text.Name,
+		Size:      text.Size,
+	}
+
+	if withContent {
+		response.Content = string(text.Data)
+	}
+
+	h.writeJSON(w, status, response)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"riley/internal/auth"
+	"riley/internal/models"
+)
+
+func TestTexts(t *testing.T) {
+	h := createHandler()
+
+	user, err := models.UserCreate("testtexts@example.com", "password123%A%", h.Config.Password, h.SQLDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = user.Delete(false, h.SQLDatabase)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	token, err := auth.GenerateToken(time.Now().UTC().Add(time.Hour), user.ID, h.Keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(handler http.HandlerFunc, method string, hash string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/texts/"+hash, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.SetPathValue("hash", hash)
+		req.Header.Set("Authorization", token)
+		req = authenticate(t, h, req)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	rr := request(h.CreateText, "POST", "", []byte(`{"name": "log.txt", "content": "line 1\nline 2"}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	created := textResponse{}
+
+	err = json.Unmarshal(rr.Body.Bytes(), &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_, err = h.SQLDatabase.Exec("DELETE FROM texts WHERE hash = $1", created.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	t.Run("get text", func(t *testing.T) {
+		rr := request(h.GetText, "GET", created.Hash, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		text := textResponse{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if text.Content != "line 1\nline 2" || text.Name != "log.txt" {
+			t.Fatalf("handler returned wrong text: got %+v", text)
+		}
+	})
+
+	t.Run("get raw text", func(t *testing.T) {
+		rr := request(h.GetRawText, "GET", created.Hash, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		if rr.Body.String() != "line 1\nline 2" {
+			t.Fatalf("handler returned wrong body: got %q", rr.Body.String())
+		}
+	})
+
+	t.Run("invalid text", func(t *testing.T) {
+		rr := request(h.CreateText, "POST", "", []byte(`{"name": "empty.txt", "content": ""}`))
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("delete text", func(t *testing.T) {
+		rr := request(h.DeleteText, "DELETE", created.Hash, nil)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+
+		rr = request(h.GetText, "GET", created.Hash, nil)
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("delete expired text", func(t *testing.T) {
+		rr := request(h.CreateText, "POST", "", []byte(`{"name": "expired.txt", "content": "expired"}`))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+
+		expired := textResponse{}
+
+		err := json.Unmarshal(rr.Body.Bytes(), &expired)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer func() {
+			_, err = h.SQLDatabase.Exec("DELETE FROM texts WHERE hash = $1", expired.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		_, err = h.SQLDatabase.Exec("UPDATE texts SET expires_at = $1 WHERE hash = $2", time.Now().UTC().Add(-time.Hour), expired.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr = request(h.GetText, "GET", expired.Hash, nil)
+		if rr.Code != http.StatusGone {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusGone)
+		}
+
+		rr = request(h.DeleteText, "DELETE", expired.Hash, nil)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+	})
+}
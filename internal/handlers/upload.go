@@ -1,129 +1,235 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"strings"
 	"time"
 
-	"riley/internal/auth"
+	"riley/internal/apperror"
 	"riley/internal/models"
 )
 
-func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
-	if r.Body == nil {
-		w.WriteHeader(http.StatusBadRequest)
+const (
+	// maxFileSize is the largest file that can be uploaded, 100MB
+	maxFileSize = 100 << 20
 
-		_, err := w.Write([]byte("Request body is empty"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
+	// maxFieldSize is the largest value of a form field other than the file
+	maxFieldSize = 1 << 10
 
+	// maxUploadSize leaves room for the form fields and multipart framing
+	// around the file
+	maxUploadSize = maxFileSize + 1<<20
+)
+
+var (
+	errEmptyBody        = apperror.New(apperror.CodeBadRequest, "Request body is empty")
+	errInvalidForm      = apperror.New(apperror.CodeBadRequest, "Invalid multipart form")
+	errMissingFile      = apperror.New(apperror.CodeValidationFailed, "A file is required")
+	errDuplicateFile    = apperror.New(apperror.CodeValidationFailed, "Only one file can be uploaded per request")
+	errFileTooLarge     = apperror.New(apperror.CodeTooLarge, "File is too large, the maximum is 100MB")
+	errInvalidExpiresAt = apperror.New(apperror.CodeValidationFailed, "Invalid expires_at time, expected RFC 3339")
+)
+
+// Upload streams the file of a multipart form to storage, without holding
+// it in memory or on disk
+//
+// expires_at may come before or after the file in the form; it defaults to
+// 24 hours from now
+func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		h.writeError(w, r, errEmptyBody)
 		return
 	}
 
-	userID, err := auth.GetUserIDFromToken(r.Header.Get("Authorization"), h.Config.TokenSecret)
-	if err != nil {
-		h.Logger.Error("Error getting user ID from context", "error", err.Error())
+	principal, ok := h.principal(w, r)
+	if !ok {
+		return
+	}
 
-		w.WriteHeader(http.StatusInternalServerError)
-
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 
+	reader, err := r.MultipartReader()
+	if err != nil {
+		h.writeError(w, r, errInvalidForm.WithCause(err))
 		return
 	}
 
-	// Maximum file size is 100MB
-	err = r.ParseMultipartForm(100 << 20)
-	if err != nil {
-		h.Logger.Error("Error parsing multipart form", "error", err.Error())
+	var file *models.File
+	expiresAt := ""
 
-		w.WriteHeader(http.StatusInternalServerError)
+	// removeFile deletes the uploaded file when the rest of the form turns
+	// out to be invalid
+	removeFile := func() {
+		if file == nil {
+			return
+		}
 
-		_, err = w.Write([]byte("Internal server error"))
+		err := file.Delete(context.WithoutCancel(r.Context()), h.Storage, h.SQLDatabase)
 		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
+			h.Logger.Error("Error deleting rejected upload", "error", err.Error())
 		}
-
-		return
 	}
 
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		h.Logger.Error("Error getting file from form", "error", err.Error())
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			removeFile()
+			h.writeError(w, r, formError(err))
+			return
+		}
 
-		w.WriteHeader(http.StatusInternalServerError)
+		switch part.FormName() {
+		case "file":
+			if file != nil {
+				removeFile()
+				h.writeError(w, r, errDuplicateFile)
+				return
+			}
 
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
+			f, err := h.storeUpload(r, part, principal.UserID, expiresAt)
+			if err != nil {
+				h.writeError(w, r, err)
+				return
+			}
+
+			file = &f
+		case "expires_at":
+			value, err := readField(part)
+			if err != nil {
+				removeFile()
+				h.writeError(w, r, err)
+				return
+			}
+
+			expiresAt = value
+
+			if file != nil {
+				expiresAtTime, err := parseExpiresAt(expiresAt)
+				if err != nil {
+					removeFile()
+					h.writeError(w, r, err)
+					return
+				}
+
+				err = file.SetExpiresAt(expiresAtTime, h.SQLDatabase)
+				if err != nil {
+					removeFile()
+					h.writeError(w, r, err)
+					return
+				}
+			}
 		}
+	}
 
+	if file == nil {
+		h.writeError(w, r, errMissingFile)
 		return
 	}
-	defer file.Close()
-
-	var expiresAtTime time.Time
-	expiresAt := r.FormValue("expires_at")
-	if expiresAt == "" {
-		expiresAtTime = time.Now().UTC().Add(24 * time.Hour)
-	} else {
-		expiresAtTime, err = time.Parse(time.RFC3339, expiresAt)
-		if err != nil {
-			h.Logger.Error("Error parsing expires_at time", "error", err.Error())
 
-			w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(http.StatusCreated)
 
-			_, err = w.Write([]byte("Invalid expires_at time"))
-			if err != nil {
-				h.Logger.Error("Error writing response", "error", err.Error())
-			}
+	_, err = w.Write([]byte(file.Hash))
+	if err != nil {
+		h.Logger.Error("Error writing response", "error", err.Error())
+	}
+}
 
-			return
-		}
+// storeUpload streams the file part to storage, failing once it is larger
+// than maxFileSize
+func (h *Handler) storeUpload(r *http.Request, part *multipart.Part, userID uint64, expiresAt string) (models.File, error) {
+	expiresAtTime, err := parseExpiresAt(expiresAt)
+	if err != nil {
+		return models.File{}, err
 	}
 
 	f := models.File{
-		Name:      header.Filename,
-		Size:      uint64(header.Size),
+		Name:      part.FileName(),
 		UserID:    userID,
 		ExpiresAt: expiresAtTime,
 	}
 
-	fileContent, err := io.ReadAll(file)
-	if err != nil {
-		h.Logger.Error("Error reading file content", "error", err.Error())
+	// Reading one byte past the limit tells a file of exactly maxFileSize
+	// apart from a larger one
+	body := &bodyReader{r: part}
+	limited := &io.LimitedReader{R: body, N: maxFileSize + 1}
 
-		w.WriteHeader(http.StatusInternalServerError)
+	file, err := f.CreateFile(r.Context(), limited, h.Storage, h.SQLDatabase)
+	if body.err != nil {
+		return models.File{}, formError(body.err)
+	} else if err != nil {
+		return models.File{}, err
+	}
 
-		_, err = w.Write([]byte("Internal server error"))
+	if limited.N == 0 {
+		err = file.Delete(context.WithoutCancel(r.Context()), h.Storage, h.SQLDatabase)
 		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
+			h.Logger.Error("Error deleting rejected upload", "error", err.Error())
 		}
 
-		return
+		return models.File{}, errFileTooLarge
+	}
+
+	return file, nil
+}
+
+// parseExpiresAt parses the expires_at form field, defaulting to 24 hours
+// from now when it is empty
+func parseExpiresAt(value string) (time.Time, error) {
+	if value == "" {
+		return time.Now().UTC().Add(24 * time.Hour), nil
 	}
 
-	ff, err := f.CreateFile(&fileContent, h.Config.Storage, h.SQLDatabase)
+	expiresAt, err := time.Parse(time.RFC3339, value)
 	if err != nil {
-		h.Logger.Error("Error creating file", "error", err.Error())
+		return time.Time{}, errInvalidExpiresAt.WithCause(err)
+	}
 
-		w.WriteHeader(http.StatusInternalServerError)
+	return expiresAt.UTC(), nil
+}
 
-		_, err = w.Write([]byte("Internal server error"))
-		if err != nil {
-			h.Logger.Error("Error writing response", "error", err.Error())
-		}
+// readField reads the value of a small form field
+func readField(part io.Reader) (string, error) {
+	value, err := io.ReadAll(io.LimitReader(part, maxFieldSize+1))
+	if err != nil {
+		return "", formError(err)
+	}
 
-		return
+	if len(value) > maxFieldSize {
+		return "", errTooLarge
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	return strings.TrimSpace(string(value)), nil
+}
 
-	_, err = w.Write([]byte(ff.Hash))
-	if err != nil {
-		h.Logger.Error("Error writing response", "error", err.Error())
+// bodyReader records the error reading the request body, so that it can be
+// told apart from storage errors
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		b.err = err
 	}
+
+	return n, err
+}
+
+// formError maps errors reading the request body to errTooLarge when the
+// body exceeds maxUploadSize, and to errInvalidForm otherwise
+func formError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errTooLarge.WithCause(err)
+	}
+
+	return errInvalidForm.WithCause(err)
 }
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"log/slog"
 	"mime/multipart"
@@ -13,8 +14,10 @@ import (
 
 	"riley/internal/auth"
 	"riley/internal/config"
+	"riley/internal/mail"
 	"riley/internal/models"
 	"riley/internal/sql"
+	"riley/internal/storage"
 )
 
 func TestUpload(t *testing.T) {
@@ -45,18 +48,19 @@ func TestUpload(t *testing.T) {
 	h := createHandler()
 
 	// Create user
-	user, err := models.UserCreate("testupload@example.com", "password123%A%", h.SQLDatabase)
+	user, err := models.UserCreate("testupload@example.com", "password123%A%", h.Config.Password, h.SQLDatabase)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	token, err := auth.GenerateToken(time.Now().UTC().Add(1*time.Hour), user.ID, h.Config.TokenSecret)
+	token, err := auth.GenerateToken(time.Now().UTC().Add(1*time.Hour), user.ID, h.Keyring)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Set Authorization header
 	req.Header.Set("Authorization", token)
+	req = authenticate(t, h, req)
 
 	// Set content type
 	req.Header.Set("Content-Type", writer.FormDataContentType())
@@ -81,7 +85,7 @@ func TestUpload(t *testing.T) {
 	}
 
 	// Delete file
-	err = file.Delete(config.LoadTestConfig().Storage, h.SQLDatabase)
+	err = file.Delete(context.Background(), h.Storage, h.SQLDatabase)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -111,11 +115,47 @@ func createHandler() *Handler {
 		AddSource: true,
 	}))
 
+	keyring, err := auth.NewKeyring(config.LoadTestConfig().Token)
+	if err != nil {
+		panic(err)
+	}
+
+	revocations, err := auth.NewRevocationList(db)
+	if err != nil {
+		panic(err)
+	}
+
+	cipher, err := auth.NewSecretCipher(config.LoadTestConfig().MFA.EncryptionKey)
+	if err != nil {
+		panic(err)
+	}
+
+	store, err := storage.New(config.LoadTestConfig().Storage)
+	if err != nil {
+		panic(err)
+	}
+
 	h := Handler{
 		SQLDatabase: db,
 		Config:      config.LoadTestConfig(),
 		Logger:      logger,
+		Keyring:     keyring,
+		Revocations: revocations,
+		Cipher:      cipher,
+		Mailer:      mail.NewMemorySender(),
+		Storage:     store,
 	}
 
 	return &h
 }
+
+// authenticate sets the principal of the request's token in its context,
+// as the authentication middleware does
+func authenticate(t *testing.T, h *Handler, req *http.Request) *http.Request {
+	principal, err := auth.NewTokenAuthenticator(h.Keyring, h.Revocations, h.SQLDatabase).Authenticate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return req.WithContext(auth.WithPrincipal(req.Context(), principal))
+}
@@ -0,0 +1,155 @@
+// Package mail sends the emails riley needs, such as verification and
+// password reset links
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"riley/internal/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender sends emails
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewSender creates the sender selected in the config
+func NewSender(c config.MailConfig) (Sender, error) {
+	switch c.Sender {
+	case config.MAIL_SENDER_SMTP:
+		return NewSMTPSender(c), nil
+	case config.MAIL_SENDER_MEMORY:
+		return NewMemorySender(), nil
+	default:
+		return nil, fmt.Errorf("mail sender %q not supported", c.Sender)
+	}
+}
+
+// SMTPSender sends emails through an SMTP server
+//
+// Credentials are only sent over STARTTLS, which net/smtp enforces for
+// servers other than localhost
+type SMTPSender struct {
+	from     string
+	addr     string
+	host     string
+	username string
+	password string
+}
+
+func NewSMTPSender(c config.MailConfig) *SMTPSender {
+	return &SMTPSender{
+		from:     c.From,
+		addr:     net.JoinHostPort(c.SMTP.Host, strconv.Itoa(c.SMTP.Port)),
+		host:     c.SMTP.Host,
+		username: c.SMTP.Username,
+		password: c.SMTP.Password,
+	}
+}
+
+// Send sends the message
+//
+// net/smtp does not take a context, so the message is sent in the
+// background and Send returns when ctx is done
+func (s *SMTPSender) Send(ctx context.Context, message Message) error {
+	body, err := s.format(message)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.addr, auth, s.from, []string{message.To}, body)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *SMTPSender) format(message Message) ([]byte, error) {
+	// Header injection through addresses or subjects with line breaks
+	for _, header := range []string{s.from, message.To, message.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, errors.New("mail header contains a line break")
+		}
+	}
+
+	var b strings.Builder
+
+	b.WriteString("From: " + s.from + "\r\n")
+	b.WriteString("To: " + message.To + "\r\n")
+	b.WriteString("Subject: " + message.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	return []byte(b.String()), nil
+}
+
+// MemorySender keeps emails in memory instead of sending them, for tests
+// and local development
+type MemorySender struct {
+	messages []Message
+	mu       sync.Mutex
+}
+
+func NewMemorySender() *MemorySender {
+	return &MemorySender{}
+}
+
+func (s *MemorySender) Send(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, message)
+
+	return nil
+}
+
+// Messages returns the emails sent so far
+func (s *MemorySender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message{}, s.messages...)
+}
+
+// Last returns the last email sent to the address
+func (s *MemorySender) Last(to string) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return s.messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"riley/internal/config"
+)
+
+func TestMemorySender(t *testing.T) {
+	sender := NewMemorySender()
+
+	for _, to := range []string{"a@example.com", "b@example.com", "a@example.com"} {
+		err := sender.Send(context.Background(), Message{To: to, Subject: "Subject", Body: "Body " + to})
+		if err != nil {
+			t.Fatal("Testing send: Wanted nil, got", err)
+		}
+	}
+
+	if len(sender.Messages()) != 3 {
+		t.Error("Testing messages: Wanted 3, got", len(sender.Messages()))
+	}
+
+	message, ok := sender.Last("b@example.com")
+	if !ok || message.Body != "Body b@example.com" {
+		t.Error("Testing last message: Wanted Body b@example.com, got", message, ok)
+	}
+
+	_, ok = sender.Last("c@example.com")
+	if ok {
+		t.Error("Testing last message of other address: Wanted false, got true")
+	}
+}
+
+func TestSMTPFormat(t *testing.T) {
+	sender := NewSMTPSender(config.MailConfig{From: "riley@example.com"})
+
+	body, err := sender.format(Message{To: "user@example.com", Subject: "Hello", Body: "line 1\nline 2"})
+	if err != nil {
+		t.Fatal("Testing format: Wanted nil, got", err)
+	}
+
+	if !strings.Contains(string(body), "To: user@example.com\r\n") || !strings.HasSuffix(string(body), "\r\n\r\nline 1\r\nline 2") {
+		t.Error("Testing format: got", string(body))
+	}
+
+	_, err = sender.format(Message{To: "user@example.com\r\nBcc: other@example.com", Subject: "Hello"})
+	if err == nil {
+		t.Error("Testing format with header injection: Wanted error, got nil")
+	}
+}
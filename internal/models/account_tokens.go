@@ -0,0 +1,84 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"riley/internal/apperror"
+)
+
+const (
+	ACCOUNT_TOKEN_PURPOSE_EMAIL_VERIFICATION = "email_verification"
+	ACCOUNT_TOKEN_PURPOSE_PASSWORD_RESET     = "password_reset"
+)
+
+// ErrInvalidAccountToken is returned for account tokens that are unknown,
+// expired or already used
+var ErrInvalidAccountToken = apperror.New(apperror.CodeBadRequest, "Invalid or expired link")
+
+// AccountToken records a token sent by email, so that it can only be used
+// once
+//
+// ID is the jti of the signed token and Email the address it was sent to
+type AccountToken struct {
+	ExpiresAt time.Time
+	Purpose   string
+	Email     string
+	ID        string
+	UserID    uint64
+}
+
+// CreateAccountToken records a token before it is sent
+func CreateAccountToken(token AccountToken, db *sql.DB) error {
+	query := "" +
+		"INSERT INTO account_tokens (id, user_id, purpose, email, expires_at) " +
+		"VALUES ($1, $2, $3, $4, $5)"
+	_, err := db.Exec(query, token.ID, token.UserID, token.Purpose, token.Email, token.ExpiresAt.UTC())
+
+	return err
+}
+
+// UseAccountToken marks a token as used and returns it
+//
+// Returns ErrInvalidAccountToken if the user has no unused, unexpired
+// token with the ID and purpose
+func UseAccountToken(id string, purpose string, userID uint64, db *sql.DB) (AccountToken, error) {
+	token := AccountToken{}
+
+	query := "" +
+		"UPDATE account_tokens SET used_at = $4 " +
+		"WHERE id = $1 AND purpose = $2 AND user_id = $3 AND used_at IS NULL AND expires_at > $4 " +
+		"RETURNING id, user_id, purpose, email, expires_at"
+	err := db.QueryRow(query, id, purpose, userID, time.Now().UTC()).
+		Scan(&token.ID, &token.UserID, &token.Purpose, &token.Email, &token.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return token, ErrInvalidAccountToken
+	}
+
+	return token, err
+}
+
+// InvalidateAccountTokens marks the unused tokens of a user for the
+// purpose as used, such as the other reset links once the password was
+// reset
+func InvalidateAccountTokens(userID uint64, purpose string, db *sql.DB) error {
+	query := "" +
+		"UPDATE account_tokens SET used_at = CURRENT_TIMESTAMP " +
+		"WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL"
+	_, err := db.Exec(query, userID, purpose)
+
+	return err
+}
+
+// PurgeAccountTokens deletes tokens that expired before now
+func PurgeAccountTokens(now time.Time, db *sql.DB) (int, error) {
+	result, err := db.Exec("DELETE FROM account_tokens WHERE expires_at <= $1", now.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+
+	return int(purged), err
+}
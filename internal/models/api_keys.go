@@ -0,0 +1,226 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"riley/internal/apperror"
+
+	"github.com/lib/pq"
+)
+
+// apiKeyTouchInterval is how often the last use of an API key is recorded
+const apiKeyTouchInterval = time.Minute
+
+const (
+	API_KEY_SCOPE_FILES_READ  = "files:read"
+	API_KEY_SCOPE_FILES_WRITE = "files:write"
+	API_KEY_SCOPE_TEXTS_READ  = "texts:read"
+	API_KEY_SCOPE_TEXTS_WRITE = "texts:write"
+	API_KEY_SCOPE_ADMIN       = "admin"
+)
+
+var apiKeyScopes = []string{
+	API_KEY_SCOPE_FILES_READ,
+	API_KEY_SCOPE_FILES_WRITE,
+	API_KEY_SCOPE_TEXTS_READ,
+	API_KEY_SCOPE_TEXTS_WRITE,
+	API_KEY_SCOPE_ADMIN,
+}
+
+var (
+	// ErrAPIKeyNotFound is returned when no API key matches the lookup
+	ErrAPIKeyNotFound = apperror.New(apperror.CodeNotFound, "API key not found")
+
+	// ErrAPIKeyExpired is returned when an API key is used after it
+	// expired
+	ErrAPIKeyExpired = apperror.New(apperror.CodeUnauthorized, "API key has expired")
+
+	// ErrInvalidAPIKey is returned when the name, scopes or expiry of a
+	// new API key fail validation, with the failing fields in its details
+	ErrInvalidAPIKey = apperror.New(apperror.CodeValidationFailed, "Invalid API key")
+)
+
+// APIKey lets scripts act on behalf of a user, limited to its scopes
+//
+// Only the hash of the key is stored; Prefix is the start of the key,
+// kept so that users can tell their keys apart
+type APIKey struct {
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	Name       string
+	Prefix     string
+	Scopes     []string
+	ID         uint64
+	UserID     uint64
+}
+
+// IsExpired checks if the API key has an expiry that has passed
+func (k APIKey) IsExpired() bool {
+	return k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now().UTC())
+}
+
+// CreateAPIKey stores a new API key with the hash of the key
+func CreateAPIKey(key APIKey, keyHash string, db *sql.DB) (APIKey, error) {
+	key.Name = strings.TrimSpace(key.Name)
+
+	details := map[string]any{}
+
+	if key.Name == "" || len(key.Name) > 255 {
+		details["name"] = "must be between 1 and 255 characters"
+	}
+
+	if len(key.Scopes) == 0 {
+		details["scopes"] = "at least one scope is required"
+	}
+
+	for _, scope := range key.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			details["scopes"] = "must be one of " + strings.Join(apiKeyScopes, ", ")
+		}
+	}
+
+	if key.IsExpired() {
+		details["expires_at"] = "must be in the future"
+	}
+
+	if len(details) > 0 {
+		return key, ErrInvalidAPIKey.WithDetails(details)
+	}
+
+	slices.Sort(key.Scopes)
+	key.Scopes = slices.Compact(key.Scopes)
+
+	var expiresAt *time.Time
+	if key.ExpiresAt != nil {
+		utc := key.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	query := "" +
+		"INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) " +
+		"RETURNING id, created_at"
+	err := db.QueryRow(
+		query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		keyHash,
+		pq.Array(key.Scopes),
+		expiresAt,
+	).Scan(&key.ID, &key.CreatedAt)
+
+	return key, err
+}
+
+// GetAPIKeyByHash returns the API key stored under the hash
+func GetAPIKeyByHash(keyHash string, db *sql.DB) (APIKey, error) {
+	query := "" +
+		"SELECT id, created_at, expires_at, last_used_at, user_id, name, prefix, scopes " +
+		"FROM api_keys WHERE key_hash = $1"
+	key, err := scanAPIKey(db.QueryRow(query, keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrAPIKeyNotFound
+	}
+
+	return key, err
+}
+
+// GetUserAPIKeys returns the API keys of a user, newest first
+func GetUserAPIKeys(userID uint64, db *sql.DB) ([]APIKey, error) {
+	query := "" +
+		"SELECT id, created_at, expires_at, last_used_at, user_id, name, prefix, scopes " +
+		"FROM api_keys WHERE user_id = $1 " +
+		"ORDER BY created_at DESC, id DESC"
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey deletes one of a user's API keys
+//
+// Returns ErrAPIKeyNotFound if the user has no API key with the ID
+func DeleteAPIKey(id uint64, userID uint64, db *sql.DB) error {
+	query := "DELETE FROM api_keys WHERE id = $1 AND user_id = $2"
+	result, err := db.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// Touch records that the API key was used
+//
+// The update is skipped if the key was used in the last minute, so that
+// busy scripts do not write on every request. The check is made on the
+// loaded key first, and again in the query for concurrent requests
+func (k *APIKey) Touch(db *sql.DB) error {
+	now := time.Now().UTC()
+
+	if k.LastUsedAt != nil && k.LastUsedAt.After(now.Add(-apiKeyTouchInterval)) {
+		return nil
+	}
+
+	query := "" +
+		"UPDATE api_keys SET last_used_at = $2 " +
+		"WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $3)"
+	_, err := db.Exec(query, k.ID, now, now.Add(-apiKeyTouchInterval))
+	if err != nil {
+		return err
+	}
+
+	k.LastUsedAt = &now
+
+	return nil
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row scanner) (APIKey, error) {
+	var key APIKey
+
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+	)
+
+	return key, err
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AUDIT_ACTION_USER_SUSPEND        = "user.suspend"
+	AUDIT_ACTION_USER_UNSUSPEND      = "user.unsuspend"
+	AUDIT_ACTION_USER_PASSWORD_RESET = "user.password_reset"
+	AUDIT_ACTION_USER_ROLE           = "user.role"
+	AUDIT_ACTION_USER_DELETE         = "user.delete"
+	AUDIT_ACTION_MFA_POLICY          = "mfa.policy"
+)
+
+// maxAuditEntries limits how many audit log entries are returned at once
+const maxAuditEntries = 200
+
+// AuditEntry is an action an admin took
+//
+// TargetUserID and TargetEmail are kept after the target user is
+// deleted, and ActorID is nil once the admin is
+type AuditEntry struct {
+	CreatedAt    time.Time
+	ActorID      *uint64
+	TargetUserID *uint64
+	Details      map[string]any
+	ActorEmail   string
+	Action       string
+	TargetEmail  string
+	IP           string
+	ID           uint64
+}
+
+// AuditFilter selects audit log entries; zero fields match every entry
+//
+// Entries are returned newest first, starting before the ID Before
+type AuditFilter struct {
+	Action       string
+	ActorID      uint64
+	TargetUserID uint64
+	Before       uint64
+	Limit        int
+}
+
+// CreateAuditEntry records an admin action
+//
+// ActorEmail is looked up from ActorID if the admin still exists
+func CreateAuditEntry(entry AuditEntry, db *sql.DB) error {
+	if entry.Details == nil {
+		entry.Details = map[string]any{}
+	}
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+
+	query := "" +
+		"INSERT INTO audit_log (actor_id, actor_email, action, target_user_id, target_email, details, ip) " +
+		"VALUES ($1, COALESCE((SELECT email FROM users WHERE id = $1), $2), $3, $4, $5, $6, $7)"
+	_, err = db.Exec(
+		query,
+		entry.ActorID,
+		entry.ActorEmail,
+		entry.Action,
+		entry.TargetUserID,
+		entry.TargetEmail,
+		details,
+		truncate(entry.IP, 64),
+	)
+
+	return err
+}
+
+// GetAuditEntries returns the audit log entries matching the filter
+func GetAuditEntries(filter AuditFilter, db *sql.DB) ([]AuditEntry, error) {
+	conditions := []string{}
+	args := []any{}
+
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+
+	if filter.ActorID != 0 {
+		where("actor_id = $%d", filter.ActorID)
+	}
+
+	if filter.TargetUserID != 0 {
+		where("target_user_id = $%d", filter.TargetUserID)
+	}
+
+	if filter.Before != 0 {
+		where("id < $%d", filter.Before)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > maxAuditEntries {
+		limit = maxAuditEntries
+	}
+
+	query := "" +
+		"SELECT id, created_at, actor_id, actor_email, action, target_user_id, target_email, details, ip " +
+		"FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var (
+			entry   AuditEntry
+			details []byte
+		)
+
+		err = rows.Scan(
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.ActorEmail,
+			&entry.Action,
+			&entry.TargetUserID,
+			&entry.TargetEmail,
+			&details,
+			&entry.IP,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(details, &entry.Details)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
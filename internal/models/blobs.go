@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"strconv"
+
+	"riley/internal/apperror"
+	"riley/internal/storage"
+)
+
+// ErrBlobNotFound is returned when no blob matches the lookup
+var ErrBlobNotFound = apperror.New(apperror.CodeNotFound, "Blob not found")
+
+// Blob is content stored once under its SHA-256, shared by every file
+// with that content
+//
+// Refs counts the files that reference the blob; the content is deleted
+// when the last of them is
+type Blob struct {
+	Hash string
+	Size uint64
+	Refs uint64
+}
+
+// GetBlobByHash gets a blob by the hex SHA-256 of its content
+//
+// Returns an error if no file has the content
+func GetBlobByHash(hash string, db *sql.DB) (Blob, error) {
+	blob := Blob{}
+
+	query := "SELECT hash, size, refs FROM blobs WHERE hash = $1"
+	err := db.QueryRow(query, hash).Scan(&blob.Hash, &blob.Size, &blob.Refs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Blob{}, ErrBlobNotFound
+	} else if err != nil {
+		return Blob{}, err
+	}
+
+	return blob, nil
+}
+
+// lockBlob takes a transaction-level advisory lock on the hash, so that
+// storing content under it and deleting that content do not interleave,
+// even while the blob has no row to lock
+func lockBlob(ctx context.Context, hash string, tx *sql.Tx) error {
+	key, err := strconv.ParseUint(hash[:min(len(hash), 16)], 16, 64)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", int64(key))
+
+	return err
+}
+
+// acquireBlob adds a reference to the blob with the hash, stored by the
+// upload under uploadKey
+//
+// If the content is already stored, the upload is redundant and the
+// returned bool is true; the caller deletes it once the transaction is
+// committed. Otherwise the upload is moved to the key of the blob, and the
+// caller calls removeUnreferencedBlob if the transaction fails
+func acquireBlob(ctx context.Context, hash string, size uint64, uploadKey string, store storage.StorageInterface, tx *sql.Tx) (bool, error) {
+	err := lockBlob(ctx, hash, tx)
+	if err != nil {
+		return false, err
+	}
+
+	// Waits for a release of the blob in another transaction, so that
+	// its content is not deleted after it was found here
+	result, err := tx.Exec("UPDATE blobs SET refs = refs + 1 WHERE hash = $1", hash)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if updated > 0 {
+		return true, nil
+	}
+
+	err = storage.Move(ctx, store, uploadKey, hash)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec("INSERT INTO blobs (hash, size, refs) VALUES ($1, $2, 1)", hash, size)
+
+	return false, err
+}
+
+// releaseBlob removes a reference to the blob with the hash, deleting its
+// row if it was the last one
+//
+// The content is left in storage, since the transaction may still roll
+// back; when the returned bool is true, the caller calls
+// removeUnreferencedBlob once the transaction is committed
+func releaseBlob(hash string, tx *sql.Tx) (bool, error) {
+	var refs uint64
+
+	err := tx.QueryRow("UPDATE blobs SET refs = refs - 1 WHERE hash = $1 RETURNING refs", hash).Scan(&refs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if refs > 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec("DELETE FROM blobs WHERE hash = $1", hash)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// removeUnreferencedBlob deletes the content stored under the hash if no
+// blob references it
+//
+// It runs after the transaction that released the last reference was
+// committed, or after the one that stored the content rolled back. An
+// upload of the same content in the meantime recreated the blob, and its
+// content is kept
+func removeUnreferencedBlob(ctx context.Context, hash string, store storage.StorageInterface, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockBlob(ctx, hash, tx)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM blobs WHERE hash = $1)", hash).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	err = store.Delete(ctx, hash)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"riley/internal/config"
+	"riley/internal/sql"
+)
+
+func TestFileDeduplication(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("deduplicated content")
+
+	db := sql.Connect(config.LoadTestConfig())
+	store := newTestStorage(t)
+
+	user, err := UserCreate("testfilededuplication@example.com", "password123%A%", config.LoadTestConfig().Password, db)
+	if err != nil {
+		t.Fatalf("UserCreate returned an error: %s", err)
+	}
+
+	defer func() {
+		err = user.Delete(false, db)
+		if err != nil {
+			t.Fatalf("Delete returned an error: %s", err)
+		}
+	}()
+
+	f := File{
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+		Name:      "test.txt",
+		UserID:    user.ID,
+	}
+
+	file, err := f.CreateFile(ctx, bytes.NewReader(content), store, db)
+	if err != nil {
+		t.Fatalf("CreateFile returned an error: %s", err)
+	}
+
+	file2, err := f.CreateFile(ctx, bytes.NewReader(content), store, db)
+	if err != nil {
+		t.Fatalf("CreateFile returned an error: %s", err)
+	}
+
+	t.Run("files share the blob", func(t *testing.T) {
+		if file.Hash == file2.Hash {
+			t.Fatalf("Testing file hashes: Wanted distinct hashes, got %s twice", file.Hash)
+		}
+
+		if file.BlobHash != file.ContentHash || file2.BlobHash != file.BlobHash {
+			t.Fatalf("Testing blob hashes: Wanted %s, got %s and %s", file.ContentHash, file.BlobHash, file2.BlobHash)
+		}
+
+		blob, err := GetBlobByHash(file.BlobHash, db)
+		if err != nil {
+			t.Fatalf("GetBlobByHash returned an error: %s", err)
+		}
+
+		if blob.Refs != 2 {
+			t.Fatalf("Testing blob refs: Wanted %d, got %d", 2, blob.Refs)
+		}
+
+		err = store.Exists(ctx, "upload-"+file2.Hash)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("Testing duplicate upload: Wanted it deleted, got %v", err)
+		}
+	})
+
+	t.Run("delete keeps the blob while referenced", func(t *testing.T) {
+		err = file.Delete(ctx, store, db)
+		if err != nil {
+			t.Fatalf("Delete returned an error: %s", err)
+		}
+
+		blob, err := GetBlobByHash(file.BlobHash, db)
+		if err != nil {
+			t.Fatalf("GetBlobByHash returned an error: %s", err)
+		}
+
+		if blob.Refs != 1 {
+			t.Fatalf("Testing blob refs: Wanted %d, got %d", 1, blob.Refs)
+		}
+
+		err = store.Exists(ctx, file.BlobHash)
+		if err != nil {
+			t.Fatalf("Testing blob content: Wanted it stored, got %s", err)
+		}
+	})
+
+	t.Run("delete removes the last reference", func(t *testing.T) {
+		err = file2.Delete(ctx, store, db)
+		if err != nil {
+			t.Fatalf("Delete returned an error: %s", err)
+		}
+
+		_, err = GetBlobByHash(file.BlobHash, db)
+		if !errors.Is(err, ErrBlobNotFound) {
+			t.Fatalf("Testing GetBlobByHash: Wanted ErrBlobNotFound, got %v", err)
+		}
+
+		err = store.Exists(ctx, file.BlobHash)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("Testing blob content: Wanted it deleted, got %v", err)
+		}
+	})
+
+	t.Run("rolled back release keeps the content", func(t *testing.T) {
+		file, err := f.CreateFile(ctx, bytes.NewReader([]byte("released content")), store, db)
+		if err != nil {
+			t.Fatalf("CreateFile returned an error: %s", err)
+		}
+
+		defer func() {
+			err = file.Delete(ctx, store, db)
+			if err != nil {
+				t.Fatalf("Delete returned an error: %s", err)
+			}
+		}()
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		unreferenced, err := releaseBlob(file.BlobHash, tx)
+		if err != nil {
+			t.Fatalf("releaseBlob returned an error: %s", err)
+		}
+
+		if !unreferenced {
+			t.Fatalf("Testing releaseBlob: Wanted the last reference released")
+		}
+
+		err = tx.Rollback()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blob, err := GetBlobByHash(file.BlobHash, db)
+		if err != nil {
+			t.Fatalf("GetBlobByHash returned an error: %s", err)
+		}
+
+		if blob.Refs != 1 {
+			t.Fatalf("Testing blob refs: Wanted %d, got %d", 1, blob.Refs)
+		}
+
+		err = store.Exists(ctx, file.BlobHash)
+		if err != nil {
+			t.Fatalf("Testing blob content: Wanted it stored, got %s", err)
+		}
+	})
+
+	t.Run("rolled back upload removes the moved content", func(t *testing.T) {
+		uploadKey := "upload-testfilededuplication"
+		hash := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+		_, err := store.Put(ctx, uploadKey, bytes.NewReader([]byte("orphaned content")))
+		if err != nil {
+			t.Fatalf("Put returned an error: %s", err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = acquireBlob(ctx, hash, 16, uploadKey, store, tx)
+		if err != nil {
+			t.Fatalf("acquireBlob returned an error: %s", err)
+		}
+
+		err = tx.Rollback()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = removeUnreferencedBlob(ctx, hash, store, db)
+		if err != nil {
+			t.Fatalf("removeUnreferencedBlob returned an error: %s", err)
+		}
+
+		err = store.Exists(ctx, hash)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("Testing blob content: Wanted it deleted, got %v", err)
+		}
+	})
+}
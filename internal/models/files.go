@@ -1,18 +1,26 @@
 package models
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
-	"fmt"
+	"io"
+	"io/fs"
 	"time"
 
-	"riley/internal/config"
+	"riley/internal/apperror"
 	"riley/internal/storage"
+)
+
+var (
+	// ErrFileNotFound is returned when no file matches the lookup
+	ErrFileNotFound = apperror.New(apperror.CodeNotFound, "File not found")
 
-	"github.com/google/uuid"
+	// ErrFileExpired is returned when a file is past its expiry date
+	ErrFileExpired = apperror.New(apperror.CodeExpired, "File has expired")
 )
 
 type File struct {
@@ -23,93 +31,109 @@ type File struct {
 	ID        string
 	Name      string
 	Hash      string
-	Size      uint64
-	UserID    uint64
+	// ContentHash is the hex SHA-256 of the content
+	ContentHash string
+	// BlobHash is the hash of the blob the content is stored in, empty
+	// for files uploaded before content was deduplicated
+	BlobHash string
+	Size     uint64
+	UserID   uint64
 }
 
-// CreateFile creates a new file in the database
+// CreateFile streams the content read from r to storage and creates the
+// file in the database
 //
-// If the file is created successfully, the file is returned
-// If the file is not created successfully, an error is returned
-func (f *File) CreateFile(data *[]byte, storageConfig config.StorageConfigInterface, db *sql.DB) (File, error) {
-	fileHash, err := createFileHash(data)
+// The size and the SHA-256 of the content are computed while streaming,
+// so the content is never held in memory. The content is stored once
+// under its SHA-256: an upload of content that is already stored only
+// adds a reference to it. If the file cannot be created, the uploaded
+// content is deleted again
+func (f *File) CreateFile(ctx context.Context, r io.Reader, store storage.StorageInterface, db *sql.DB) (File, error) {
+	fileHash, err := newFileHash()
 	if err != nil {
 		return File{}, err
 	}
 
-	storage := storage.Storage{
-		FileDetails: storage.FileDetails{
-			Hash:          fileHash,
-			Size:          f.Size,
-			FileName:      f.Name,
-			FileContent:   data,
-			StorageType:   storageConfig.GetStorageType(),
-			StorageConfig: storageConfig,
-		},
-	}
+	// The content is uploaded under a key of its own, since its SHA-256
+	// is only known once it was read
+	uploadKey := "upload-" + fileHash
+	contentHasher := sha256.New()
 
-	query := "INSERT INTO files (expires_at, name, hash, size, user_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at"
-	err = db.QueryRow(
-		query, f.ExpiresAt, f.Name, fileHash, f.Size, f.UserID,
-	).Scan(
-		&f.ID, &f.CreatedAt, &f.UpdatedAt,
-	)
+	size, err := store.Put(ctx, uploadKey, io.TeeReader(r, contentHasher))
 	if err != nil {
+		// Remove what was written before the upload failed
+		_ = store.Delete(context.WithoutCancel(ctx), uploadKey)
 		return File{}, err
 	}
 
-	_, err = storage.Upload()
-	if err != nil {
-		return File{}, err
-	}
+	contentHash := hex.EncodeToString(contentHasher.Sum(nil))
 
 	file := File{
-		Hash:      storage.FileDetails.Hash,
-		Size:      storage.FileDetails.Size,
-		Name:      storage.FileDetails.FileName,
-		ID:        f.ID,
-		UserID:    f.UserID,
-		ExpiresAt: f.ExpiresAt,
-		CreatedAt: f.CreatedAt,
-		UpdatedAt: f.UpdatedAt,
+		ExpiresAt:   f.ExpiresAt,
+		Name:        f.Name,
+		Hash:        fileHash,
+		ContentHash: contentHash,
+		BlobHash:    contentHash,
+		Size:        uint64(size),
+		UserID:      f.UserID,
 	}
 
-	return file, nil
-}
-
-func createFileHash(data *[]byte) (string, error) {
-	hasher := sha256.New()
+	duplicate, err := file.insert(ctx, uploadKey, store, db)
+	if err != nil || duplicate {
+		// Does nothing if the upload was moved to the blob
+		_ = store.Delete(context.WithoutCancel(ctx), uploadKey)
+	}
 
-	now := fmt.Sprintf("%d", time.Now().UTC().UnixNano())
+	if err != nil {
+		// The upload may have been moved to the blob before the transaction
+		// failed
+		_ = removeUnreferencedBlob(context.WithoutCancel(ctx), file.BlobHash, store, db)
+		return File{}, err
+	}
 
-	rnd := ""
+	return file, nil
+}
 
-	uuid, err := uuid.NewV7()
+// insert creates the file in the database, referencing the blob of its
+// content, and returns whether the content was already stored
+func (f *File) insert(ctx context.Context, uploadKey string, store storage.StorageInterface, db *sql.DB) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		b := make([]byte, 16)
-		_, err := rand.Read(b)
-		if err != nil {
-			return "", err
-		}
-
-		rnd = hex.EncodeToString(b)
-	} else {
-		rnd = uuid.String()
+		return false, err
 	}
+	defer tx.Rollback()
 
-	if _, err := hasher.Write([]byte(rnd)); err != nil {
-		return "", err
+	duplicate, err := acquireBlob(ctx, f.BlobHash, f.Size, uploadKey, store, tx)
+	if err != nil {
+		return false, err
 	}
 
-	if _, err := hasher.Write([]byte(now)); err != nil {
-		return "", err
+	query := "" +
+		"INSERT INTO files (expires_at, name, hash, content_hash, blob_hash, size, user_id) VALUES ($1, $2, $3, $4, $5, $6, $7) " +
+		"RETURNING id, created_at, updated_at"
+	err = tx.QueryRow(
+		query, f.ExpiresAt, f.Name, f.Hash, f.ContentHash, f.BlobHash, f.Size, f.UserID,
+	).Scan(
+		&f.ID, &f.CreatedAt, &f.UpdatedAt,
+	)
+	if err != nil {
+		return false, err
 	}
 
-	if _, err := hasher.Write(*data); err != nil {
+	return duplicate, tx.Commit()
+}
+
+// newFileHash returns a random hash identifying a new file, used as its
+// public ID in download and share links
+func newFileHash() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
 		return "", err
 	}
 
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return hex.EncodeToString(b), nil
 }
 
 // GetFileByHash gets a file by the hash
@@ -119,37 +143,162 @@ func createFileHash(data *[]byte) (string, error) {
 func GetFileByHash(hash string, db *sql.DB) (File, error) {
 	file := File{}
 
-	query := "SELECT id, created_at, updated_at, expires_at, name, hash, size, user_id FROM files WHERE hash = $1"
-	err := db.QueryRow(query, hash).Scan(&file.ID, &file.CreatedAt, &file.UpdatedAt, &file.ExpiresAt, &file.Name, &file.Hash, &file.Size, &file.UserID)
+	query := "" +
+		"SELECT id, created_at, updated_at, expires_at, name, hash, content_hash, COALESCE(blob_hash, ''), size, user_id " +
+		"FROM files WHERE hash = $1 AND deleted_at IS NULL"
+	err := db.QueryRow(query, hash).Scan(
+		&file.ID, &file.CreatedAt, &file.UpdatedAt, &file.ExpiresAt, &file.Name, &file.Hash, &file.ContentHash, &file.BlobHash, &file.Size, &file.UserID,
+	)
 	if err != nil && err != sql.ErrNoRows {
 		return File{}, err
 	} else if err == sql.ErrNoRows {
-		return File{}, errors.New("file does not exist")
+		return File{}, ErrFileNotFound
 	}
 
 	return file, nil
 }
 
-// Delete deletes a file from the database using the ID
+// IsExpired checks if the file is past its expiry date
 //
-// Returns an error if the file does not exist
-func (f *File) Delete(c config.StorageConfigInterface, db *sql.DB) error {
-	query := "DELETE FROM files WHERE hash = $1"
-	_, err := db.Exec(query, f.Hash)
+// Files without an expiry date never expire
+func (f *File) IsExpired() bool {
+	return !f.ExpiresAt.IsZero() && time.Now().UTC().After(f.ExpiresAt)
+}
+
+// SetExpiresAt changes the expiry date of the file
+func (f *File) SetExpiresAt(expiresAt time.Time, db *sql.DB) error {
+	now := time.Now().UTC()
+
+	query := "UPDATE files SET expires_at = $1, updated_at = $2 WHERE hash = $3"
+	result, err := db.Exec(query, expiresAt, now, f.Hash)
 	if err != nil {
 		return err
 	}
 
-	s := storage.Storage{
-		FileDetails: storage.FileDetails{
-			Hash:          f.Hash,
-			FileName:      f.Name,
-			StorageType:   c.GetStorageType(),
-			StorageConfig: c,
-		},
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrFileNotFound
+	}
+
+	f.ExpiresAt = expiresAt
+	f.UpdatedAt = now
+
+	return nil
+}
+
+// Open opens the file content in the storage backend for reading
+//
+// The caller closes the reader
+func (f *File) Open(ctx context.Context, store storage.StorageInterface) (io.ReadSeekCloser, error) {
+	return store.Get(ctx, f.storageKey())
+}
+
+// storageKey returns the key the content of the file is stored under
+//
+// Files uploaded before content was deduplicated have no blob and are
+// stored under their own hash
+func (f *File) storageKey() string {
+	if f.BlobHash != "" {
+		return f.BlobHash
+	}
+
+	return f.Hash
+}
+
+// PresignedURL returns a URL that downloads the file directly from the
+// storage backend, or an empty string if the backend does not hand out
+// presigned URLs
+func (f *File) PresignedURL(ctx context.Context, store storage.StorageInterface) (string, error) {
+	presigner, ok := store.(storage.Presigner)
+	if !ok {
+		return "", nil
+	}
+
+	url, err := presigner.PresignGet(ctx, f.storageKey(), f.Name)
+	if errors.Is(err, storage.ErrPresignDisabled) {
+		return "", nil
+	}
+
+	return url, err
+}
+
+// Delete permanently deletes a file from the database, and its content
+// from storage if no other file has the same content
+//
+// The blob of the file is looked up in the database rather than taken
+// from f, so that only the hash needs to be set
+func (f *File) Delete(ctx context.Context, store storage.StorageInterface, db *sql.DB) error {
+	query := "DELETE FROM files WHERE hash = $1 RETURNING blob_hash"
+	_, err := f.delete(ctx, store, db, query, f.Hash)
+
+	return err
+}
+
+// purge deletes the file like Delete, but only if it is still in the
+// trash since before, so that a file restored after it was selected for
+// purging is kept
+//
+// Returns whether the file was deleted
+func (f *File) purge(ctx context.Context, before time.Time, store storage.StorageInterface, db *sql.DB) (bool, error) {
+	query := "DELETE FROM files WHERE hash = $1 AND deleted_at IS NOT NULL AND deleted_at <= $2 RETURNING blob_hash"
+
+	return f.delete(ctx, store, db, query, f.Hash, before.UTC())
+}
+
+// delete runs the query deleting the file row and, if it matched, deletes
+// the shares and content of the file in the same transaction
+func (f *File) delete(ctx context.Context, store storage.StorageInterface, db *sql.DB, query string, args ...any) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var blobHash sql.NullString
+
+	err = tx.QueryRow(query, args...).Scan(&blobHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec("DELETE FROM shares WHERE hash = $1", f.Hash)
+	if err != nil {
+		return false, err
+	}
+
+	if !blobHash.Valid {
+		err = store.Delete(ctx, f.Hash)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+
+		return true, tx.Commit()
+	}
+
+	unreferenced, err := releaseBlob(blobHash.String, tx)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	if unreferenced {
+		err = removeUnreferencedBlob(ctx, blobHash.String, store, db)
+		if err != nil {
+			return true, err
+		}
 	}
 
-	return s.Delete()
+	return true, nil
 }
 
 // GetFilesByUserID gets all files by the user ID
@@ -159,7 +308,9 @@ func (f *File) Delete(c config.StorageConfigInterface, db *sql.DB) error {
 func GetFilesByUserID(id uint64, db *sql.DB) ([]File, error) {
 	files := []File{}
 
-	query := "SELECT id, created_at, updated_at, expires_at, name, hash, size, user_id FROM files WHERE user_id = $1"
+	query := "" +
+		"SELECT id, created_at, updated_at, expires_at, name, hash, content_hash, COALESCE(blob_hash, ''), size, user_id " +
+		"FROM files WHERE user_id = $1 AND deleted_at IS NULL"
 	rows, err := db.Query(query, id)
 	if err != nil && err != sql.ErrNoRows {
 		return []File{}, err
@@ -169,7 +320,9 @@ func GetFilesByUserID(id uint64, db *sql.DB) ([]File, error) {
 
 	for rows.Next() {
 		var file File
-		err := rows.Scan(&file.ID, &file.CreatedAt, &file.UpdatedAt, &file.ExpiresAt, &file.Name, &file.Hash, &file.Size, &file.UserID)
+		err := rows.Scan(
+			&file.ID, &file.CreatedAt, &file.UpdatedAt, &file.ExpiresAt, &file.Name, &file.Hash, &file.ContentHash, &file.BlobHash, &file.Size, &file.UserID,
+		)
 		if err != nil {
 			return []File{}, err
 		}
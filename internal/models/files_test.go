@@ -1,21 +1,26 @@
 package models
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"testing"
 	"time"
 
 	"riley/internal/sql"
 
 	"riley/internal/config"
+	"riley/internal/storage"
 )
 
 func TestCreateFile(t *testing.T) {
 	fileContent := []byte("test")
 
-	storageConfig := config.LoadTestConfig().Storage
+	store := newTestStorage(t)
 
 	db := sql.Connect(config.LoadTestConfig())
-	user, err := UserCreate("exampleTestCreateFile@example.com", "password123%A%", db)
+	user, err := UserCreate("exampleTestCreateFile@example.com", "password123%A%", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
@@ -33,10 +38,11 @@ func TestCreateFile(t *testing.T) {
 		ExpiresAt: time.Now().UTC().Add(time.Hour),
 		Name:      "test.txt",
 		Hash:      "test",
+		Size:      uint64(len(fileContent)),
 		UserID:    user.ID,
 	}
 
-	file, err := f.CreateFile(&fileContent, storageConfig, db)
+	file, err := f.CreateFile(context.Background(), bytes.NewReader(fileContent), store, db)
 	if err != nil {
 		t.Fatalf("CreateFile returned an error: %s", err)
 	}
@@ -54,6 +60,11 @@ func TestCreateFile(t *testing.T) {
 			t.Fatalf("expected file size to be %d, got %d", f.Size, file.Size)
 		}
 
+		contentHash := sha256.Sum256(fileContent)
+		if file.ContentHash != hex.EncodeToString(contentHash[:]) {
+			t.Fatalf("expected content hash to be %x, got %s", contentHash, file.ContentHash)
+		}
+
 		if file.UserID != f.UserID {
 			t.Fatalf("expected user ID to be %d, got %d", f.UserID, file.UserID)
 		}
@@ -68,7 +79,7 @@ func TestCreateFile(t *testing.T) {
 	})
 
 	t.Run("delete file", func(t *testing.T) {
-		err = file.Delete(storageConfig, db)
+		err = file.Delete(context.Background(), store, db)
 		if err != nil {
 			t.Fatalf("Delete returned an error: %s", err)
 		}
@@ -80,7 +91,7 @@ func TestGetFileByHash(t *testing.T) {
 
 	db := sql.Connect(config.LoadTestConfig())
 
-	user, err := UserCreate("exampleTestGetFileByHash@example.com", "password123%A%", db)
+	user, err := UserCreate("exampleTestGetFileByHash@example.com", "password123%A%", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
@@ -102,9 +113,9 @@ func TestGetFileByHash(t *testing.T) {
 		UserID:    user.ID,
 	}
 
-	storageConfig := config.LoadTestConfig().Storage
+	store := newTestStorage(t)
 
-	file, err := f.CreateFile(&fileContent, storageConfig, db)
+	file, err := f.CreateFile(context.Background(), bytes.NewReader(fileContent), store, db)
 	if err != nil {
 		t.Fatalf("CreateFile returned an error: %s", err)
 	}
@@ -148,7 +159,7 @@ func TestGetFileByHash(t *testing.T) {
 	})
 
 	t.Run("delete file", func(t *testing.T) {
-		err = file.Delete(storageConfig, db)
+		err = file.Delete(context.Background(), store, db)
 		if err != nil {
 			t.Fatalf("Delete returned an error: %s", err)
 		}
@@ -160,7 +171,7 @@ func TestGetFilesByUserID(t *testing.T) {
 
 	db := sql.Connect(config.LoadTestConfig())
 
-	user, err := UserCreate("exampleTestGetFileByHash@example.com", "password123%A%", db)
+	user, err := UserCreate("exampleTestGetFileByHash@example.com", "password123%A%", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
@@ -182,14 +193,14 @@ func TestGetFilesByUserID(t *testing.T) {
 		UserID: user.ID,
 	}
 
-	storageConfig := config.LoadTestConfig().Storage
+	store := newTestStorage(t)
 
-	file, err := f.CreateFile(&fileContent, storageConfig, db)
+	file, err := f.CreateFile(context.Background(), bytes.NewReader(fileContent), store, db)
 	if err != nil {
 		t.Fatalf("CreateFile returned an error: %s", err)
 	}
 
-	user2, err := UserCreate("exampleTestGetFileByHash2@example.com", "password123%A%", db)
+	user2, err := UserCreate("exampleTestGetFileByHash2@example.com", "password123%A%", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
@@ -213,7 +224,7 @@ func TestGetFilesByUserID(t *testing.T) {
 		UserID:    user2.ID,
 	}
 
-	file2, err := f2.CreateFile(&fileContent2, storageConfig, db)
+	file2, err := f2.CreateFile(context.Background(), bytes.NewReader(fileContent2), store, db)
 	if err != nil {
 		t.Fatalf("CreateFile returned an error: %s", err)
 	}
@@ -262,12 +273,12 @@ func TestGetFilesByUserID(t *testing.T) {
 	})
 
 	t.Run("get files by user ID with no files", func(t *testing.T) {
-		err = file.Delete(storageConfig, db)
+		err = file.Delete(context.Background(), store, db)
 		if err != nil {
 			t.Fatalf("Delete returned an error: %s", err)
 		}
 
-		err = file2.Delete(storageConfig, db)
+		err = file2.Delete(context.Background(), store, db)
 		if err != nil {
 			t.Fatalf("Delete returned an error: %s", err)
 		}
@@ -279,7 +290,7 @@ func TestDeleteFile(t *testing.T) {
 
 	db := sql.Connect(config.LoadTestConfig())
 
-	user, err := UserCreate("exampleTestGetFileByHash@example.com", "password123%A%", db)
+	user, err := UserCreate("exampleTestGetFileByHash@example.com", "password123%A%", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
@@ -301,15 +312,15 @@ func TestDeleteFile(t *testing.T) {
 		UserID:    user.ID,
 	}
 
-	storageConfig := config.LoadTestConfig().Storage
+	store := newTestStorage(t)
 
-	file, err := f.CreateFile(&fileContent, storageConfig, db)
+	file, err := f.CreateFile(context.Background(), bytes.NewReader(fileContent), store, db)
 	if err != nil {
 		t.Fatalf("CreateFile returned an error: %s", err)
 	}
 
 	t.Run("delete file", func(t *testing.T) {
-		err = file.Delete(storageConfig, db)
+		err = file.Delete(context.Background(), store, db)
 		if err != nil {
 			t.Fatalf("Delete returned an error: %s", err)
 		}
@@ -321,3 +332,13 @@ func TestDeleteFile(t *testing.T) {
 		}
 	})
 }
+
+// newTestStorage returns the storage backend of the test config
+func newTestStorage(t *testing.T) storage.StorageInterface {
+	store, err := storage.New(config.LoadTestConfig().Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
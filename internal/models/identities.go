@@ -0,0 +1,178 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/config"
+	"riley/internal/passwords"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrIdentityNotFound is returned when no user is linked to an
+	// identity of an external provider
+	ErrIdentityNotFound = apperror.New(apperror.CodeNotFound, "Identity not found")
+
+	// ErrOIDCLoginNotFound is returned when the state of an OIDC callback
+	// does not match a pending login
+	ErrOIDCLoginNotFound = apperror.New(apperror.CodeBadRequest, "Invalid or expired login state")
+)
+
+// Identity links a user to the subject of an external identity provider
+type Identity struct {
+	CreatedAt time.Time
+	Issuer    string
+	Subject   string
+	Email     string
+	ID        uint64
+	UserID    uint64
+}
+
+// OIDCLogin is a login started at an OIDC provider, waiting for the
+// provider to redirect back with its state
+type OIDCLogin struct {
+	ExpiresAt    time.Time
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// GetIdentity returns the identity with the subject at the issuer
+func GetIdentity(issuer string, subject string, db *sql.DB) (Identity, error) {
+	identity := Identity{}
+
+	query := "SELECT id, created_at, user_id, issuer, subject, email FROM identities WHERE issuer = $1 AND subject = $2"
+	err := db.QueryRow(query, issuer, subject).Scan(
+		&identity.ID,
+		&identity.CreatedAt,
+		&identity.UserID,
+		&identity.Issuer,
+		&identity.Subject,
+		&identity.Email,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return identity, ErrIdentityNotFound
+	}
+
+	return identity, err
+}
+
+// CreateIdentity links an identity to an existing user
+func CreateIdentity(identity Identity, db *sql.DB) (Identity, error) {
+	query := "" +
+		"INSERT INTO identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4) " +
+		"RETURNING id, created_at"
+	err := db.QueryRow(query, identity.UserID, identity.Issuer, identity.Subject, identity.Email).
+		Scan(&identity.ID, &identity.CreatedAt)
+
+	return identity, err
+}
+
+// UserCreateFromIdentity creates a user for an identity and links them
+//
+// The user gets a random password, so they can only log in through the
+// provider, and their email counts as verified if the provider verified
+// it. If the email is already in use, ErrUserExists is returned
+func UserCreateFromIdentity(identity Identity, emailVerified bool, c config.PasswordHasherConfig, db *sql.DB) (User, error) {
+	user := User{}
+
+	if !UserEmailIsValid(identity.Email) {
+		return user, ErrInvalidUser.WithDetails(map[string]any{"email": "must be a valid email address"})
+	}
+
+	password := make([]byte, 32)
+
+	_, err := rand.Read(password)
+	if err != nil {
+		return user, err
+	}
+
+	hasher, err := passwords.NewHasher(c)
+	if err != nil {
+		return user, err
+	}
+
+	encryptedPassword, err := hasher.Hash(hex.EncodeToString(password))
+	if err != nil {
+		return user, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return user, err
+	}
+	defer tx.Rollback()
+
+	var emailVerifiedAt *time.Time
+	if emailVerified {
+		now := time.Now().UTC()
+		emailVerifiedAt = &now
+	}
+
+	query := "" +
+		"INSERT INTO users (email, password, email_verified_at) VALUES ($1, $2, $3) " +
+		"RETURNING id, created_at, updated_at, deleted_at, email_verified_at, role, active"
+	err = tx.
+		QueryRow(query, identity.Email, encryptedPassword, emailVerifiedAt).
+		Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.EmailVerifiedAt, &user.Role, &user.Active)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return user, ErrUserExists
+	} else if err != nil {
+		return user, err
+	}
+
+	query = "INSERT INTO identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4)"
+	_, err = tx.Exec(query, user.ID, identity.Issuer, identity.Subject, identity.Email)
+	if err != nil {
+		return user, err
+	}
+
+	user.Email = identity.Email
+
+	return user, tx.Commit()
+}
+
+// CreateOIDCLogin stores a pending login, dropping the ones that expired
+func CreateOIDCLogin(login OIDCLogin, db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM oidc_logins WHERE expires_at <= $1", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO oidc_logins (state, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4)"
+	_, err = db.Exec(query, login.State, login.Nonce, login.CodeVerifier, login.ExpiresAt.UTC())
+
+	return err
+}
+
+// ConsumeOIDCLogin removes and returns the pending login with the state,
+// so that a callback cannot be replayed
+//
+// Returns ErrOIDCLoginNotFound if there is none or it expired
+func ConsumeOIDCLogin(state string, db *sql.DB) (OIDCLogin, error) {
+	login := OIDCLogin{}
+
+	query := "" +
+		"DELETE FROM oidc_logins WHERE state = $1 " +
+		"RETURNING state, nonce, code_verifier, expires_at"
+	err := db.QueryRow(query, state).Scan(&login.State, &login.Nonce, &login.CodeVerifier, &login.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return login, ErrOIDCLoginNotFound
+	} else if err != nil {
+		return login, err
+	}
+
+	if login.ExpiresAt.Before(time.Now().UTC()) {
+		return login, ErrOIDCLoginNotFound
+	}
+
+	return login, nil
+}
@@ -0,0 +1,310 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"riley/internal/apperror"
+)
+
+const (
+	ITEM_KIND_FILE = "file"
+	ITEM_KIND_TEXT = "text"
+
+	ITEM_STATUS_ACTIVE  = "active"
+	ITEM_STATUS_EXPIRED = "expired"
+
+	ITEM_SORT_CREATED_AT = "created_at"
+	ITEM_SORT_EXPIRES_AT = "expires_at"
+	ITEM_SORT_SIZE       = "size"
+	ITEM_SORT_NAME       = "name"
+
+	ListDefaultLimit = 50
+	ListMaxLimit     = 200
+)
+
+// ErrInvalidListOptions is returned when the list options cannot be used
+// to build a query, e.g. an unknown sort column or a malformed cursor
+var ErrInvalidListOptions = apperror.New(apperror.CodeValidationFailed, "Invalid list options")
+
+// Item is a file or a text as shown in a listing
+type Item struct {
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+	DeletedAt *time.Time
+	Kind      string
+	Hash      string
+	Name      string
+	ID        uint64
+	Size      uint64
+}
+
+// IsExpired checks if the item is past its expiry date
+func (i *Item) IsExpired() bool {
+	return i.ExpiresAt != nil && time.Now().UTC().After(*i.ExpiresAt)
+}
+
+// ListOptions filters, sorts and paginates a listing
+//
+// Zero values mean "no filter"; SortBy defaults to created_at and Limit
+// defaults to ListDefaultLimit. Deleted lists the trash instead of the
+// live items
+type ListOptions struct {
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Kind          string
+	Name          string
+	Status        string
+	SortBy        string
+	Cursor        string
+	Limit         int
+	Descending    bool
+	Deleted       bool
+}
+
+// ListPage is a single page of a listing
+//
+// NextCursor is empty when there are no more items
+type ListPage struct {
+	Items      []Item
+	NextCursor string
+	Total      uint64
+	TotalFiles uint64
+	TotalTexts uint64
+}
+
+type listCursor struct {
+	SortBy     string `json:"s"`
+	Value      string `json:"v"`
+	Kind       string `json:"k"`
+	ID         uint64 `json:"i"`
+	Descending bool   `json:"d"`
+}
+
+// sortExpressions maps the sort columns to the SQL expression used for
+// ordering and the type the cursor value is cast back to
+var sortExpressions = map[string][2]string{
+	ITEM_SORT_CREATED_AT: {"created_at", "timestamp"},
+	ITEM_SORT_EXPIRES_AT: {"COALESCE(expires_at, 'infinity'::timestamp)", "timestamp"},
+	ITEM_SORT_SIZE:       {"size", "bigint"},
+	ITEM_SORT_NAME:       {"name", "text"},
+}
+
+// ListItems returns a page of files and texts owned by a user
+//
+// Items are ordered by the sort column, with kind and ID as tie breakers,
+// so cursors stay stable while items are added or removed
+func ListItems(userID uint64, opts ListOptions, db *sql.DB) (ListPage, error) {
+	page := ListPage{Items: []Item{}}
+
+	if opts.SortBy == "" {
+		opts.SortBy = ITEM_SORT_CREATED_AT
+	}
+
+	sortExpr, ok := sortExpressions[opts.SortBy]
+	if !ok {
+		return page, ErrInvalidListOptions.WithMessage(fmt.Sprintf("Unknown sort column %q", opts.SortBy))
+	}
+
+	if opts.Limit <= 0 {
+		opts.Limit = ListDefaultLimit
+	} else if opts.Limit > ListMaxLimit {
+		opts.Limit = ListMaxLimit
+	}
+
+	where, args, err := listFilters(userID, opts)
+	if err != nil {
+		return page, err
+	}
+
+	from := "" +
+		"(SELECT 'file' AS kind, id, hash, name, size, created_at, expires_at, user_id, deleted_at FROM files " +
+		"UNION ALL " +
+		"SELECT 'text' AS kind, id, hash, name, size, created_at, expires_at, user_id, deleted_at FROM texts) items"
+
+	countQuery := "SELECT kind, COUNT(*) FROM " + from + " WHERE " + strings.Join(where, " AND ") + " GROUP BY kind"
+	rows, err := db.Query(countQuery, args...)
+	if err != nil {
+		return page, err
+	}
+
+	for rows.Next() {
+		var (
+			kind  string
+			count uint64
+		)
+
+		err = rows.Scan(&kind, &count)
+		if err != nil {
+			rows.Close()
+			return page, err
+		}
+
+		switch kind {
+		case ITEM_KIND_FILE:
+			page.TotalFiles = count
+		case ITEM_KIND_TEXT:
+			page.TotalTexts = count
+		}
+	}
+
+	// Closed before the page query, so that it does not hold a second
+	// connection
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+
+	page.Total = page.TotalFiles + page.TotalTexts
+
+	direction, comparison := "ASC", ">"
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if opts.Cursor != "" {
+		cursor, err := decodeListCursor(opts.Cursor)
+		if err != nil {
+			return page, err
+		}
+
+		if cursor.SortBy != opts.SortBy || cursor.Descending != opts.Descending {
+			return page, ErrInvalidListOptions.WithMessage("Cursor does not match the requested sort order")
+		}
+
+		args = append(args, cursor.Value, cursor.Kind, cursor.ID)
+		where = append(where, fmt.Sprintf(
+			"(%s, kind, id) %s (CAST($%d AS %s), $%d, $%d)",
+			sortExpr[0], comparison, len(args)-2, sortExpr[1], len(args)-1, len(args),
+		))
+	}
+
+	args = append(args, opts.Limit+1)
+	query := fmt.Sprintf(
+		"SELECT kind, id, hash, name, size, created_at, expires_at, deleted_at, (%s)::text FROM %s WHERE %s ORDER BY %s %s, kind %s, id %s LIMIT $%d",
+		sortExpr[0], from, strings.Join(where, " AND "), sortExpr[0], direction, direction, direction, len(args),
+	)
+
+	itemRows, err := db.Query(query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer itemRows.Close()
+
+	var lastSortValue string
+
+	for itemRows.Next() {
+		var (
+			item      Item
+			sortValue string
+		)
+
+		err = itemRows.Scan(&item.Kind, &item.ID, &item.Hash, &item.Name, &item.Size, &item.CreatedAt, &item.ExpiresAt, &item.DeletedAt, &sortValue)
+		if err != nil {
+			return page, err
+		}
+
+		if len(page.Items) == opts.Limit {
+			last := page.Items[len(page.Items)-1]
+			page.NextCursor, err = encodeListCursor(listCursor{
+				SortBy:     opts.SortBy,
+				Value:      lastSortValue,
+				Kind:       last.Kind,
+				ID:         last.ID,
+				Descending: opts.Descending,
+			})
+			if err != nil {
+				return page, err
+			}
+
+			break
+		}
+
+		page.Items = append(page.Items, item)
+		lastSortValue = sortValue
+	}
+
+	return page, itemRows.Err()
+}
+
+func listFilters(userID uint64, opts ListOptions) ([]string, []any, error) {
+	where := []string{"user_id = $1", "deleted_at IS NULL"}
+	args := []any{userID}
+
+	if opts.Deleted {
+		where[1] = "deleted_at IS NOT NULL"
+	}
+
+	switch opts.Kind {
+	case "":
+	case ITEM_KIND_FILE, ITEM_KIND_TEXT:
+		args = append(args, opts.Kind)
+		where = append(where, fmt.Sprintf("kind = $%d", len(args)))
+	default:
+		return nil, nil, ErrInvalidListOptions.WithMessage(fmt.Sprintf("Unknown kind %q", opts.Kind))
+	}
+
+	if opts.Name != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(opts.Name)
+		args = append(args, "%"+escaped+"%")
+		where = append(where, fmt.Sprintf("name ILIKE $%d", len(args)))
+	}
+
+	switch opts.Status {
+	case "":
+	case ITEM_STATUS_ACTIVE:
+		args = append(args, time.Now().UTC())
+		where = append(where, fmt.Sprintf("(expires_at IS NULL OR expires_at > $%d)", len(args)))
+	case ITEM_STATUS_EXPIRED:
+		args = append(args, time.Now().UTC())
+		where = append(where, fmt.Sprintf("expires_at <= $%d", len(args)))
+	default:
+		return nil, nil, ErrInvalidListOptions.WithMessage(fmt.Sprintf("Unknown status %q", opts.Status))
+	}
+
+	if !opts.CreatedAfter.IsZero() {
+		args = append(args, opts.CreatedAfter.UTC())
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+
+	if !opts.CreatedBefore.IsZero() {
+		args = append(args, opts.CreatedBefore.UTC())
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	return where, args, nil
+}
+
+func encodeListCursor(c listCursor) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeListCursor(s string) (listCursor, error) {
+	c := listCursor{}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidListOptions.WithMessage("Malformed cursor")
+	}
+
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return c, ErrInvalidListOptions.WithMessage("Malformed cursor")
+	}
+
+	if c.Kind != ITEM_KIND_FILE && c.Kind != ITEM_KIND_TEXT {
+		return c, ErrInvalidListOptions.WithMessage("Malformed cursor")
+	}
+
+	return c, nil
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"riley/internal/config"
+	"riley/internal/sql"
+)
+
+func TestListItems(t *testing.T) {
+	db := sql.Connect(config.LoadTestConfig())
+	store := newTestStorage(t)
+
+	user, err := UserCreate("testlistitems@example.com", "password123%A%", config.LoadTestConfig().Password, db)
+	if err != nil {
+		t.Fatalf("UserCreate returned an error: %s", err)
+	}
+
+	defer func() {
+		err = user.Delete(false, db)
+		if err != nil {
+			t.Fatalf("Delete returned an error: %s", err)
+		}
+	}()
+
+	files := []File{}
+
+	for i := 0; i < 3; i++ {
+		content := []byte(fmt.Sprintf("list file %d", i))
+
+		f := File{
+			ExpiresAt: time.Now().UTC().Add(time.Hour),
+			Name:      fmt.Sprintf("report-%d.txt", i),
+			Size:      uint64(len(content)),
+			UserID:    user.ID,
+		}
+
+		file, err := f.CreateFile(context.Background(), bytes.NewReader(content), store, db)
+		if err != nil {
+			t.Fatalf("CreateFile returned an error: %s", err)
+		}
+
+		files = append(files, file)
+	}
+
+	defer func() {
+		for _, file := range files {
+			err = file.Delete(context.Background(), store, db)
+			if err != nil {
+				t.Fatalf("Delete returned an error: %s", err)
+			}
+		}
+	}()
+
+	text, err := CreateText("notes", user.ID, time.Now().UTC().Add(-time.Hour), []byte("expired text"), db)
+	if err != nil {
+		t.Fatalf("CreateText returned an error: %s", err)
+	}
+
+	defer func() {
+		err = text.Delete(db)
+		if err != nil {
+			t.Fatalf("Delete returned an error: %s", err)
+		}
+	}()
+
+	t.Run("paginate all items", func(t *testing.T) {
+		seen := map[string]bool{}
+		cursor := ""
+
+		for {
+			page, err := ListItems(user.ID, ListOptions{Limit: 3, Cursor: cursor}, db)
+			if err != nil {
+				t.Fatalf("ListItems returned an error: %s", err)
+			}
+
+			if page.Total != 4 || page.TotalFiles != 3 || page.TotalTexts != 1 {
+				t.Fatalf("expected totals 4/3/1, got %d/%d/%d", page.Total, page.TotalFiles, page.TotalTexts)
+			}
+
+			for _, item := range page.Items {
+				if seen[item.Hash] {
+					t.Fatalf("item %s returned twice", item.Hash)
+				}
+
+				seen[item.Hash] = true
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+
+			cursor = page.NextCursor
+		}
+
+		if len(seen) != 4 {
+			t.Fatalf("expected 4 items, got %d", len(seen))
+		}
+	})
+
+	t.Run("filter and sort", func(t *testing.T) {
+		page, err := ListItems(user.ID, ListOptions{
+			Kind:       ITEM_KIND_FILE,
+			Name:       "report",
+			SortBy:     ITEM_SORT_NAME,
+			Descending: true,
+		}, db)
+		if err != nil {
+			t.Fatalf("ListItems returned an error: %s", err)
+		}
+
+		if len(page.Items) != 3 {
+			t.Fatalf("expected 3 items, got %d", len(page.Items))
+		}
+
+		if page.Items[0].Name != "report-2.txt" {
+			t.Fatalf("expected report-2.txt first, got %s", page.Items[0].Name)
+		}
+	})
+
+	t.Run("filter expired", func(t *testing.T) {
+		page, err := ListItems(user.ID, ListOptions{Status: ITEM_STATUS_EXPIRED}, db)
+		if err != nil {
+			t.Fatalf("ListItems returned an error: %s", err)
+		}
+
+		if len(page.Items) != 1 || page.Items[0].Hash != text.Hash {
+			t.Fatalf("expected only the expired text, got %v", page.Items)
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := ListItems(user.ID, ListOptions{SortBy: "password"}, db)
+		if !errors.Is(err, ErrInvalidListOptions) {
+			t.Fatalf("expected ErrInvalidListOptions, got %v", err)
+		}
+
+		_, err = ListItems(user.ID, ListOptions{Cursor: "not a cursor"}, db)
+		if !errors.Is(err, ErrInvalidListOptions) {
+			t.Fatalf("expected ErrInvalidListOptions, got %v", err)
+		}
+	})
+}
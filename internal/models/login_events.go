@@ -0,0 +1,153 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	LOGIN_METHOD_PASSWORD = "password"
+	LOGIN_METHOD_MFA      = "mfa"
+	LOGIN_METHOD_OIDC     = "oidc"
+
+	LOGIN_FAILURE_INVALID_CREDENTIALS = "invalid_credentials"
+	LOGIN_FAILURE_INVALID_CODE        = "invalid_code"
+	LOGIN_FAILURE_LOCKED              = "locked"
+)
+
+// maxLoginEvents limits how many login events are returned at once
+const maxLoginEvents = 200
+
+// LoginEvent is a login attempt
+//
+// UserID is nil for attempts with an email no user had, and once the user
+// is deleted; Email keeps who the attempt was for. FailureReason is empty
+// for successful logins
+type LoginEvent struct {
+	CreatedAt     time.Time
+	UserID        *uint64
+	Email         string
+	Method        string
+	FailureReason string
+	IP            string
+	UserAgent     string
+	ID            uint64
+	Success       bool
+}
+
+// LoginEventFilter selects login events; zero fields match every event
+//
+// Events are returned newest first, starting before the ID Before
+type LoginEventFilter struct {
+	Success *bool
+	Email   string
+	UserID  uint64
+	Before  uint64
+	Limit   int
+}
+
+// CreateLoginEvent records a login attempt
+//
+// If UserID is nil, the event is linked to the user with the email, so
+// that users see failed attempts on their account
+func CreateLoginEvent(event LoginEvent, db *sql.DB) error {
+	query := "" +
+		"INSERT INTO login_events (user_id, email, method, success, failure_reason, ip, user_agent) " +
+		"VALUES (COALESCE($1, (SELECT id FROM users WHERE email = $2)), $2, $3, $4, $5, $6, $7)"
+	_, err := db.Exec(
+		query,
+		event.UserID,
+		truncate(event.Email, 255),
+		event.Method,
+		event.Success,
+		event.FailureReason,
+		truncate(event.IP, 64),
+		truncate(event.UserAgent, 512),
+	)
+
+	return err
+}
+
+// GetLoginEvents returns the login events matching the filter
+func GetLoginEvents(filter LoginEventFilter, db *sql.DB) ([]LoginEvent, error) {
+	conditions := []string{}
+	args := []any{}
+
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserID != 0 {
+		where("user_id = $%d", filter.UserID)
+	}
+
+	if filter.Email != "" {
+		where("email = $%d", filter.Email)
+	}
+
+	if filter.Success != nil {
+		where("success = $%d", *filter.Success)
+	}
+
+	if filter.Before != 0 {
+		where("id < $%d", filter.Before)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > maxLoginEvents {
+		limit = maxLoginEvents
+	}
+
+	query := "" +
+		"SELECT id, created_at, user_id, email, method, success, failure_reason, ip, user_agent " +
+		"FROM login_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []LoginEvent{}
+	for rows.Next() {
+		var event LoginEvent
+
+		err = rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.UserID,
+			&event.Email,
+			&event.Method,
+			&event.Success,
+			&event.FailureReason,
+			&event.IP,
+			&event.UserAgent,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// PurgeLoginEvents deletes the login events recorded before the time
+func PurgeLoginEvents(before time.Time, db *sql.DB) (int, error) {
+	result, err := db.Exec("DELETE FROM login_events WHERE created_at < $1", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+
+	return int(purged), err
+}
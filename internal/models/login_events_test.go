@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"riley/internal/config"
+	"riley/internal/sql"
+)
+
+func TestLoginEventsOutliveUser(t *testing.T) {
+	db := sql.Connect(config.LoadTestConfig())
+	email := "testlogineventsoutliveuser@example.com"
+
+	user, err := UserCreate(email, "password123%A%", config.LoadTestConfig().Password, db)
+	if err != nil {
+		t.Fatalf("UserCreate returned an error: %s", err)
+	}
+
+	defer func() {
+		_, err := db.Exec("DELETE FROM login_events WHERE email = $1", email)
+		if err != nil {
+			t.Fatalf("Deleting login events returned an error: %s", err)
+		}
+	}()
+
+	err = CreateLoginEvent(LoginEvent{Email: email, Method: LOGIN_METHOD_PASSWORD, Success: true}, db)
+	if err != nil {
+		t.Fatalf("CreateLoginEvent returned an error: %s", err)
+	}
+
+	err = user.Purge(context.Background(), newTestStorage(t), db)
+	if err != nil {
+		t.Fatalf("Purge returned an error: %s", err)
+	}
+
+	events, err := GetLoginEvents(LoginEventFilter{Email: email}, db)
+	if err != nil {
+		t.Fatalf("GetLoginEvents returned an error: %s", err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Testing login events: Wanted %d, got %d", 1, len(events))
+	}
+
+	if events[0].UserID != nil {
+		t.Fatalf("Testing login event user: Wanted nil, got %d", *events[0].UserID)
+	}
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/config"
+)
+
+// ErrAccountLocked is returned for logins to an account that is locked
+// after too many failed attempts
+var ErrAccountLocked = apperror.New(apperror.CodeRateLimited, "Too many failed logins, try again later")
+
+// Failed logins are counted by email rather than by user, so that unknown
+// emails are locked the same way and lockouts do not reveal which
+// accounts exist
+
+// GetLoginLockout returns until when logins with the email are locked,
+// or the zero time if they are not
+func GetLoginLockout(email string, db *sql.DB) (time.Time, error) {
+	var lockedUntil *time.Time
+
+	query := "SELECT locked_until FROM login_throttles WHERE email = $1"
+	err := db.QueryRow(query, throttleKey(email)).Scan(&lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) || lockedUntil == nil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	return *lockedUntil, nil
+}
+
+// RecordLoginFailure counts a failed login with the email and locks it if
+// there were too many
+//
+// Returns until when logins with the email are locked, or the zero time
+func RecordLoginFailure(email string, c config.LockoutConfig, db *sql.DB) (time.Time, error) {
+	// Postgres keeps microseconds, so the returned time matches the stored
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var failures int
+
+	query := "" +
+		"INSERT INTO login_throttles (email, failures, last_failure_at) VALUES ($1, 1, $2) " +
+		"ON CONFLICT (email) DO UPDATE SET " +
+		"failures = CASE WHEN login_throttles.last_failure_at < $3 THEN 1 ELSE login_throttles.failures + 1 END, " +
+		"last_failure_at = $2 " +
+		"RETURNING failures"
+	err = tx.QueryRow(query, throttleKey(email), now, now.Add(-c.ResetAfter)).Scan(&failures)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var lockedUntil time.Time
+
+	duration := LockoutDuration(failures, c)
+	if duration > 0 {
+		lockedUntil = now.Add(duration)
+
+		_, err = tx.Exec("UPDATE login_throttles SET locked_until = $2 WHERE email = $1", throttleKey(email), lockedUntil)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return lockedUntil, tx.Commit()
+}
+
+// ResetLoginFailures clears the failed logins with the email after a
+// successful login
+func ResetLoginFailures(email string, db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM login_throttles WHERE email = $1", throttleKey(email))
+
+	return err
+}
+
+// PurgeLoginThrottles deletes the failed logins that are too old to count
+// and are no longer locked
+func PurgeLoginThrottles(c config.LockoutConfig, db *sql.DB) (int, error) {
+	now := time.Now().UTC()
+
+	query := "" +
+		"DELETE FROM login_throttles " +
+		"WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $2)"
+	result, err := db.Exec(query, now.Add(-c.ResetAfter), now)
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+
+	return int(purged), err
+}
+
+// LockoutDuration returns how long to lock an account after the number of
+// consecutive failed logins
+func LockoutDuration(failures int, c config.LockoutConfig) time.Duration {
+	if failures < c.Threshold {
+		return 0
+	}
+
+	duration := c.BaseDuration
+	for i := c.Threshold; i < failures && duration < c.MaxDuration; i++ {
+		duration *= 2
+	}
+
+	return min(duration, c.MaxDuration)
+}
+
+func throttleKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"riley/internal/config"
+	"riley/internal/sql"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	c := config.LockoutConfig{
+		Threshold:    3,
+		BaseDuration: 30 * time.Second,
+		MaxDuration:  5 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, 30 * time.Second},
+		{4, time.Minute},
+		{5, 2 * time.Minute},
+		{6, 4 * time.Minute},
+		{7, 5 * time.Minute},
+		{1000, 5 * time.Minute},
+	}
+
+	for _, test := range tests {
+		got := LockoutDuration(test.failures, c)
+		if got != test.want {
+			t.Errorf("Testing lockout after %d failures: Wanted %s, got %s", test.failures, test.want, got)
+		}
+	}
+}
+
+func TestRecordLoginFailure(t *testing.T) {
+	db := sql.Connect(config.LoadTestConfig())
+
+	c := config.LockoutConfig{
+		Threshold:    2,
+		BaseDuration: time.Minute,
+		MaxDuration:  time.Hour,
+		ResetAfter:   time.Hour,
+	}
+
+	email := "TestRecordLoginFailure@example.com"
+
+	defer func() {
+		err := ResetLoginFailures(email, db)
+		if err != nil {
+			t.Fatalf("ResetLoginFailures returned an error: %s", err)
+		}
+	}()
+
+	lockedUntil, err := RecordLoginFailure(email, c, db)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure returned an error: %s", err)
+	}
+
+	if !lockedUntil.IsZero() {
+		t.Error("Testing lockout below the threshold: Wanted zero time, got", lockedUntil)
+	}
+
+	lockedUntil, err = RecordLoginFailure("testrecordloginfailure@example.com", c, db)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure returned an error: %s", err)
+	}
+
+	if !lockedUntil.After(time.Now().UTC()) {
+		t.Error("Testing lockout at the threshold: Wanted a time in the future, got", lockedUntil)
+	}
+
+	got, err := GetLoginLockout(email, db)
+	if err != nil {
+		t.Fatalf("GetLoginLockout returned an error: %s", err)
+	}
+
+	if !got.Equal(lockedUntil) {
+		t.Errorf("Testing get lockout: Wanted %s, got %s", lockedUntil, got)
+	}
+
+	err = ResetLoginFailures(email, db)
+	if err != nil {
+		t.Fatalf("ResetLoginFailures returned an error: %s", err)
+	}
+
+	got, err = GetLoginLockout(email, db)
+	if err != nil {
+		t.Fatalf("GetLoginLockout returned an error: %s", err)
+	}
+
+	if !got.IsZero() {
+		t.Error("Testing lockout after reset: Wanted zero time, got", got)
+	}
+}
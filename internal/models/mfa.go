@@ -0,0 +1,213 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"riley/internal/apperror"
+)
+
+var (
+	// ErrMFANotFound is returned when a user has not set up two-factor
+	// authentication
+	ErrMFANotFound = apperror.New(apperror.CodeNotFound, "Two-factor authentication is not set up")
+
+	// ErrMFAAlreadyEnabled is returned when enrolling a user who already
+	// has two-factor authentication enabled
+	ErrMFAAlreadyEnabled = apperror.New(apperror.CodeConflict, "Two-factor authentication is already enabled")
+
+	// ErrInvalidMFACode is returned for wrong, reused or expired codes
+	ErrInvalidMFACode = apperror.New(apperror.CodeUnauthorized, "Invalid two-factor authentication code")
+)
+
+// MFA is the TOTP enrollment of a user
+//
+// Secret is encrypted by the caller. Enrollments are pending until the
+// user confirms them with a code, which sets EnabledAt
+type MFA struct {
+	CreatedAt   time.Time
+	EnabledAt   *time.Time
+	Secret      string
+	LastCounter int64
+	UserID      uint64
+}
+
+// IsEnabled checks if the enrollment was confirmed
+func (m MFA) IsEnabled() bool {
+	return m.EnabledAt != nil
+}
+
+// GetMFA returns the TOTP enrollment of a user
+//
+// Returns ErrMFANotFound if the user has none
+func GetMFA(userID uint64, db *sql.DB) (MFA, error) {
+	mfa := MFA{}
+
+	query := "SELECT user_id, created_at, enabled_at, secret, last_counter FROM mfa WHERE user_id = $1"
+	err := db.QueryRow(query, userID).Scan(&mfa.UserID, &mfa.CreatedAt, &mfa.EnabledAt, &mfa.Secret, &mfa.LastCounter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return mfa, ErrMFANotFound
+	}
+
+	return mfa, err
+}
+
+// CreatePendingMFA starts a TOTP enrollment, replacing a pending one
+//
+// Returns ErrMFAAlreadyEnabled if the user has a confirmed enrollment
+func CreatePendingMFA(userID uint64, secret string, db *sql.DB) error {
+	query := "" +
+		"INSERT INTO mfa (user_id, secret) VALUES ($1, $2) " +
+		"ON CONFLICT (user_id) DO UPDATE SET secret = $2, created_at = CURRENT_TIMESTAMP, last_counter = 0 " +
+		"WHERE mfa.enabled_at IS NULL"
+	result, err := db.Exec(query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	created, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if created == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// EnableMFA confirms the pending enrollment of a user with the counter of
+// the code they entered and stores their recovery codes
+func EnableMFA(userID uint64, counter int64, recoveryCodeHashes []string, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "" +
+		"UPDATE mfa SET enabled_at = CURRENT_TIMESTAMP, last_counter = $2 " +
+		"WHERE user_id = $1 AND enabled_at IS NULL AND last_counter < $2"
+	result, err := tx.Exec(query, userID, counter)
+	if err != nil {
+		return err
+	}
+
+	enabled, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if enabled == 0 {
+		return ErrInvalidMFACode
+	}
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableMFA removes the TOTP enrollment and recovery codes of a user
+func DisableMFA(userID uint64, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM mfa WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPCounter records that the code of the counter was used
+//
+// Returns ErrInvalidMFACode if that code or a later one was already used,
+// so that an observed code cannot be replayed
+func UseTOTPCounter(userID uint64, counter int64, db *sql.DB) error {
+	query := "UPDATE mfa SET last_counter = $2 WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_counter < $2"
+	result, err := db.Exec(query, userID, counter)
+	if err != nil {
+		return err
+	}
+
+	used, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if used == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user
+func ReplaceRecoveryCodes(userID uint64, recoveryCodeHashes []string, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks a recovery code of a user as used
+//
+// Returns ErrInvalidMFACode if the user has no unused code with the hash
+func UseRecoveryCode(userID uint64, recoveryCodeHash string, db *sql.DB) error {
+	query := "" +
+		"UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP " +
+		"WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+	result, err := db.Exec(query, userID, recoveryCodeHash)
+	if err != nil {
+		return err
+	}
+
+	used, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if used == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID uint64, recoveryCodeHashes []string) error {
+	_, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec("INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
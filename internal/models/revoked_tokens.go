@@ -0,0 +1,87 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RevokedToken is an access token that must be rejected until it expires
+type RevokedToken struct {
+	ExpiresAt time.Time
+	ID        string
+}
+
+// GetRevokedTokens returns the revoked tokens that have not expired yet
+func GetRevokedTokens(now time.Time, db *sql.DB) ([]RevokedToken, error) {
+	query := "SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > $1"
+	rows, err := db.Query(query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []RevokedToken{}
+	for rows.Next() {
+		var token RevokedToken
+
+		err = rows.Scan(&token.ID, &token.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// PurgeRevokedTokens deletes revoked tokens that expired before now, as
+// they are rejected on their expiry anyway
+//
+// Returns the number of purged tokens
+func PurgeRevokedTokens(now time.Time, db *sql.DB) (int, error) {
+	query := "DELETE FROM revoked_tokens WHERE expires_at <= $1"
+	result, err := db.Exec(query, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+
+	return int(purged), err
+}
+
+func revokeTokens(tx *sql.Tx, tokens []RevokedToken) error {
+	now := time.Now().UTC()
+
+	for _, token := range tokens {
+		if token.ExpiresAt.Before(now) {
+			continue
+		}
+
+		query := "INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING"
+		_, err := tx.Exec(query, token.ID, token.ExpiresAt.UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeTokens revokes tokens outside of a session, such as used MFA
+// challenge tokens
+func RevokeTokens(tokens []RevokedToken, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = revokeTokens(tx, tokens)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
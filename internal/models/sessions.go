@@ -0,0 +1,306 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"riley/internal/apperror"
+)
+
+var (
+	// ErrSessionNotFound is returned when a session does not exist, was
+	// revoked or has expired
+	ErrSessionNotFound = apperror.New(apperror.CodeNotFound, "Session not found")
+
+	// ErrInvalidRefreshToken is returned when a refresh token does not
+	// belong to a live session
+	ErrInvalidRefreshToken = apperror.New(apperror.CodeUnauthorized, "Invalid refresh token")
+)
+
+// Session is a login of a user, kept alive by rotating refresh tokens
+//
+// Only the hash of the current refresh token is stored; AccessTokenID is
+// the jti of the last access token issued for the session. LastUsedAt,
+// UserAgent and IP are recorded whenever tokens are issued, so they are
+// accurate to the lifetime of an access token
+type Session struct {
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	LastUsedAt           time.Time
+	ExpiresAt            time.Time
+	AccessTokenExpiresAt time.Time
+	RevokedAt            *time.Time
+	AccessTokenID        string
+	UserAgent            string
+	IP                   string
+	ID                   uint64
+	UserID               uint64
+}
+
+// maxUserAgentLength is the size of the user_agent column
+const maxUserAgentLength = 512
+
+// CreateSession stores a new session with the hash of its refresh token
+func CreateSession(session Session, refreshTokenHash string, db *sql.DB) (Session, error) {
+	session.UserAgent = truncate(session.UserAgent, maxUserAgentLength)
+
+	query := "" +
+		"INSERT INTO sessions (user_id, expires_at, refresh_token_hash, access_token_id, access_token_expires_at, user_agent, ip) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7) " +
+		"RETURNING id, created_at, updated_at, last_used_at"
+	err := db.QueryRow(
+		query,
+		session.UserID,
+		session.ExpiresAt.UTC(),
+		refreshTokenHash,
+		session.AccessTokenID,
+		session.AccessTokenExpiresAt.UTC(),
+		session.UserAgent,
+		session.IP,
+	).Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt, &session.LastUsedAt)
+
+	return session, err
+}
+
+// GetUserSessions returns the live sessions of a user, most recently used
+// first
+func GetUserSessions(userID uint64, db *sql.DB) ([]Session, error) {
+	query := "" +
+		"SELECT id, created_at, updated_at, last_used_at, expires_at, user_id, access_token_id, access_token_expires_at, user_agent, ip " +
+		"FROM sessions " +
+		"WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2 " +
+		"ORDER BY last_used_at DESC, id DESC"
+	rows, err := db.Query(query, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+
+		err = rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.UpdatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+			&session.UserID,
+			&session.AccessTokenID,
+			&session.AccessTokenExpiresAt,
+			&session.UserAgent,
+			&session.IP,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RotateSession replaces the refresh token of the session it belongs to
+// and records the access token, user agent and IP of update
+//
+// The access token issued with the previous refresh token is revoked and
+// returned. Presenting a refresh token that was already rotated means it
+// leaked, so the whole session is revoked and ErrInvalidRefreshToken is
+// returned
+//
+// Sessions of suspended or deleted users are left as they are and
+// ErrInvalidRefreshToken is returned; the user row is locked until the
+// rotation is committed, so that a suspension waits for it
+func RotateSession(refreshTokenHash string, newRefreshTokenHash string, update Session, db *sql.DB) (Session, RevokedToken, error) {
+	now := time.Now().UTC()
+	update.UserAgent = truncate(update.UserAgent, maxUserAgentLength)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Session{}, RevokedToken{}, err
+	}
+	defer tx.Rollback()
+
+	var session Session
+	var previous RevokedToken
+
+	query := "" +
+		"SELECT sessions.id, sessions.created_at, sessions.expires_at, sessions.user_id, " +
+		"sessions.access_token_id, sessions.access_token_expires_at " +
+		"FROM sessions JOIN users ON users.id = sessions.user_id " +
+		"WHERE sessions.refresh_token_hash = $1 AND sessions.revoked_at IS NULL AND sessions.expires_at > $2 " +
+		"AND users.active = true " +
+		"FOR UPDATE OF sessions FOR SHARE OF users"
+	err = tx.QueryRow(query, refreshTokenHash, now).Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.ExpiresAt,
+		&session.UserID,
+		&previous.ID,
+		&previous.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		query = "" +
+			"UPDATE sessions SET revoked_at = $2, updated_at = $2 " +
+			"WHERE previous_refresh_token_hash = $1 AND revoked_at IS NULL " +
+			"RETURNING access_token_id, access_token_expires_at"
+
+		revoked, err := revokeSessions(tx, query, refreshTokenHash, now)
+		if err != nil {
+			return Session{}, RevokedToken{}, err
+		}
+
+		if len(revoked) > 0 {
+			err = tx.Commit()
+			if err != nil {
+				return Session{}, RevokedToken{}, err
+			}
+		}
+
+		return Session{}, RevokedToken{}, ErrInvalidRefreshToken
+	} else if err != nil {
+		return Session{}, RevokedToken{}, err
+	}
+
+	query = "" +
+		"UPDATE sessions " +
+		"SET previous_refresh_token_hash = refresh_token_hash, refresh_token_hash = $2, " +
+		"access_token_id = $3, access_token_expires_at = $4, user_agent = $5, ip = $6, " +
+		"last_used_at = $7, updated_at = $7 " +
+		"WHERE id = $1"
+	_, err = tx.Exec(query, session.ID, newRefreshTokenHash, update.AccessTokenID, update.AccessTokenExpiresAt.UTC(), update.UserAgent, update.IP, now)
+	if err != nil {
+		return Session{}, RevokedToken{}, err
+	}
+
+	err = revokeTokens(tx, []RevokedToken{previous})
+	if err != nil {
+		return Session{}, RevokedToken{}, err
+	}
+
+	session.UpdatedAt = now
+	session.LastUsedAt = now
+	session.AccessTokenID = update.AccessTokenID
+	session.AccessTokenExpiresAt = update.AccessTokenExpiresAt.UTC()
+	session.UserAgent = update.UserAgent
+	session.IP = update.IP
+
+	return session, previous, tx.Commit()
+}
+
+// RevokeSession revokes one of a user's sessions together with its
+// current access token, which is returned
+//
+// Returns ErrSessionNotFound if the user has no live session with the ID
+func RevokeSession(id uint64, userID uint64, db *sql.DB) (RevokedToken, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return RevokedToken{}, err
+	}
+	defer tx.Rollback()
+
+	query := "" +
+		"UPDATE sessions SET revoked_at = $3, updated_at = $3 " +
+		"WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > $3 " +
+		"RETURNING access_token_id, access_token_expires_at"
+	revoked, err := revokeSessions(tx, query, id, userID, time.Now().UTC())
+	if err != nil {
+		return RevokedToken{}, err
+	}
+
+	if len(revoked) == 0 {
+		return RevokedToken{}, ErrSessionNotFound
+	}
+
+	return revoked[0], tx.Commit()
+}
+
+// RevokeUserSessions revokes every live session of a user and returns
+// their access tokens, which are revoked as well
+func RevokeUserSessions(userID uint64, db *sql.DB) ([]RevokedToken, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := "" +
+		"UPDATE sessions SET revoked_at = $2, updated_at = $2 " +
+		"WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2 " +
+		"RETURNING access_token_id, access_token_expires_at"
+	revoked, err := revokeSessions(tx, query, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return revoked, tx.Commit()
+}
+
+// RevokeOtherUserSessions revokes the sessions of a user except one, such
+// as the sessions on other devices after a password change
+func RevokeOtherUserSessions(userID uint64, sessionID uint64, db *sql.DB) ([]RevokedToken, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := "" +
+		"UPDATE sessions SET revoked_at = $3, updated_at = $3 " +
+		"WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND expires_at > $3 " +
+		"RETURNING access_token_id, access_token_expires_at"
+	revoked, err := revokeSessions(tx, query, userID, sessionID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return revoked, tx.Commit()
+}
+
+// revokeSessions runs an update revoking sessions that returns their
+// access tokens, and adds those to the revoked tokens
+func revokeSessions(tx *sql.Tx, query string, args ...any) ([]RevokedToken, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := []RevokedToken{}
+	for rows.Next() {
+		var token RevokedToken
+
+		err = rows.Scan(&token.ID, &token.ExpiresAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		revoked = append(revoked, token)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revoked, revokeTokens(tx, revoked)
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8
+// sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+)
+
+// settingMFARequired is the setting that makes every user set up
+// two-factor authentication
+const settingMFARequired = "mfa_required"
+
+// IsMFARequired checks if admins require every user to use two-factor
+// authentication
+func IsMFARequired(db *sql.DB) (bool, error) {
+	value, err := getSetting(settingMFARequired, db)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// SetMFARequired sets whether every user must use two-factor
+// authentication
+func SetMFARequired(required bool, db *sql.DB) error {
+	return setSetting(settingMFARequired, strconv.FormatBool(required), db)
+}
+
+func getSetting(key string, db *sql.DB) (string, error) {
+	var value string
+
+	err := db.QueryRow("SELECT value FROM settings WHERE key = $1", key).Scan(&value)
+
+	return value, err
+}
+
+func setSetting(key string, value string, db *sql.DB) error {
+	query := "" +
+		"INSERT INTO settings (key, value) VALUES ($1, $2) " +
+		"ON CONFLICT (key) DO UPDATE SET value = $2, updated_at = CURRENT_TIMESTAMP"
+	_, err := db.Exec(query, key, value)
+
+	return err
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"riley/internal/apperror"
+)
+
+const (
+	SHARE_PERMISSION_READ  = "read"
+	SHARE_PERMISSION_WRITE = "write"
+)
+
+var (
+	// ErrShareNotFound is returned when an item is not shared with a user
+	ErrShareNotFound = apperror.New(apperror.CodeNotFound, "Share not found")
+
+	// ErrInvalidShare is returned when a share has an unknown permission
+	ErrInvalidShare = apperror.New(apperror.CodeValidationFailed, "Invalid share permission")
+)
+
+// Share grants a user other than the owner access to a file or a text
+type Share struct {
+	CreatedAt  time.Time
+	Hash       string
+	Permission string
+	ID         uint64
+	UserID     uint64
+}
+
+// CreateShare shares the item with the hash with a user, replacing the
+// permission of an existing share
+func CreateShare(hash string, userID uint64, permission string, db *sql.DB) (Share, error) {
+	share := Share{
+		Hash:       hash,
+		Permission: permission,
+		UserID:     userID,
+	}
+
+	if permission != SHARE_PERMISSION_READ && permission != SHARE_PERMISSION_WRITE {
+		return share, ErrInvalidShare
+	}
+
+	query := "" +
+		"INSERT INTO shares (hash, user_id, permission) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (hash, user_id) DO UPDATE SET permission = EXCLUDED.permission " +
+		"RETURNING id, created_at"
+	err := db.QueryRow(query, hash, userID, permission).Scan(&share.ID, &share.CreatedAt)
+
+	return share, err
+}
+
+// GetSharePermission returns the permission a user was given on an item
+//
+// Returns ErrShareNotFound if the item is not shared with the user
+func GetSharePermission(hash string, userID uint64, db *sql.DB) (string, error) {
+	var permission string
+
+	query := "SELECT permission FROM shares WHERE hash = $1 AND user_id = $2"
+	err := db.QueryRow(query, hash, userID).Scan(&permission)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrShareNotFound
+	}
+
+	return permission, err
+}
+
+// DeleteShare revokes a user's access to an item
+func DeleteShare(hash string, userID uint64, db *sql.DB) error {
+	query := "DELETE FROM shares WHERE hash = $1 AND user_id = $2"
+	_, err := db.Exec(query, hash, userID)
+
+	return err
+}
+
+// GetItemOwner returns the kind and owner of the live file or text with
+// the hash
+//
+// Returns ErrItemNotFound if there is none
+func GetItemOwner(hash string, db *sql.DB) (string, uint64, error) {
+	var (
+		kind   string
+		userID uint64
+	)
+
+	query := "" +
+		"SELECT 'file', user_id FROM files WHERE hash = $1 AND deleted_at IS NULL " +
+		"UNION ALL " +
+		"SELECT 'text', user_id FROM texts WHERE hash = $1 AND deleted_at IS NULL " +
+		"LIMIT 1"
+	err := db.QueryRow(query, hash).Scan(&kind, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, ErrItemNotFound
+	}
+
+	return kind, userID, err
+}
@@ -9,9 +9,22 @@ import (
 	"fmt"
 	"time"
 
+	"riley/internal/apperror"
+
 	"github.com/google/uuid"
 )
 
+var (
+	// ErrTextNotFound is returned when no text matches the lookup
+	ErrTextNotFound = apperror.New(apperror.CodeNotFound, "Text not found")
+
+	// ErrTextExpired is returned when a text is past its expiry date
+	ErrTextExpired = apperror.New(apperror.CodeExpired, "Text has expired")
+
+	// ErrInvalidText is returned when a text fails validation
+	ErrInvalidText = apperror.New(apperror.CodeValidationFailed, "Invalid text")
+)
+
 type Text struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -20,6 +33,7 @@ type Text struct {
 	ID        string
 	Name      string
 	Hash      string
+	Data      []byte
 	Size      uint64
 	UserID    uint64
 }
@@ -31,8 +45,9 @@ type Text struct {
 func CreateText(name string, userID uint64, expiresAt time.Time, data []byte, db *sql.DB) (Text, error) {
 	text := Text{}
 
-	if validateText(name, userID, expiresAt, data) != nil {
-		return text, errors.New("invalid text")
+	err := validateText(name, userID, expiresAt, data)
+	if err != nil {
+		return text, ErrInvalidText.WithDetails(map[string]any{"reason": err.Error()})
 	}
 
 	size := uint64(len(data))
@@ -42,9 +57,11 @@ func CreateText(name string, userID uint64, expiresAt time.Time, data []byte, db
 		return text, err
 	}
 
+	// data is passed as a string, lib/pq would otherwise encode the byte
+	// slice as bytea and store its hex representation in the text column
 	query := "INSERT INTO texts (expires_at, name, hash, size, user_id, data) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at"
 	err = db.QueryRow(
-		query, expiresAt, name, hash, size, userID, data,
+		query, expiresAt, name, hash, size, userID, string(data),
 	).Scan(
 		&text.ID, &text.CreatedAt, &text.UpdatedAt,
 	)
@@ -56,6 +73,7 @@ func CreateText(name string, userID uint64, expiresAt time.Time, data []byte, db
 	text.Name = name
 	text.Hash = hash
 	text.UserID = userID
+	text.Data = data
 	text.ExpiresAt = expiresAt
 
 	return text, nil
@@ -116,34 +134,47 @@ func validateText(name string, userID uint64, expiresAt time.Time, data []byte)
 	return nil
 }
 
-// GetTextByID gets a text by the ID
+// GetTextByHash gets a text, including its content, by the hash
 //
 // Returns the text if it exists
 // Returns an error if the text does not exist
 func GetTextByHash(hash string, db *sql.DB) (Text, error) {
 	text := Text{}
 
-	query := "SELECT id, created_at, updated_at, expires_at, name, hash, size, user_id FROM texts WHERE hash = $1"
-	err := db.QueryRow(query, hash).Scan(&text.ID, &text.CreatedAt, &text.UpdatedAt, &text.ExpiresAt, &text.Name, &text.Hash, &text.Size, &text.UserID)
+	query := "SELECT id, created_at, updated_at, expires_at, name, hash, size, user_id, data FROM texts WHERE hash = $1 AND deleted_at IS NULL"
+	err := db.QueryRow(query, hash).Scan(&text.ID, &text.CreatedAt, &text.UpdatedAt, &text.ExpiresAt, &text.Name, &text.Hash, &text.Size, &text.UserID, &text.Data)
 	if err != nil && err != sql.ErrNoRows {
 		return Text{}, err
 	} else if err == sql.ErrNoRows {
-		return Text{}, errors.New("text does not exist")
+		return Text{}, ErrTextNotFound
 	}
 
 	return text, nil
 }
 
-// Delete deletes a text from the database using the ID
+// IsExpired checks if the text is past its expiry date
+//
+// Texts without an expiry date never expire
+func (t *Text) IsExpired() bool {
+	return !t.ExpiresAt.IsZero() && time.Now().UTC().After(t.ExpiresAt)
+}
+
+// Delete permanently deletes a text from the database using the ID
 //
 // Returns an error if the text does not exist
 func (t *Text) Delete(db *sql.DB) error {
-	query := "DELETE FROM texts WHERE id = $1"
+	query := "DELETE FROM shares WHERE hash IN (SELECT hash FROM texts WHERE id = $1)"
 	_, err := db.Exec(query, t.ID)
 	if err != nil {
 		return err
 	}
 
+	query = "DELETE FROM texts WHERE id = $1"
+	_, err = db.Exec(query, t.ID)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -154,7 +185,7 @@ func (t *Text) Delete(db *sql.DB) error {
 func GetTextsByUserID(id uint64, db *sql.DB) ([]Text, error) {
 	texts := []Text{}
 
-	query := "SELECT id, created_at, updated_at, expires_at, name, hash, size, user_id FROM texts WHERE user_id = $1"
+	query := "SELECT id, created_at, updated_at, expires_at, name, hash, size, user_id FROM texts WHERE user_id = $1 AND deleted_at IS NULL"
 
 	rows, err := db.Query(query, id)
 	if err != nil && err != sql.ErrNoRows {
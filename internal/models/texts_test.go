@@ -14,7 +14,7 @@ func TestCreateText(t *testing.T) {
 	textContent := []byte("test")
 	textName := "test"
 
-	user, err := UserCreate("testcreatetext@example.com", "password123%%A", db)
+	user, err := UserCreate("testcreatetext@example.com", "password123%%A", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
@@ -78,7 +78,7 @@ func TestGetTextByID(t *testing.T) {
 	textContent := []byte("test2")
 	textName := "test2"
 
-	user, err := UserCreate("testgettextbyid@example.com", "password123%%A", db)
+	user, err := UserCreate("testgettextbyid@example.com", "password123%%A", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
@@ -150,7 +150,7 @@ func TestGetTextsByUserID(t *testing.T) {
 	textContent := []byte("test3")
 	textName := "test3"
 
-	user, err := UserCreate("testgettextsbyuserid@example.com", "password123%%A", db)
+	user, err := UserCreate("testgettextsbyuserid@example.com", "password123%%A", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
@@ -161,7 +161,7 @@ func TestGetTextsByUserID(t *testing.T) {
 		}
 	}()
 
-	otherUser, err := UserCreate("othertestgettextbyuserid@example.com", "password123%%A", db)
+	otherUser, err := UserCreate("othertestgettextbyuserid@example.com", "password123%%A", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
@@ -247,7 +247,7 @@ func TestTextDelete(t *testing.T) {
 	textContent := []byte("test5")
 	textName := "test5"
 
-	user, err := UserCreate("testtextdelete@example.com", "password123%%A", db)
+	user, err := UserCreate("testtextdelete@example.com", "password123%%A", config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatalf("UserCreate returned an error: %s", err)
 	}
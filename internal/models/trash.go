@@ -0,0 +1,192 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"riley/internal/apperror"
+	"riley/internal/storage"
+
+	"github.com/lib/pq"
+)
+
+// ErrItemNotFound is returned when one or more hashes do not match an item
+// owned by the caller
+var ErrItemNotFound = apperror.New(apperror.CodeNotFound, "Item not found")
+
+// TrashItems soft deletes files and texts owned by a user
+//
+// Either every hash is moved to the trash or none is; if any hash does not
+// belong to the user, ErrItemNotFound is returned with the missing hashes
+// in its details
+func TrashItems(userID uint64, hashes []string, db *sql.DB) error {
+	now := time.Now().UTC()
+
+	return updateItems(hashes, db, func(table string) string {
+		return "" +
+			"UPDATE " + table + " " +
+			"SET deleted_at = $3, updated_at = $3 " +
+			"WHERE user_id = $1 AND hash = ANY($2) AND deleted_at IS NULL " +
+			"RETURNING hash"
+	}, userID, pq.Array(hashes), now)
+}
+
+// RestoreItems takes files and texts owned by a user out of the trash
+//
+// Items deleted before the retention window started can no longer be
+// restored and are reported as missing
+func RestoreItems(userID uint64, hashes []string, retention time.Duration, db *sql.DB) error {
+	now := time.Now().UTC()
+
+	return updateItems(hashes, db, func(table string) string {
+		return "" +
+			"UPDATE " + table + " " +
+			"SET deleted_at = NULL, updated_at = $4 " +
+			"WHERE user_id = $1 AND hash = ANY($2) AND deleted_at IS NOT NULL AND deleted_at > $3 " +
+			"RETURNING hash"
+	}, userID, pq.Array(hashes), now.Add(-retention), now)
+}
+
+func updateItems(hashes []string, db *sql.DB, query func(table string) string, args ...any) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	updated := map[string]bool{}
+
+	for _, table := range []string{"files", "texts"} {
+		rows, err := tx.Query(query(table), args...)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var hash string
+
+			err = rows.Scan(&hash)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+
+			updated[hash] = true
+		}
+
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	missing := []string{}
+	for _, hash := range hashes {
+		if !updated[hash] && !slices.Contains(missing, hash) {
+			missing = append(missing, hash)
+		}
+	}
+
+	if len(missing) > 0 {
+		return ErrItemNotFound.WithDetails(map[string]any{"hashes": missing})
+	}
+
+	return tx.Commit()
+}
+
+// PurgeTrash permanently deletes items that were moved to the trash before
+// the given time, removing file content from storage as well
+//
+// Returns the number of purged items
+func PurgeTrash(ctx context.Context, before time.Time, store storage.StorageInterface, db *sql.DB) (int, error) {
+	purged := 0
+
+	query := "SELECT hash, name FROM files WHERE deleted_at IS NOT NULL AND deleted_at <= $1"
+	rows, err := db.Query(query, before.UTC())
+	if err != nil {
+		return purged, err
+	}
+
+	files := []File{}
+	for rows.Next() {
+		var file File
+
+		err = rows.Scan(&file.Hash, &file.Name)
+		if err != nil {
+			rows.Close()
+			return purged, err
+		}
+
+		files = append(files, file)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return purged, err
+	}
+
+	// Files restored since they were selected are skipped
+	for _, file := range files {
+		deleted, err := file.purge(ctx, before, store, db)
+		if err != nil {
+			return purged, err
+		}
+
+		if deleted {
+			purged++
+		}
+	}
+
+	texts, err := purgeTexts(before, db)
+	if err != nil {
+		return purged, err
+	}
+
+	return purged + texts, nil
+}
+
+// purgeTexts permanently deletes the texts that were moved to the trash
+// before the given time, with their shares
+func purgeTexts(before time.Time, db *sql.DB) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := "DELETE FROM texts WHERE deleted_at IS NOT NULL AND deleted_at <= $1 RETURNING hash"
+	rows, err := tx.Query(query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+
+		err = rows.Scan(&hash)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM shares WHERE hash = ANY($1)", pq.Array(hashes))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(hashes), tx.Commit()
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"riley/internal/config"
+	"riley/internal/sql"
+)
+
+func TestTrashItems(t *testing.T) {
+	db := sql.Connect(config.LoadTestConfig())
+	store := newTestStorage(t)
+
+	user, err := UserCreate("testtrashitems@example.com", "password123%A%", config.LoadTestConfig().Password, db)
+	if err != nil {
+		t.Fatalf("UserCreate returned an error: %s", err)
+	}
+
+	defer func() {
+		err = user.Delete(false, db)
+		if err != nil {
+			t.Fatalf("Delete returned an error: %s", err)
+		}
+	}()
+
+	other, err := UserCreate("testtrashitemsother@example.com", "password123%A%", config.LoadTestConfig().Password, db)
+	if err != nil {
+		t.Fatalf("UserCreate returned an error: %s", err)
+	}
+
+	defer func() {
+		err = other.Delete(false, db)
+		if err != nil {
+			t.Fatalf("Delete returned an error: %s", err)
+		}
+	}()
+
+	content := []byte("trash")
+
+	f := File{
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+		Name:      "trash.txt",
+		Size:      uint64(len(content)),
+		UserID:    user.ID,
+	}
+
+	file, err := f.CreateFile(context.Background(), bytes.NewReader(content), store, db)
+	if err != nil {
+		t.Fatalf("CreateFile returned an error: %s", err)
+	}
+
+	text, err := CreateText("trash", user.ID, time.Now().UTC().Add(time.Hour), []byte("trash"), db)
+	if err != nil {
+		t.Fatalf("CreateText returned an error: %s", err)
+	}
+
+	t.Run("other user cannot trash", func(t *testing.T) {
+		err = TrashItems(other.ID, []string{file.Hash}, db)
+		if !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("expected ErrItemNotFound, got %v", err)
+		}
+	})
+
+	t.Run("bulk trash is all or nothing", func(t *testing.T) {
+		err = TrashItems(user.ID, []string{file.Hash, "missing"}, db)
+		if !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("expected ErrItemNotFound, got %v", err)
+		}
+
+		_, err = GetFileByHash(file.Hash, db)
+		if err != nil {
+			t.Fatalf("expected file to still exist, got %v", err)
+		}
+	})
+
+	t.Run("trash and restore", func(t *testing.T) {
+		err = TrashItems(user.ID, []string{file.Hash, text.Hash}, db)
+		if err != nil {
+			t.Fatalf("TrashItems returned an error: %s", err)
+		}
+
+		_, err = GetFileByHash(file.Hash, db)
+		if !errors.Is(err, ErrFileNotFound) {
+			t.Fatalf("expected ErrFileNotFound, got %v", err)
+		}
+
+		err = RestoreItems(user.ID, []string{file.Hash}, time.Hour, db)
+		if err != nil {
+			t.Fatalf("RestoreItems returned an error: %s", err)
+		}
+
+		_, err = GetFileByHash(file.Hash, db)
+		if err != nil {
+			t.Fatalf("expected file to be restored, got %v", err)
+		}
+	})
+
+	t.Run("purge skips a file restored after it was selected", func(t *testing.T) {
+		err = TrashItems(user.ID, []string{file.Hash}, db)
+		if err != nil {
+			t.Fatalf("TrashItems returned an error: %s", err)
+		}
+
+		// The file as PurgeTrash selected it, before the restore
+		selected := File{Hash: file.Hash, Name: file.Name}
+
+		err = RestoreItems(user.ID, []string{file.Hash}, time.Hour, db)
+		if err != nil {
+			t.Fatalf("RestoreItems returned an error: %s", err)
+		}
+
+		deleted, err := selected.purge(context.Background(), time.Now().UTC().Add(time.Minute), store, db)
+		if err != nil {
+			t.Fatalf("purge returned an error: %s", err)
+		}
+
+		if deleted {
+			t.Fatalf("expected the restored file not to be purged")
+		}
+
+		_, err = GetFileByHash(file.Hash, db)
+		if err != nil {
+			t.Fatalf("expected the restored file to still exist, got %v", err)
+		}
+
+		r, err := file.Open(context.Background(), store)
+		if err != nil {
+			t.Fatalf("expected the restored file content to still exist, got %v", err)
+		}
+		r.Close()
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		err = TrashItems(user.ID, []string{file.Hash}, db)
+		if err != nil {
+			t.Fatalf("TrashItems returned an error: %s", err)
+		}
+
+		purged, err := PurgeTrash(context.Background(), time.Now().UTC().Add(time.Minute), store, db)
+		if err != nil {
+			t.Fatalf("PurgeTrash returned an error: %s", err)
+		}
+
+		if purged < 2 {
+			t.Fatalf("expected at least 2 purged items, got %d", purged)
+		}
+
+		err = RestoreItems(user.ID, []string{file.Hash}, time.Hour, db)
+		if !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("expected ErrItemNotFound, got %v", err)
+		}
+	})
+}
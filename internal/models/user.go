@@ -1,52 +1,155 @@
 package models
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"riley/internal/apperror"
+	"riley/internal/config"
+	"riley/internal/passwords"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUserNotFound is returned when no active user matches the lookup
+	ErrUserNotFound = apperror.New(apperror.CodeNotFound, "User not found")
+
+	// ErrUserExists is returned when the email is already in use
+	ErrUserExists = apperror.New(apperror.CodeConflict, "User already exists")
+
+	// ErrInvalidUser is returned when the email or password fail
+	// validation, with the failing fields in its details
+	ErrInvalidUser = apperror.New(apperror.CodeValidationFailed, "Invalid email or password")
+
+	// ErrInvalidCredentials is returned when a login does not match a user
+	ErrInvalidCredentials = apperror.New(apperror.CodeUnauthorized, "Invalid email or password")
+)
+
+const (
+	USER_ROLE_USER     = "user"
+	USER_ROLE_ADMIN    = "admin"
+	USER_ROLE_READONLY = "readonly"
 )
 
+// uniqueViolation is the Postgres error code for a unique constraint
+// violation
+const uniqueViolation = "23505"
+
 type User struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt *time.Time
-	Email     string
-	Password  string
-	Active    bool
-	ID        uint64
+	// EmailVerifiedAt is when the user proved they own the email, or nil
+	EmailVerifiedAt *time.Time
+	Email           string
+	Password        string
+	Role            string
+	Active          bool
+	ID              uint64
 }
 
 // UserCheckLogin checks if a user can log in
 //
-// If the email is invalid, an error is returned
-// If the password is invalid, an error is returned
+// If the email does not belong to an active user or the password is
+// wrong, 0 is returned without an error. Unknown emails are checked
+// against a dummy hash, so that the response time does not reveal which
+// accounts exist
 //
-// If the user can log in, the user ID is returned
-func UserCheckLogin(email string, password string, db *sql.DB) (uint64, error) {
+// If the user can log in, the user ID is returned. Their password is
+// rehashed if it was hashed with another algorithm or other parameters
+// than the configured ones
+func UserCheckLogin(email string, password string, c config.PasswordHasherConfig, db *sql.DB) (uint64, error) {
 	var (
 		encryptedPassword string
 		userID            uint64
 	)
 
-	query := "SELECT id, password FROM users WHERE email = $1"
-	err := db.QueryRow(query, email).Scan(&userID, &encryptedPassword)
-	if err != nil && err != sql.ErrNoRows {
+	hasher, err := passwords.NewHasher(c)
+	if err != nil {
 		return 0, err
-	} else if err == sql.ErrNoRows {
-		return 0, nil
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(encryptedPassword), []byte(password))
+	query := "SELECT id, password FROM users WHERE email = $1 AND active = true"
+	err = db.QueryRow(query, email).Scan(&userID, &encryptedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		encryptedPassword, err = dummyPasswordHash(c, hasher)
+		if err != nil {
+			return 0, err
+		}
+
+		userID = 0
+	} else if err != nil {
+		return 0, err
+	}
+
+	ok, err := passwords.Verify(password, encryptedPassword)
 	if err != nil {
 		return 0, err
 	}
 
+	if !ok || userID == 0 {
+		return 0, nil
+	}
+
+	if hasher.NeedsRehash(encryptedPassword) {
+		err = setPasswordHash(userID, password, hasher, db)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	return userID, nil
 }
 
+// dummyPasswordHashes caches, by hasher config, a hash no password
+// matches, made with the cost of real passwords
+var dummyPasswordHashes sync.Map
+
+func dummyPasswordHash(c config.PasswordHasherConfig, hasher passwords.Hasher) (string, error) {
+	if hash, ok := dummyPasswordHashes.Load(c); ok {
+		return hash.(string), nil
+	}
+
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := hasher.Hash(hex.EncodeToString(b))
+	if err != nil {
+		return "", err
+	}
+
+	dummyPasswordHashes.Store(c, hash)
+
+	return hash, nil
+}
+
+// GetUserByID gets an active user by the ID
+//
+// Returns ErrUserNotFound if the user does not exist
+func GetUserByID(id uint64, db *sql.DB) (User, error) {
+	user := User{}
+
+	query := "SELECT id, created_at, updated_at, deleted_at, email_verified_at, email, role, active FROM users WHERE id = $1 AND active = true"
+	err := db.QueryRow(query, id).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.EmailVerifiedAt, &user.Email, &user.Role, &user.Active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	} else if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
 // UserExists checks if a user exists in the database
 //
 // If the email is invalid, an error is returned
@@ -65,21 +168,40 @@ func UserExists(email string, db *sql.DB) error {
 
 // UserCreate creates a new user in the database
 //
-// If the email is invalid, an error is returned
-// If the email is already in use, an error is returned
-// If the password is invalid, an error is returned
+// If the email or password is invalid, ErrInvalidUser is returned
+// If the email is already in use, ErrUserExists is returned
 //
 // If the user is created successfully, it is returned
-func UserCreate(email string, password string, db *sql.DB) (User, error) {
+func UserCreate(email string, password string, c config.PasswordConfig, db *sql.DB) (User, error) {
 	user := User{}
 
 	password = strings.TrimSpace(password)
 
-	if !UserCreateValidation(email, password) {
-		return user, errors.New("invalid email or password, password must be at least 8 characters long and contain at least one uppercase letter, one lowercase letter, one number, and one special character")
+	details := map[string]any{}
+
+	if !UserEmailIsValid(email) {
+		details["email"] = "must be a valid email address"
 	}
 
-	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	requirement, err := passwords.CheckPolicy(password, passwords.Policy(c))
+	if err != nil {
+		return user, err
+	}
+
+	if requirement != "" {
+		details["password"] = requirement
+	}
+
+	if len(details) > 0 {
+		return user, ErrInvalidUser.WithDetails(details)
+	}
+
+	hasher, err := passwords.NewHasher(c.Hasher)
+	if err != nil {
+		return user, err
+	}
+
+	encryptedPassword, err := hasher.Hash(password)
 	if err != nil {
 		return user, err
 	}
@@ -87,17 +209,148 @@ func UserCreate(email string, password string, db *sql.DB) (User, error) {
 	query := "" +
 		"INSERT INTO users (email, password)" +
 		"VALUES ($1, $2)" +
-		"RETURNING id, created_at, updated_at, deleted_at, active"
+		"RETURNING id, created_at, updated_at, deleted_at, role, active"
 	err = db.
 		QueryRow(query, email, encryptedPassword).
-		Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.Active)
-	if err != nil {
+		Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.Role, &user.Active)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return user, ErrUserExists
+	} else if err != nil {
 		return user, err
 	}
 
+	user.Email = email
+
 	return user, nil
 }
 
+// IsEmailVerified checks if the user verified their email
+func (u User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// VerifyEmail marks the email of the user as verified
+//
+// Returns ErrUserNotFound if the user no longer has the email, so that a
+// link sent before an email change does not verify the new one
+func (u *User) VerifyEmail(email string, db *sql.DB) error {
+	now := time.Now().UTC()
+
+	query := "UPDATE users SET email_verified_at = $3, updated_at = $3 WHERE id = $1 AND email = $2 AND active = true"
+	result, err := db.Exec(query, u.ID, email, now)
+	if err != nil {
+		return err
+	}
+
+	verified, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if verified == 0 {
+		return ErrUserNotFound
+	}
+
+	u.EmailVerifiedAt = &now
+
+	return nil
+}
+
+// CheckPassword checks the password of the user
+//
+// Returns ErrInvalidCredentials if it does not match
+func (u *User) CheckPassword(password string, db *sql.DB) error {
+	var encryptedPassword string
+
+	query := "SELECT password FROM users WHERE id = $1 AND active = true"
+	err := db.QueryRow(query, u.ID).Scan(&encryptedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidCredentials
+	} else if err != nil {
+		return err
+	}
+
+	ok, err := passwords.Verify(password, encryptedPassword)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// SetPassword validates and changes the password of the user
+//
+// Returns ErrInvalidUser if the password is invalid
+func (u *User) SetPassword(password string, c config.PasswordConfig, db *sql.DB) error {
+	password = strings.TrimSpace(password)
+
+	err := ValidatePassword(password, c)
+	if err != nil {
+		return err
+	}
+
+	hasher, err := passwords.NewHasher(c.Hasher)
+	if err != nil {
+		return err
+	}
+
+	return setPasswordHash(u.ID, password, hasher, db)
+}
+
+// ValidatePassword checks that a new password meets the requirements of
+// the policy
+//
+// Returns ErrInvalidUser if it does not
+func ValidatePassword(password string, c config.PasswordConfig) error {
+	requirement, err := passwords.CheckPolicy(strings.TrimSpace(password), passwords.Policy(c))
+	if err != nil {
+		return err
+	}
+
+	if requirement != "" {
+		return ErrInvalidUser.WithDetails(map[string]any{"password": requirement})
+	}
+
+	return nil
+}
+
+func setPasswordHash(userID uint64, password string, hasher passwords.Hasher, db *sql.DB) error {
+	encryptedPassword, err := hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE users SET password = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2"
+	_, err = db.Exec(query, encryptedPassword, userID)
+
+	return err
+}
+
+// SetRole changes the role of a user
+//
+// Returns ErrInvalidUser if the role is unknown
+func (u *User) SetRole(role string, db *sql.DB) error {
+	if role != USER_ROLE_USER && role != USER_ROLE_ADMIN && role != USER_ROLE_READONLY {
+		return ErrInvalidUser.WithDetails(map[string]any{"role": "must be user, admin or readonly"})
+	}
+
+	query := "UPDATE users SET role = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2"
+	_, err := db.Exec(query, role, u.ID)
+	if err != nil {
+		return err
+	}
+
+	u.Role = role
+
+	return nil
+}
+
 // Delete deletes a user from the database using the ID
 //
 // If soft is true, the user is soft deleted
@@ -155,14 +408,41 @@ func (u *User) Exists(db *sql.DB) bool {
 
 // GetFiles returns all the files associated with a user
 //
-// Returns a slice of Files
+// Returns a slice of Files, or nil if they cannot be loaded
 func (u *User) GetFiles(db *sql.DB) []File {
-	return nil
+	files, err := GetFilesByUserID(u.ID, db)
+	if err != nil {
+		return nil
+	}
+
+	return files
 }
 
 // GetTexts returns all the texts associated with a user
 //
-// Returns a slice of Texts
+// Returns a slice of Texts, or nil if they cannot be loaded
 func (u *User) GetTexts(db *sql.DB) []Text {
-	return nil
+	texts, err := GetTextsByUserID(u.ID, db)
+	if err != nil {
+		return nil
+	}
+
+	return texts
+}
+
+// GetUserByEmail gets an active user by the email
+//
+// Returns ErrUserNotFound if the user does not exist
+func GetUserByEmail(email string, db *sql.DB) (User, error) {
+	user := User{}
+
+	query := "SELECT id, created_at, updated_at, deleted_at, email_verified_at, email, role, active FROM users WHERE email = $1 AND active = true"
+	err := db.QueryRow(query, email).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.EmailVerifiedAt, &user.Email, &user.Role, &user.Active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	} else if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"testing"
 
 	"riley/internal/config"
@@ -13,7 +14,7 @@ func TestUserCreate(t *testing.T) {
 	email := "testemailsignup@example.com"
 	password := "password123%A%"
 
-	user, err := UserCreate(email, password, db)
+	user, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Error("Testing signup: Wanted nil, got", err)
 	}
@@ -30,7 +31,7 @@ func TestUserCreate(t *testing.T) {
 	}()
 
 	// Test signup with existing email
-	user2, err := UserCreate(email, password, db)
+	user2, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 	if err == nil {
 		t.Error("Testing signup with existing email: Wanted error, got nil")
 
@@ -47,7 +48,7 @@ func TestSignupWithAllSpacesPassword(t *testing.T) {
 	email := "email@exampleallspaces.com"
 	password := "        p3%A"
 
-	user, err := UserCreate(email, password, db)
+	user, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 	if err == nil {
 		t.Error("Testing signup with all spaces password: Wanted error, got nil")
 
@@ -68,7 +69,7 @@ func TestUserCreateWithWrongEmailNoDomain(t *testing.T) {
 	email := "testemailsignupwithwrongemail"
 	password := "password"
 
-	user, err := UserCreate(email, password, db)
+	user, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 	if err == nil {
 		t.Error("Testing signup with wrong email: Wanted error, got nil")
 
@@ -89,7 +90,7 @@ func TestUserCreateWithWrongEmailNoAt(t *testing.T) {
 	email := "testemailsignupwithwrongemail.com"
 	password := "password"
 
-	user, err := UserCreate(email, password, db)
+	user, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 	if err == nil {
 		t.Error("Testing signup with wrong email: Wanted error, got nil")
 
@@ -116,7 +117,7 @@ func TestUserCreateWithWrongPassword(t *testing.T) {
 	}
 
 	for _, password := range passwords {
-		user, err := UserCreate(email, password, db)
+		user, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 		if err == nil {
 			t.Error("Testing signup with wrong password: Wanted error, got nil")
 
@@ -145,7 +146,7 @@ func TestUserCreateWithCorrectPassword(t *testing.T) {
 	}
 
 	for _, password := range passwords {
-		user, err := UserCreate(email, password, db)
+		user, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 		if err != nil {
 			t.Error("Testing signup with correct password: Wanted nil, got", err)
 		}
@@ -167,7 +168,7 @@ func TestUserDeleteSoft(t *testing.T) {
 	email := "testuserdeletesoft@example.com"
 	password := "password123!§$%AA"
 
-	user, err := UserCreate(email, password, db)
+	user, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Fatal("Signup during delete soft test failed: Wanted nil, got", err)
 	}
@@ -197,7 +198,7 @@ func TestUserDelete(t *testing.T) {
 	email := "testemaildelete@example.com"
 	password := "password123$$AA"
 
-	user, err := UserCreate(email, password, db)
+	user, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Error("Signup during delete test failed: Wanted nil, got", err)
 	}
@@ -214,7 +215,7 @@ func TestUserCheckLogin(t *testing.T) {
 	email := "wrong@example.com"
 	password := "password123$$AA"
 
-	userID, err := UserCheckLogin(email, password, db)
+	userID, err := UserCheckLogin(email, password, config.LoadTestConfig().Password.Hasher, db)
 	if err != nil {
 		t.Error("Testing check login with wrong email and password: Wanted nil, got", err)
 	}
@@ -223,7 +224,7 @@ func TestUserCheckLogin(t *testing.T) {
 		t.Error("Testing check login with wrong email and password: Wanted 0, got", userID)
 	}
 
-	userID, err = UserCheckLogin("", password, db)
+	userID, err = UserCheckLogin("", password, config.LoadTestConfig().Password.Hasher, db)
 	if err != nil {
 		t.Error("Testing check login with empty email: Wanted nil, got", err)
 	}
@@ -232,7 +233,7 @@ func TestUserCheckLogin(t *testing.T) {
 		t.Error("Testing check login with empty email: Wanted 0, got", userID)
 	}
 
-	userID, err = UserCheckLogin(email, "", db)
+	userID, err = UserCheckLogin(email, "", config.LoadTestConfig().Password.Hasher, db)
 	if err != nil {
 		t.Error("Testing check login with empty password: Wanted nil, got", err)
 	}
@@ -241,7 +242,7 @@ func TestUserCheckLogin(t *testing.T) {
 		t.Error("Testing check login with empty password: Wanted 0, got", userID)
 	}
 
-	userID, err = UserCheckLogin("", "", db)
+	userID, err = UserCheckLogin("", "", config.LoadTestConfig().Password.Hasher, db)
 	if err != nil {
 		t.Error("Testing check login with empty email and password: Wanted nil, got", err)
 	}
@@ -253,17 +254,69 @@ func TestUserCheckLogin(t *testing.T) {
 	email = "correct@example.com"
 	password = "correctpasswordpassword123$$AA"
 
-	user, err := UserCreate(email, password, db)
+	user, err := UserCreate(email, password, config.LoadTestConfig().Password, db)
 	if err != nil {
 		t.Error("Signup during login test failed: Wanted nil, got", err)
 	}
 
-	if userID, err := UserCheckLogin(email, password, db); userID == 0 && err != nil {
+	if userID, err := UserCheckLogin(email, password, config.LoadTestConfig().Password.Hasher, db); userID == 0 && err != nil {
 		t.Error("Testing check login with correct email and password: Wanted true, got false")
 	}
 
+	userID, err = UserCheckLogin(email, "wrongpasswordpassword123$$AA", config.LoadTestConfig().Password.Hasher, db)
+	if err != nil {
+		t.Error("Testing check login with wrong password: Wanted nil, got", err)
+	}
+
+	if userID != 0 {
+		t.Error("Testing check login with wrong password: Wanted 0, got", userID)
+	}
+
 	err = user.Delete(false, db)
 	if err != nil {
 		t.Error("Delete user during login test failed: Wanted nil, got", err)
 	}
 }
+
+func TestUserCheckLoginRehash(t *testing.T) {
+	db := sql.Connect(config.LoadTestConfig())
+
+	email := "testrehash@example.com"
+	password := "password123%A%"
+
+	c := config.LoadTestConfig().Password
+	c.Hasher.Algorithm = config.PASSWORD_HASHER_BCRYPT
+
+	user, err := UserCreate(email, password, c, db)
+	if err != nil {
+		t.Fatalf("UserCreate returned an error: %s", err)
+	}
+
+	defer func() {
+		err = user.Delete(false, db)
+		if err != nil {
+			t.Fatalf("Delete returned an error: %s", err)
+		}
+	}()
+
+	userID, err := UserCheckLogin(email, password, config.LoadTestConfig().Password.Hasher, db)
+	if err != nil || userID != user.ID {
+		t.Fatalf("Testing check login with bcrypt hash: Wanted %d, got %d (%v)", user.ID, userID, err)
+	}
+
+	var hash string
+
+	err = db.QueryRow("SELECT password FROM users WHERE id = $1", user.ID).Scan(&hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Error("Testing rehash after login: Wanted argon2id hash, got", hash)
+	}
+
+	userID, err = UserCheckLogin(email, password, config.LoadTestConfig().Password.Hasher, db)
+	if err != nil || userID != user.ID {
+		t.Errorf("Testing check login with rehashed password: Wanted %d, got %d (%v)", user.ID, userID, err)
+	}
+}
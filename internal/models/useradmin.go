@@ -0,0 +1,285 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"riley/internal/config"
+	"riley/internal/passwords"
+	"riley/internal/storage"
+)
+
+const (
+	UserSearchDefaultLimit = 50
+	UserSearchMaxLimit     = 200
+)
+
+// UserSummary is a user with what they store, as shown to admins
+//
+// StorageBytes counts the files in the trash too, as their content is
+// still stored
+type UserSummary struct {
+	User
+	StorageBytes uint64
+	Files        uint64
+	Texts        uint64
+	TrashedItems uint64
+}
+
+// UserSearch selects users for admins; zero fields match every user
+//
+// Users are returned newest first, starting before the ID Before
+type UserSearch struct {
+	Active *bool
+	// Query matches part of the email, ignoring case
+	Query  string
+	Role   string
+	Before uint64
+	Limit  int
+}
+
+// UserPage is a page of users
+//
+// Next is the Before of the next page, or 0 if this is the last one
+type UserPage struct {
+	Users []UserSummary
+	Total uint64
+	Next  uint64
+}
+
+const userSummaryColumns = "" +
+	"u.id, u.created_at, u.updated_at, u.deleted_at, u.email_verified_at, u.email, u.role, u.active, " +
+	"(SELECT COALESCE(SUM(size), 0) FROM files WHERE user_id = u.id), " +
+	"(SELECT COUNT(*) FROM files WHERE user_id = u.id AND deleted_at IS NULL), " +
+	"(SELECT COUNT(*) FROM texts WHERE user_id = u.id AND deleted_at IS NULL), " +
+	"(SELECT COUNT(*) FROM files WHERE user_id = u.id AND deleted_at IS NOT NULL) + " +
+	"(SELECT COUNT(*) FROM texts WHERE user_id = u.id AND deleted_at IS NOT NULL)"
+
+// SearchUsers returns a page of the users matching the search, including
+// suspended ones
+func SearchUsers(search UserSearch, db *sql.DB) (UserPage, error) {
+	page := UserPage{Users: []UserSummary{}}
+
+	if search.Limit <= 0 {
+		search.Limit = UserSearchDefaultLimit
+	} else if search.Limit > UserSearchMaxLimit {
+		search.Limit = UserSearchMaxLimit
+	}
+
+	where := []string{"TRUE"}
+	args := []any{}
+
+	filter := func(condition string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(condition, len(args)))
+	}
+
+	if search.Query != "" {
+		filter("u.email ILIKE $%d", "%"+escapeLike(search.Query)+"%")
+	}
+
+	if search.Role != "" {
+		filter("u.role = $%d", search.Role)
+	}
+
+	if search.Active != nil {
+		filter("u.active = $%d", *search.Active)
+	}
+
+	query := "SELECT COUNT(*) FROM users u WHERE " + strings.Join(where, " AND ")
+	err := db.QueryRow(query, args...).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	if search.Before != 0 {
+		filter("u.id < $%d", search.Before)
+	}
+
+	args = append(args, search.Limit+1)
+	query = fmt.Sprintf(
+		"SELECT %s FROM users u WHERE %s ORDER BY u.id DESC LIMIT $%d",
+		userSummaryColumns, strings.Join(where, " AND "), len(args),
+	)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		summary, err := scanUserSummary(rows)
+		if err != nil {
+			return page, err
+		}
+
+		if len(page.Users) == search.Limit {
+			page.Next = page.Users[len(page.Users)-1].ID
+			break
+		}
+
+		page.Users = append(page.Users, summary)
+	}
+
+	return page, rows.Err()
+}
+
+// GetUserSummary gets a user by the ID, including suspended ones
+//
+// Returns ErrUserNotFound if the user does not exist
+func GetUserSummary(id uint64, db *sql.DB) (UserSummary, error) {
+	query := "SELECT " + userSummaryColumns + " FROM users u WHERE u.id = $1"
+
+	summary, err := scanUserSummary(db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserSummary{}, ErrUserNotFound
+	}
+
+	return summary, err
+}
+
+func scanUserSummary(row interface{ Scan(...any) error }) (UserSummary, error) {
+	s := UserSummary{}
+
+	err := row.Scan(
+		&s.ID,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+		&s.DeletedAt,
+		&s.EmailVerifiedAt,
+		&s.Email,
+		&s.Role,
+		&s.Active,
+		&s.StorageBytes,
+		&s.Files,
+		&s.Texts,
+		&s.TrashedItems,
+	)
+
+	return s, err
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// SetActive suspends or reactivates the user
+//
+// Suspended users cannot log in, and their tokens and API keys are
+// rejected, but nothing they stored is deleted
+func (u *User) SetActive(active bool, db *sql.DB) error {
+	query := "UPDATE users SET active = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2"
+	result, err := db.Exec(query, active, u.ID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrUserNotFound
+	}
+
+	u.Active = active
+
+	return nil
+}
+
+// ScramblePassword replaces the password of the user with a random one,
+// so that they have to reset it before they can log in again
+func (u *User) ScramblePassword(c config.PasswordHasherConfig, db *sql.DB) error {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+
+	hasher, err := passwords.NewHasher(c)
+	if err != nil {
+		return err
+	}
+
+	return setPasswordHash(u.ID, hex.EncodeToString(b), hasher, db)
+}
+
+// Purge permanently deletes the user with all their files and texts,
+// including the ones in the trash, and the file content in storage
+//
+// Files are deleted one by one, so that a failure leaves the rest in place
+// and the purge can be retried
+func (u *User) Purge(ctx context.Context, store storage.StorageInterface, db *sql.DB) error {
+	query := "SELECT hash, name FROM files WHERE user_id = $1"
+	rows, err := db.Query(query, u.ID)
+	if err != nil {
+		return err
+	}
+
+	files := []File{}
+	for rows.Next() {
+		var file File
+
+		err = rows.Scan(&file.Hash, &file.Name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		files = append(files, file)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err = file.Delete(ctx, store, db)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM shares WHERE hash IN (SELECT hash FROM texts WHERE user_id = $1)", u.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM texts WHERE user_id = $1", u.ID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM users WHERE id = $1", u.ID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrUserNotFound
+	}
+
+	return tx.Commit()
+}
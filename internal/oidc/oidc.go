@@ -0,0 +1,421 @@
+// Package oidc logs users in through an OpenID Connect provider using the
+// authorization code flow with PKCE
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"riley/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// leeway is the clock skew allowed when checking the exp and iat claims of
+// ID tokens
+const leeway = time.Minute
+
+// jwksRefreshInterval limits how often the provider's keys are fetched
+// again for an unknown kid
+const jwksRefreshInterval = time.Minute
+
+// maxResponseSize limits the responses read from the provider
+const maxResponseSize = 1 << 20
+
+var (
+	// ErrTokenExchange is returned when the provider does not exchange the
+	// authorization code for tokens
+	ErrTokenExchange = errors.New("oidc: token exchange failed")
+
+	// ErrInvalidIDToken is returned when the ID token fails validation
+	ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+)
+
+// Metadata is the part of the provider's discovery document riley uses
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the claims of a validated ID token
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Provider is an OpenID Connect provider, discovered on first use
+type Provider struct {
+	config   config.OIDCConfig
+	client   *http.Client
+	metadata *Metadata
+	keys     map[string]any
+	fetched  time.Time
+	mu       sync.Mutex
+}
+
+// NewProvider creates a provider from the config
+//
+// If client is nil, http.DefaultClient is used
+func NewProvider(c config.OIDCConfig, client *http.Client) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Provider{
+		config: c,
+		client: client,
+		keys:   map[string]any{},
+	}
+}
+
+// Issuer returns the issuer of the provider
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// Metadata fetches the discovery document of the provider once and
+// returns it
+//
+// The document must name the configured issuer, so that a provider cannot
+// issue tokens in the name of another one
+func (p *Provider) Metadata(ctx context.Context) (Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.discover(ctx)
+}
+
+func (p *Provider) discover(ctx context.Context) (Metadata, error) {
+	if p.metadata != nil {
+		return *p.metadata, nil
+	}
+
+	metadata := Metadata{}
+
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	err := p.getJSON(ctx, discoveryURL, &metadata)
+	if err != nil {
+		return metadata, fmt.Errorf("oidc: discovery: %w", err)
+	}
+
+	if metadata.Issuer != p.config.Issuer {
+		return metadata, fmt.Errorf("oidc: discovery: issuer %q does not match %q", metadata.Issuer, p.config.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return metadata, errors.New("oidc: discovery: missing endpoints")
+	}
+
+	p.metadata = &metadata
+
+	return metadata, nil
+}
+
+// AuthCodeURL returns the URL the user is sent to for logging in at the
+// provider
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// Exchange exchanges the authorization code for the raw ID token
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string) (string, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrTokenExchange, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d", ErrTokenExchange, res.StatusCode)
+	}
+
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&tokens)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrTokenExchange, err)
+	}
+
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in response", ErrTokenExchange)
+	}
+
+	return tokens.IDToken, nil
+}
+
+// VerifyIDToken verifies the signature of the ID token with the provider's
+// keys and validates its iss, aud, azp, exp, iat and nonce claims
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (Claims, error) {
+	claims := jwt.MapClaims{}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithLeeway(leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || tokenNonce != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+
+	audience, err := claims.GetAudience()
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	// With several audiences, the token must have been issued to riley
+	azp, hasAZP := claims["azp"].(string)
+	if (len(audience) > 1 || hasAZP) && azp != p.config.ClientID {
+		return Claims{}, fmt.Errorf("%w: authorized party does not match", ErrInvalidIDToken)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	email, _ := claims["email"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+
+	return Claims{
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: emailVerified,
+	}, nil
+}
+
+// key returns the provider's key with the kid, fetching the provider's
+// keys again if it is unknown
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.fetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+
+	err = p.getJSON(ctx, metadata.JWKSURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetching keys: %w", err)
+	}
+
+	p.fetched = time.Now()
+	p.keys = map[string]any{}
+
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		public, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+
+		p.keys[k.KeyID] = public
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(v)
+}
+
+// jwk is a public key published by the provider
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Curve   string `json:"crv"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func (k jwk) publicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch {
+	case k.KeyType == "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case k.KeyType == "EC" && k.Curve == "P-256":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+
+		return public, nil
+	case k.KeyType == "OKP" && k.Curve == "Ed25519":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("key type %q not supported", k.KeyType)
+}
+
+// RandomString returns a random, URL safe string, used for the state,
+// nonce and PKCE code verifier of a login
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of the verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
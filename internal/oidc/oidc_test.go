@@ -0,0 +1,151 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"riley/internal/config"
+	"riley/internal/oidc"
+	"riley/internal/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T) (*oidc.Provider, *oidctest.Server) {
+	t.Helper()
+
+	server := oidctest.NewServer(t)
+
+	c := config.LoadTestConfig().OIDC
+	c.Issuer = server.Issuer()
+	c.ClientID = oidctest.ClientID
+	c.ClientSecret = oidctest.ClientSecret
+	c.RedirectURL = "http://riley.test/login/oidc/callback"
+
+	return oidc.NewProvider(c, server.Client()), server
+}
+
+// authorize follows the authorization URL without following the redirect
+// back to riley and returns the code and state of the callback
+func authorize(t *testing.T, server *oidctest.Server, authURL string) (string, string) {
+	t.Helper()
+
+	client := server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatal("Testing authorization: Wanted", http.StatusFound, "got", res.StatusCode)
+	}
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	provider, server := newTestProvider(t)
+
+	user := oidctest.User{Subject: "subject-1", Email: "oidc@example.com", EmailVerified: true}
+	server.SetUser(user)
+
+	t.Run("login", func(t *testing.T) {
+		verifier, _ := oidc.RandomString()
+
+		authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+		if err != nil {
+			t.Fatal("Testing AuthCodeURL: Wanted nil, got", err)
+		}
+
+		code, state := authorize(t, server, authURL)
+		if state != "state" {
+			t.Error("Testing callback state: Wanted state, got", state)
+		}
+
+		_, err = provider.Exchange(ctx, code, "wrong verifier")
+		if !errors.Is(err, oidc.ErrTokenExchange) {
+			t.Error("Testing Exchange with wrong verifier: Wanted", oidc.ErrTokenExchange, "got", err)
+		}
+
+		// The failed exchange used up the code
+		code, _ = authorize(t, server, authURL)
+
+		idToken, err := provider.Exchange(ctx, code, verifier)
+		if err != nil {
+			t.Fatal("Testing Exchange: Wanted nil, got", err)
+		}
+
+		_, err = provider.Exchange(ctx, code, verifier)
+		if !errors.Is(err, oidc.ErrTokenExchange) {
+			t.Error("Testing Exchange with used code: Wanted", oidc.ErrTokenExchange, "got", err)
+		}
+
+		claims, err := provider.VerifyIDToken(ctx, idToken, "nonce")
+		if err != nil {
+			t.Fatal("Testing VerifyIDToken: Wanted nil, got", err)
+		}
+
+		if claims.Subject != user.Subject || claims.Email != user.Email || !claims.EmailVerified {
+			t.Error("Testing VerifyIDToken: Wanted", user, "got", claims)
+		}
+
+		_, err = provider.VerifyIDToken(ctx, idToken, "other nonce")
+		if !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Error("Testing VerifyIDToken with wrong nonce: Wanted", oidc.ErrInvalidIDToken, "got", err)
+		}
+	})
+
+	t.Run("invalid ID tokens", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			claim string
+			value any
+		}{
+			{"wrong issuer", "iss", "https://other.example.com"},
+			{"wrong audience", "aud", "other-client"},
+			{"other authorized party", "azp", "other-client"},
+			{"several audiences without azp", "aud", []string{oidctest.ClientID, "other-client"}},
+			{"expired", "exp", time.Now().Add(-time.Hour).Unix()},
+			{"issued in the future", "iat", time.Now().Add(time.Hour).Unix()},
+			{"missing subject", "sub", ""},
+		}
+
+		for _, tt := range tests {
+			claims := server.Claims(user, "nonce")
+			claims[tt.claim] = tt.value
+
+			idToken, err := server.SignIDToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = provider.VerifyIDToken(ctx, idToken, "nonce")
+			if !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Error("Testing VerifyIDToken with", tt.name, ": Wanted", oidc.ErrInvalidIDToken, "got", err)
+			}
+		}
+	})
+
+	t.Run("issuer mismatch", func(t *testing.T) {
+		c := config.LoadTestConfig().OIDC
+		c.Issuer = server.Issuer() + "/"
+
+		_, err := oidc.NewProvider(c, server.Client()).Metadata(ctx)
+		if err == nil {
+			t.Error("Testing discovery with another issuer: Wanted error, got nil")
+		}
+	})
+}
@@ -0,0 +1,189 @@
+// Package passwords hashes passwords and checks new ones against the
+// password policy
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"riley/internal/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHash is returned for stored hashes no hasher made
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+const (
+	argon2KeyLength  = 32
+	argon2SaltLength = 16
+)
+
+// Hasher hashes new passwords
+//
+// Verify checks passwords against the hashes of every hasher, so the
+// algorithm can be changed without invalidating stored passwords
+type Hasher interface {
+	// Hash returns the encoded hash of the password with a random salt
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether a stored hash was made by another
+	// algorithm or with other parameters, and should be replaced
+	NeedsRehash(hash string) bool
+}
+
+// NewHasher returns the hasher selected by the config
+func NewHasher(c config.PasswordHasherConfig) (Hasher, error) {
+	switch c.Algorithm {
+	case config.PASSWORD_HASHER_ARGON2ID:
+		if c.Argon2Memory == 0 || c.Argon2Time == 0 || c.Argon2Threads == 0 {
+			return nil, errors.New("argon2id memory, time and threads must be positive")
+		}
+
+		return &Argon2idHasher{
+			Memory:  c.Argon2Memory,
+			Time:    c.Argon2Time,
+			Threads: c.Argon2Threads,
+		}, nil
+	case config.PASSWORD_HASHER_BCRYPT:
+		if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+
+		return &BcryptHasher{Cost: c.BcryptCost}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", c.Algorithm)
+	}
+}
+
+// Verify checks a password against a hash made by any hasher
+//
+// Returns false without an error if the password does not match
+func Verify(password string, hash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	default:
+		return false, ErrUnknownHash
+	}
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC
+// string format
+type Argon2idHasher struct {
+	// Memory is in KiB
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, argon2KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Memory,
+		h.Time,
+		h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return *params != *h || len(key) != argon2KeyLength
+}
+
+// decodeArgon2id parses an argon2id hash in the PHC string format
+func decodeArgon2id(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrUnknownHash
+	}
+
+	params := &Argon2idHasher{}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || params.Memory == 0 || params.Time == 0 || params.Threads == 0 {
+		return nil, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrUnknownHash
+	}
+
+	return params, salt, key, nil
+}
+
+// bcryptMaxLength is the longest password bcrypt accepts, in bytes
+const bcryptMaxLength = 72
+
+// BcryptHasher hashes passwords with bcrypt
+//
+// bcrypt rejects passwords longer than 72 bytes; Policy limits new
+// passwords accordingly
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+
+	return err != nil || cost != h.Cost
+}
@@ -0,0 +1,144 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"riley/internal/config"
+)
+
+var testHasherConfig = config.PasswordHasherConfig{
+	Algorithm:     config.PASSWORD_HASHER_ARGON2ID,
+	Argon2Memory:  1024,
+	Argon2Time:    1,
+	Argon2Threads: 1,
+	BcryptCost:    4,
+}
+
+func TestHashAndVerify(t *testing.T) {
+	bcryptConfig := testHasherConfig
+	bcryptConfig.Algorithm = config.PASSWORD_HASHER_BCRYPT
+
+	for _, c := range []config.PasswordHasherConfig{testHasherConfig, bcryptConfig} {
+		hasher, err := NewHasher(c)
+		if err != nil {
+			t.Fatalf("NewHasher returned an error: %s", err)
+		}
+
+		hash, err := hasher.Hash("password123%A%")
+		if err != nil {
+			t.Fatalf("Hash returned an error: %s", err)
+		}
+
+		ok, err := Verify("password123%A%", hash)
+		if err != nil || !ok {
+			t.Errorf("Testing %s verify with correct password: Wanted true, got %v (%v)", c.Algorithm, ok, err)
+		}
+
+		ok, err = Verify("password123%A", hash)
+		if err != nil || ok {
+			t.Errorf("Testing %s verify with wrong password: Wanted false, got %v (%v)", c.Algorithm, ok, err)
+		}
+
+		if hasher.NeedsRehash(hash) {
+			t.Errorf("Testing %s rehash with same parameters: Wanted false, got true", c.Algorithm)
+		}
+
+		other, err := hasher.Hash("password123%A%")
+		if err != nil {
+			t.Fatalf("Hash returned an error: %s", err)
+		}
+
+		if other == hash {
+			t.Errorf("Testing %s salt: Wanted different hashes, got the same", c.Algorithm)
+		}
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	hasher, err := NewHasher(testHasherConfig)
+	if err != nil {
+		t.Fatalf("NewHasher returned an error: %s", err)
+	}
+
+	hash, err := hasher.Hash("password123%A%")
+	if err != nil {
+		t.Fatalf("Hash returned an error: %s", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Error("Testing argon2id format: Wanted PHC string, got", hash)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon2id, err := NewHasher(testHasherConfig)
+	if err != nil {
+		t.Fatalf("NewHasher returned an error: %s", err)
+	}
+
+	stronger := testHasherConfig
+	stronger.Argon2Memory = 2048
+
+	strongerArgon2id, err := NewHasher(stronger)
+	if err != nil {
+		t.Fatalf("NewHasher returned an error: %s", err)
+	}
+
+	bcryptConfig := testHasherConfig
+	bcryptConfig.Algorithm = config.PASSWORD_HASHER_BCRYPT
+
+	bcrypt, err := NewHasher(bcryptConfig)
+	if err != nil {
+		t.Fatalf("NewHasher returned an error: %s", err)
+	}
+
+	argon2idHash, err := argon2id.Hash("password123%A%")
+	if err != nil {
+		t.Fatalf("Hash returned an error: %s", err)
+	}
+
+	bcryptHash, err := bcrypt.Hash("password123%A%")
+	if err != nil {
+		t.Fatalf("Hash returned an error: %s", err)
+	}
+
+	if !strongerArgon2id.NeedsRehash(argon2idHash) {
+		t.Error("Testing rehash with more memory: Wanted true, got false")
+	}
+
+	if !argon2id.NeedsRehash(bcryptHash) {
+		t.Error("Testing rehash of bcrypt hash with argon2id: Wanted true, got false")
+	}
+
+	if !bcrypt.NeedsRehash(argon2idHash) {
+		t.Error("Testing rehash of argon2id hash with bcrypt: Wanted true, got false")
+	}
+
+	ok, err := Verify("password123%A%", bcryptHash)
+	if err != nil || !ok {
+		t.Errorf("Testing verify of bcrypt hash after switching: Wanted true, got %v (%v)", ok, err)
+	}
+}
+
+func TestNewHasherInvalid(t *testing.T) {
+	invalid := []config.PasswordHasherConfig{
+		{Algorithm: "md5"},
+		{Algorithm: config.PASSWORD_HASHER_ARGON2ID},
+		{Algorithm: config.PASSWORD_HASHER_BCRYPT, BcryptCost: 100},
+	}
+
+	for _, c := range invalid {
+		_, err := NewHasher(c)
+		if err == nil {
+			t.Errorf("Testing invalid hasher config %+v: Wanted error, got nil", c)
+		}
+	}
+}
+
+func TestVerifyUnknownHash(t *testing.T) {
+	_, err := Verify("password123%A%", "plaintext")
+	if err != ErrUnknownHash {
+		t.Error("Testing verify with unknown hash: Wanted ErrUnknownHash, got", err)
+	}
+}
@@ -0,0 +1,178 @@
+package passwords
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"riley/internal/config"
+)
+
+// breachedRequirement describes the breached password check in
+// validation errors
+const breachedRequirement = "must not be a commonly used or breached password"
+
+// breachedLists caches the breached password lists by path, as they are
+// large and read on every signup
+var breachedLists sync.Map
+
+type breachedList struct {
+	passwords map[string]struct{}
+	err       error
+	once      sync.Once
+}
+
+// Policy returns the policy new passwords are checked against with the
+// configured hasher
+//
+// bcrypt rejects passwords longer than 72 bytes, so with it lengths are
+// counted in bytes and MaxLength is at most 72
+func Policy(c config.PasswordConfig) config.PasswordPolicyConfig {
+	policy := c.Policy
+
+	if c.Hasher.Algorithm == config.PASSWORD_HASHER_BCRYPT {
+		policy.CountBytes = true
+
+		if policy.MaxLength <= 0 || policy.MaxLength > bcryptMaxLength {
+			policy.MaxLength = bcryptMaxLength
+		}
+	}
+
+	return policy
+}
+
+// CheckPolicy checks a new password against the policy
+//
+// Returns the requirement the password fails, to show to the client, or
+// an empty string if it meets them all. The error is only set if the
+// breached password list cannot be read
+func CheckPolicy(password string, c config.PasswordPolicyConfig) (string, error) {
+	length := utf8.RuneCountInString(password)
+	if c.CountBytes {
+		length = len(password)
+	}
+
+	var hasUpper, hasLower, hasNumber, hasSpecial bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasNumber = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSpecial = true
+		}
+	}
+
+	if length < c.MinLength ||
+		(c.MaxLength > 0 && length > c.MaxLength) ||
+		(c.RequireUpper && !hasUpper) ||
+		(c.RequireLower && !hasLower) ||
+		(c.RequireNumber && !hasNumber) ||
+		(c.RequireSpecial && !hasSpecial) {
+		return Requirements(c), nil
+	}
+
+	if c.BreachedListPath == "" {
+		return "", nil
+	}
+
+	breached, err := isBreached(password, c.BreachedListPath)
+	if err != nil {
+		return "", err
+	}
+
+	if breached {
+		return breachedRequirement, nil
+	}
+
+	return "", nil
+}
+
+// Requirements describes the passwords the policy accepts, e.g. "must be
+// 8 to 64 characters long and contain at least one number"
+func Requirements(c config.PasswordPolicyConfig) string {
+	var b strings.Builder
+
+	unit := "characters"
+	if c.CountBytes {
+		unit = "bytes"
+	}
+
+	if c.MaxLength > 0 {
+		fmt.Fprintf(&b, "must be %d to %d %s long", c.MinLength, c.MaxLength, unit)
+	} else {
+		fmt.Fprintf(&b, "must be at least %d %s long", c.MinLength, unit)
+	}
+
+	classes := []string{}
+	if c.RequireUpper {
+		classes = append(classes, "one uppercase letter")
+	}
+	if c.RequireLower {
+		classes = append(classes, "one lowercase letter")
+	}
+	if c.RequireNumber {
+		classes = append(classes, "one number")
+	}
+	if c.RequireSpecial {
+		classes = append(classes, "one special character")
+	}
+
+	switch len(classes) {
+	case 0:
+	case 1:
+		b.WriteString(" and contain at least " + classes[0])
+	default:
+		b.WriteString(" and contain at least " + strings.Join(classes[:len(classes)-1], ", ") + ", and " + classes[len(classes)-1])
+	}
+
+	return b.String()
+}
+
+// isBreached checks if the password is in the breached password list at
+// the path
+//
+// The list has one password per line and is read once, on first use
+func isBreached(password string, path string) (bool, error) {
+	value, _ := breachedLists.LoadOrStore(path, &breachedList{})
+	list := value.(*breachedList)
+
+	list.once.Do(func() {
+		list.passwords, list.err = readBreachedList(path)
+	})
+
+	if list.err != nil {
+		return false, list.err
+	}
+
+	_, ok := list.passwords[password]
+
+	return ok, nil
+}
+
+func readBreachedList(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	passwords := map[string]struct{}{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line != "" {
+			passwords[line] = struct{}{}
+		}
+	}
+
+	return passwords, scanner.Err()
+}
@@ -0,0 +1,149 @@
+package passwords
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"riley/internal/config"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+
+	err := os.WriteFile(path, []byte("Password1!\nqwerty\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.PasswordPolicyConfig{
+		BreachedListPath: path,
+		MinLength:        8,
+		MaxLength:        16,
+		RequireUpper:     true,
+		RequireLower:     true,
+		RequireNumber:    true,
+		RequireSpecial:   true,
+	}
+
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"password123%A%", ""},
+		{"pässwörd123%Ä%", ""},
+		{"short1A!", ""},
+		{"shrt1A!", Requirements(c)},
+		{"waytoolongpassword123%A%", Requirements(c)},
+		{"password123%%", Requirements(c)},
+		{"PASSWORD123%%", Requirements(c)},
+		{"passwordAA%%", Requirements(c)},
+		{"password123AA", Requirements(c)},
+		{"Password1!", breachedRequirement},
+	}
+
+	for _, test := range tests {
+		got, err := CheckPolicy(test.password, c)
+		if err != nil {
+			t.Fatalf("CheckPolicy returned an error: %s", err)
+		}
+
+		if got != test.want {
+			t.Errorf("Testing policy with %q: Wanted %q, got %q", test.password, test.want, got)
+		}
+	}
+}
+
+func TestCheckPolicyMissingList(t *testing.T) {
+	c := config.PasswordPolicyConfig{
+		BreachedListPath: filepath.Join(t.TempDir(), "missing.txt"),
+		MinLength:        8,
+	}
+
+	_, err := CheckPolicy("password123%A%", c)
+	if err == nil {
+		t.Error("Testing policy with missing breached list: Wanted error, got nil")
+	}
+}
+
+func TestPolicyBcrypt(t *testing.T) {
+	c := config.PasswordConfig{
+		Hasher: config.PasswordHasherConfig{Algorithm: config.PASSWORD_HASHER_BCRYPT, BcryptCost: 4},
+		Policy: config.PasswordPolicyConfig{MinLength: 8, MaxLength: 64, RequireNumber: true},
+	}
+
+	// 64 characters, but 84 bytes
+	password := strings.Repeat("ä", 20) + strings.Repeat("a", 43) + "1"
+
+	got, err := CheckPolicy(password, Policy(c))
+	if err != nil {
+		t.Fatalf("CheckPolicy returned an error: %s", err)
+	}
+
+	if got != Requirements(Policy(c)) {
+		t.Errorf("Testing bcrypt policy with %d bytes: Wanted %q, got %q", len(password), Requirements(Policy(c)), got)
+	}
+
+	c.Hasher.Algorithm = config.PASSWORD_HASHER_ARGON2ID
+
+	got, err = CheckPolicy(password, Policy(c))
+	if err != nil {
+		t.Fatalf("CheckPolicy returned an error: %s", err)
+	}
+
+	if got != "" {
+		t.Errorf("Testing argon2id policy with %d characters: Wanted \"\", got %q", len(password), got)
+	}
+
+	// Every password the bcrypt policy accepts can be hashed
+	c.Hasher.Algorithm = config.PASSWORD_HASHER_BCRYPT
+	c.Policy.MaxLength = 0
+	password = strings.Repeat("ä", 35) + "1a"
+
+	got, err = CheckPolicy(password, Policy(c))
+	if err != nil || got != "" {
+		t.Fatalf("Testing bcrypt policy with %d bytes: Wanted \"\", got %q, %v", len(password), got, err)
+	}
+
+	hasher, err := NewHasher(c.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = hasher.Hash(password)
+	if err != nil {
+		t.Errorf("Testing bcrypt hash with %d bytes: Wanted nil, got %s", len(password), err)
+	}
+}
+
+func TestRequirements(t *testing.T) {
+	tests := []struct {
+		c    config.PasswordPolicyConfig
+		want string
+	}{
+		{
+			config.PasswordPolicyConfig{MinLength: 8, MaxLength: 64, RequireUpper: true, RequireLower: true, RequireNumber: true, RequireSpecial: true},
+			"must be 8 to 64 characters long and contain at least one uppercase letter, one lowercase letter, one number, and one special character",
+		},
+		{
+			config.PasswordPolicyConfig{MinLength: 12},
+			"must be at least 12 characters long",
+		},
+		{
+			config.PasswordPolicyConfig{MinLength: 12, RequireNumber: true},
+			"must be at least 12 characters long and contain at least one number",
+		},
+		{
+			config.PasswordPolicyConfig{MinLength: 8, MaxLength: 72, CountBytes: true},
+			"must be 8 to 72 bytes long",
+		},
+	}
+
+	for _, test := range tests {
+		got := Requirements(test.c)
+		if got != test.want {
+			t.Errorf("Testing requirements: Wanted %q, got %q", test.want, got)
+		}
+	}
+}
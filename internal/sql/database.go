@@ -32,8 +32,22 @@ func Connect(config *config.Config) *sql.DB {
 
 func runMigrations(db *sql.DB) {
 	runUserMigration(db)
+	runBlobsMigration(db)
 	runFilesMigration(db)
 	runTextsMigration(db)
+	runRateLimitsMigration(db)
+	runSharesMigration(db)
+	runSessionsMigration(db)
+	runRevokedTokensMigration(db)
+	runAPIKeysMigration(db)
+	runIdentitiesMigration(db)
+	runOIDCLoginsMigration(db)
+	runMFAMigration(db)
+	runSettingsMigration(db)
+	runAccountTokensMigration(db)
+	runLoginThrottlesMigration(db)
+	runLoginEventsMigration(db)
+	runAuditLogMigration(db)
 }
 
 func runUserMigration(db *sql.DB) {
@@ -47,6 +61,23 @@ func runUserMigration(db *sql.DB) {
 			password VARCHAR(255) NOT NULL,
 			active BOOLEAN NOT NULL DEFAULT TRUE
 		);
+
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(32) NOT NULL DEFAULT 'user';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runBlobsMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS blobs (
+			hash VARCHAR(64) PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			size BIGINT NOT NULL,
+			refs INTEGER NOT NULL
+		);
 	`)
 	if err != nil {
 		panic(err)
@@ -67,6 +98,11 @@ func runFilesMigration(db *sql.DB) {
 			user_id BIGINT NOT NULL,
 			FOREIGN KEY (user_id) REFERENCES users(id)
 		);
+
+		ALTER TABLE files ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64) NOT NULL DEFAULT '';
+
+		ALTER TABLE files ADD COLUMN IF NOT EXISTS blob_hash VARCHAR(64) REFERENCES blobs(hash);
+		CREATE INDEX IF NOT EXISTS files_blob_hash_idx ON files (blob_hash);
 	`)
 	if err != nil {
 		panic(err)
@@ -93,3 +129,266 @@ func runTextsMigration(db *sql.DB) {
 		panic(err)
 	}
 }
+
+func runRateLimitsMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS rate_limits (
+			key VARCHAR(255) NOT NULL,
+			window_start TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (key, window_start)
+		);
+
+		CREATE INDEX IF NOT EXISTS rate_limits_expires_at_idx ON rate_limits (expires_at);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runSharesMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS shares (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			hash VARCHAR(255) NOT NULL,
+			user_id BIGINT NOT NULL,
+			permission VARCHAR(32) NOT NULL,
+			UNIQUE (hash, user_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runSessionsMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP,
+			user_id BIGINT NOT NULL,
+			refresh_token_hash VARCHAR(64) NOT NULL UNIQUE,
+			previous_refresh_token_hash VARCHAR(64),
+			access_token_id VARCHAR(64) NOT NULL,
+			access_token_expires_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+		CREATE INDEX IF NOT EXISTS sessions_previous_refresh_token_hash_idx ON sessions (previous_refresh_token_hash);
+
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512) NOT NULL DEFAULT '';
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip VARCHAR(64) NOT NULL DEFAULT '';
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runRevokedTokensMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti VARCHAR(64) PRIMARY KEY,
+			expires_at TIMESTAMP NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runAPIKeysMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			user_id BIGINT NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			key_hash VARCHAR(64) NOT NULL UNIQUE,
+			scopes TEXT[] NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runIdentitiesMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS identities (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			user_id BIGINT NOT NULL,
+			issuer VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			email VARCHAR(255) NOT NULL,
+			UNIQUE (issuer, subject),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runOIDCLoginsMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS oidc_logins (
+			state VARCHAR(64) PRIMARY KEY,
+			nonce VARCHAR(64) NOT NULL,
+			code_verifier VARCHAR(128) NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runMFAMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS mfa (
+			user_id BIGINT PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			enabled_at TIMESTAMP,
+			secret TEXT NOT NULL,
+			last_counter BIGINT NOT NULL DEFAULT 0,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			used_at TIMESTAMP,
+			user_id BIGINT NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			UNIQUE (user_id, code_hash),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runSettingsMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS settings (
+			key VARCHAR(64) PRIMARY KEY,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			value TEXT NOT NULL
+		);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runAccountTokensMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS account_tokens (
+			id VARCHAR(64) PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			user_id BIGINT NOT NULL,
+			purpose VARCHAR(32) NOT NULL,
+			email VARCHAR(255) NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS account_tokens_user_id_idx ON account_tokens (user_id);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runLoginThrottlesMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_throttles (
+			email VARCHAR(255) PRIMARY KEY,
+			failures INT NOT NULL,
+			last_failure_at TIMESTAMP NOT NULL,
+			locked_until TIMESTAMP
+		);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runLoginEventsMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_events (
+			id BIGSERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			user_id BIGINT,
+			email VARCHAR(255) NOT NULL,
+			method VARCHAR(32) NOT NULL,
+			success BOOLEAN NOT NULL,
+			failure_reason VARCHAR(64) NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			user_agent VARCHAR(512) NOT NULL DEFAULT '',
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+		);
+
+		-- Keeps the login history of deleted users, which used to be deleted
+		-- with them
+		DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM pg_constraint
+				WHERE conname = 'login_events_user_id_fkey' AND confdeltype = 'c'
+			) THEN
+				ALTER TABLE login_events DROP CONSTRAINT login_events_user_id_fkey;
+				ALTER TABLE login_events ADD CONSTRAINT login_events_user_id_fkey
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+			END IF;
+		END $$;
+
+		CREATE INDEX IF NOT EXISTS login_events_user_id_idx ON login_events (user_id, id);
+		CREATE INDEX IF NOT EXISTS login_events_created_at_idx ON login_events (created_at);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runAuditLogMigration(db *sql.DB) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			actor_id BIGINT,
+			actor_email VARCHAR(255) NOT NULL,
+			action VARCHAR(64) NOT NULL,
+			target_user_id BIGINT,
+			target_email VARCHAR(255) NOT NULL DEFAULT '',
+			details JSONB NOT NULL DEFAULT '{}',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS audit_log_target_user_id_idx ON audit_log (target_user_id, id);
+	`)
+	if err != nil {
+		panic(err)
+	}
+}
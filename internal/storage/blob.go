@@ -1,25 +1,345 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"riley/internal/config"
 )
 
+// blobAPIVersion is the x-ms-version of the Blob service REST API used
+const blobAPIVersion = "2021-08-06"
+
+// defaultBlockSize is used when the config does not set a block size
+const defaultBlockSize = 4 << 20
+
+// Blob stores content as block blobs in an Azure Blob Storage container,
+// authenticating with the account Shared Key
 type Blob struct {
-	FileDetails FileDetails
+	Config config.AzureBlobConfig
+	// Client sends the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+func init() {
+	Register(STORAGE_TYPE_BLOB, func(c config.StorageConfigInterface) (StorageInterface, error) {
+		abc, err := configSection[*config.AzureBlobConfig](c)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Blob{Config: *abc}, nil
+	})
+}
+
+// Put uploads the content in blocks of Config.BlockSize, so that memory use
+// does not depend on the size of the file
+//
+// Content that fits in a single block is uploaded with one Put Blob
+// request; larger content is staged with Put Block and committed with Put
+// Block List, so the blob is only replaced once every block is uploaded
+func (b *Blob) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	err := validateKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, b.blockSize())
+	r = contextReader{ctx: ctx, r: r}
+
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return int64(n), b.putBlob(ctx, key, buf[:n])
+	} else if err != nil {
+		return 0, err
+	}
+
+	size := int64(0)
+	blockIDs := []string{}
+
+	for n > 0 {
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(blockIDs))))
+
+		err = b.putBlock(ctx, key, blockID, buf[:n])
+		if err != nil {
+			return size, err
+		}
+
+		size += int64(n)
+		blockIDs = append(blockIDs, blockID)
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return size, err
+		}
+	}
+
+	return size, b.putBlockList(ctx, key, blockIDs)
+}
+
+// Get returns a reader that downloads the blob in ranges, starting from
+// where it was last seeked to
+//
+// The ranges are requested with the ETag of the blob when it was opened,
+// so that a blob replaced while it is read fails instead of mixing content
+func (b *Blob) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	etag := res.Header.Get("ETag")
+
+	return NewRangeReader(ctx, res.ContentLength, func(ctx context.Context, offset int64, length int64) (io.ReadCloser, error) {
+		header := http.Header{}
+		if etag != "" {
+			header.Set("If-Match", etag)
+		}
+		header.Set("x-ms-range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+		res, err := b.do(ctx, http.MethodGet, key, nil, header, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return res.Body, nil
+	}), nil
+}
+
+func (b *Blob) Delete(ctx context.Context, key string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	res, err := b.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func (b *Blob) Exists(ctx context.Context, key string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	res, err := b.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func (b *Blob) putBlob(ctx context.Context, key string, content []byte) error {
+	header := http.Header{}
+	header.Set("x-ms-blob-type", "BlockBlob")
+
+	res, err := b.do(ctx, http.MethodPut, key, nil, header, content)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
 }
 
-func (b *Blob) Upload() (string, error) {
-	return "", errors.New("not implemented")
+func (b *Blob) putBlock(ctx context.Context, key string, blockID string, content []byte) error {
+	query := url.Values{}
+	query.Set("comp", "block")
+	query.Set("blockid", blockID)
+
+	res, err := b.do(ctx, http.MethodPut, key, query, nil, content)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
 }
 
-func (b *Blob) Delete() error {
-	return errors.New("not implemented")
+func (b *Blob) putBlockList(ctx context.Context, key string, blockIDs []string) error {
+	blockList := struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{
+		Latest: blockIDs,
+	}
+
+	content, err := xml.Marshal(blockList)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("comp", "blocklist")
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+
+	res, err := b.do(ctx, http.MethodPut, key, query, header, append([]byte(xml.Header), content...))
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
 }
 
-func (b *Blob) Exists() error {
-	return errors.New("not implemented")
+// do sends a signed request for the blob and returns the response if it
+// succeeded
+//
+// A missing blob returns an error matching fs.ErrNotExist
+func (b *Blob) do(ctx context.Context, method string, key string, query url.Values, header http.Header, content []byte) (*http.Response, error) {
+	u, err := url.Parse(b.endpoint() + "/" + url.PathEscape(b.Config.Container) + "/" + url.PathEscape(key))
+	if err != nil {
+		return nil, err
+	}
+
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	req.ContentLength = int64(len(content))
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", blobAPIVersion)
+
+	err = b.sign(req)
+	if err != nil {
+		return nil, err
+	}
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	// The error code is in a header, since HEAD responses have no body
+	_, _ = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	code := res.Header.Get("x-ms-error-code")
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("azure blob: %s %s: %w", method, code, fs.ErrNotExist)
+	}
+
+	return nil, fmt.Errorf("azure blob: %s: status %d %s", method, res.StatusCode, code)
 }
 
-func (b *Blob) Download() (*[]byte, error) {
-	return nil, errors.New("not implemented")
+// sign sets the Shared Key Authorization header of the request
+//
+// See https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func (b *Blob) sign(req *http.Request) error {
+	key, err := base64.StdEncoding.DecodeString(b.Config.AccountKey)
+	if err != nil {
+		return fmt.Errorf("azure blob: invalid account key: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(b.stringToSign(req)))
+
+	req.Header.Set("Authorization", "SharedKey "+b.Config.AccountName+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	return nil
+}
+
+// stringToSign returns the Shared Key string to sign of the request
+func (b *Blob) stringToSign(req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = fmt.Sprintf("%d", req.ContentLength)
+	}
+
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		// Empty, since x-ms-date is set
+		"",
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	headers := []string{}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	slices.Sort(headers)
+
+	resource := "/" + b.Config.AccountName + req.URL.EscapedPath()
+
+	query := req.URL.Query()
+	names := []string{}
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		values := query[name]
+		slices.Sort(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	return strings.Join(lines, "\n") + "\n" + strings.Join(headers, "\n") + "\n" + resource
+}
+
+func (b *Blob) endpoint() string {
+	if b.Config.Endpoint != "" {
+		return strings.TrimSuffix(b.Config.Endpoint, "/")
+	}
+
+	return "https://" + b.Config.AccountName + ".blob.core.windows.net"
+}
+
+func (b *Blob) blockSize() int64 {
+	if b.Config.BlockSize > 0 {
+		return b.Config.BlockSize
+	}
+
+	return defaultBlockSize
 }
@@ -1,142 +1,262 @@
 package storage
 
-/**
-
 import (
-	"slices"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"riley/internal/config"
 )
 
-func TestBlobUploadFile(t *testing.T) {
-	b := Blob{
-		FileDetails: FileDetails{
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-			Hash:      "test",
-			Size:      uint64(len([]byte("test"))),
-			FileName:  "test.txt",
-			FileContent: func() *[]byte {
-				content := []byte("test")
-				return &content
-			}(),
-		},
+// azuriteConfig returns the config of the well-known development account
+// of the Azurite emulator, listening on its default blob port
+func azuriteConfig() config.AzureBlobConfig {
+	return config.AzureBlobConfig{
+		AccountName: "devstoreaccount1",
+		AccountKey:  "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		Container:   "riley-test",
+		Endpoint:    "http://127.0.0.1:10000/devstoreaccount1",
+		BlockSize:   8,
+	}
+}
+
+// newAzuriteBlob returns a Blob for the Azurite emulator, creating the
+// test container if it does not exist
+func newAzuriteBlob(t *testing.T) *Blob {
+	b := &Blob{Config: azuriteConfig()}
+
+	req, err := http.NewRequest(http.MethodPut, b.endpoint()+"/"+b.Config.Container+"?restype=container", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", blobAPIVersion)
+
+	err = b.sign(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Creating the Azurite container returned an error: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusConflict {
+		t.Fatalf("Creating the Azurite container returned status %d", res.StatusCode)
 	}
 
-	t.Run("test file upload", func(t *testing.T) {
-		_, err := b.Upload()
+	return b
+}
+
+func TestBlobPut(t *testing.T) {
+	b := newAzuriteBlob(t)
+	ctx := context.Background()
+
+	t.Run("test single block upload", func(t *testing.T) {
+		n, err := b.Put(ctx, "test", strings.NewReader("test"))
 		if err != nil {
-			t.Fatalf("BlobUploadFile returned an error: %s", err)
+			t.Fatalf("BlobPut returned an error: %s", err)
+		}
+
+		if n != 4 {
+			t.Fatalf("BlobPut returned size %d, expected 4", n)
 		}
 
-		err = b.Delete()
+		err = b.Delete(ctx, "test")
 		if err != nil {
-			t.Fatalf("BlobDeleteFile returned an error: %s", err)
+			t.Fatalf("BlobDelete returned an error: %s", err)
 		}
 	})
-}
 
-func TestBlobExists(t *testing.T) {
-	b := Blob{
-		FileDetails: FileDetails{
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-			Hash:      "test1",
-			Size:      uint64(len([]byte("test1"))),
-			FileName:  "test1.txt",
-			FileContent: func() *[]byte {
-				content := []byte("test1")
-				return &content
-			}(),
-		},
-	}
+	t.Run("test staged upload", func(t *testing.T) {
+		content := "content larger than a single block"
 
-	t.Run("test file exists", func(t *testing.T) {
-		_, err := b.Upload()
+		n, err := b.Put(ctx, "test1", strings.NewReader(content))
 		if err != nil {
-			t.Fatalf("BlobUploadFile returned an error: %s", err)
+			t.Fatalf("BlobPut returned an error: %s", err)
+		}
+
+		if n != int64(len(content)) {
+			t.Fatalf("BlobPut returned size %d, expected %d", n, len(content))
 		}
 
-		err = b.Exists()
+		r, err := b.Get(ctx, "test1")
 		if err != nil {
-			t.Fatalf("BlobExists returned an error: %s", err)
+			t.Fatalf("BlobGet returned an error: %s", err)
+		}
+		defer r.Close()
+
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Reading the blob returned an error: %s", err)
 		}
 
-		err = b.Delete()
+		if string(got) != content {
+			t.Fatalf("BlobGet returned %q, expected %q", got, content)
+		}
+
+		err = b.Delete(ctx, "test1")
 		if err != nil {
-			t.Fatalf("BlobDeleteFile returned an error: %s", err)
+			t.Fatalf("BlobDelete returned an error: %s", err)
 		}
 	})
 }
 
-func TestBlobDownloadFile(t *testing.T) {
-	b := Blob{
-		FileDetails: FileDetails{
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-			Hash:      "test2",
-			Size:      uint64(len([]byte("test2"))),
-			FileName:  "test2.txt",
-			FileContent: func() *[]byte {
-				content := []byte("test2")
-				return &content
-			}(),
-		},
+func TestBlobGet(t *testing.T) {
+	b := newAzuriteBlob(t)
+	ctx := context.Background()
+
+	_, err := b.Put(ctx, "test2", strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatalf("BlobPut returned an error: %s", err)
 	}
 
-	t.Run("test file download", func(t *testing.T) {
-		_, err := b.Upload()
+	defer func() {
+		err = b.Delete(ctx, "test2")
 		if err != nil {
-			t.Fatalf("BlobUploadFile returned an error: %s", err)
+			t.Fatalf("BlobDelete returned an error: %s", err)
 		}
+	}()
 
-		content, err := b.Download()
+	t.Run("test ranged read", func(t *testing.T) {
+		r, err := b.Get(ctx, "test2")
 		if err != nil {
-			t.Fatalf("BlobDownloadFile returned an error: %s", err)
+			t.Fatalf("BlobGet returned an error: %s", err)
 		}
+		defer r.Close()
 
-		if slices.Compare(*content, *b.FileDetails.FileContent) != 0 {
-			t.Fatalf("got different content")
+		_, err = r.Seek(6, io.SeekStart)
+		if err != nil {
+			t.Fatalf("Seek returned an error: %s", err)
 		}
 
-		err = b.Delete()
+		got, err := io.ReadAll(r)
 		if err != nil {
-			t.Fatalf("BlobDeleteFile returned an error: %s", err)
+			t.Fatalf("Reading the blob returned an error: %s", err)
+		}
+
+		if string(got) != "6789" {
+			t.Fatalf("BlobGet returned %q, expected %q", got, "6789")
+		}
+	})
+
+	t.Run("test missing blob", func(t *testing.T) {
+		_, err := b.Get(ctx, "missing")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("BlobGet returned %v, expected fs.ErrNotExist", err)
 		}
 	})
 }
 
-func TestBlobDeleteFile(t *testing.T) {
-	b := Blob{
-		FileDetails: FileDetails{
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-			Hash:      "test3",
-			Size:      uint64(len([]byte("test3"))),
-			FileName:  "test3.txt",
-			FileContent: func() *[]byte {
-				content := []byte("test3")
-				return &content
-			}(),
-		},
-	}
+func TestBlobExists(t *testing.T) {
+	b := newAzuriteBlob(t)
+	ctx := context.Background()
 
-	t.Run("test file delete", func(t *testing.T) {
-		_, err := b.Upload()
+	t.Run("test file exists", func(t *testing.T) {
+		_, err := b.Put(ctx, "test3", strings.NewReader("test3"))
 		if err != nil {
-			t.Fatalf("BlobUploadFile returned an error: %s", err)
+			t.Fatalf("BlobPut returned an error: %s", err)
 		}
 
-		err = b.Delete()
+		err = b.Exists(ctx, "test3")
 		if err != nil {
-			t.Fatalf("BlobDeleteFile returned an error: %s", err)
+			t.Fatalf("BlobExists returned an error: %s", err)
 		}
 
-		err = b.Exists()
+		err = b.Delete(ctx, "test3")
 		if err != nil {
-			t.Fatalf("BlobExists returned an error: %s", err)
+			t.Fatalf("BlobDelete returned an error: %s", err)
+		}
+
+		err = b.Exists(ctx, "test3")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("BlobExists returned %v, expected fs.ErrNotExist", err)
 		}
 	})
 }
 
-*/
+func TestBlobStringToSign(t *testing.T) {
+	b := Blob{Config: config.AzureBlobConfig{AccountName: "account", Container: "container"}}
+
+	req, err := http.NewRequest(http.MethodPut, "https://account.blob.core.windows.net/container/key?comp=block&blockid=MDA%3D", bytes.NewReader([]byte("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("x-ms-version", blobAPIVersion)
+	req.Header.Set("x-ms-date", "Sun, 18 Oct 2026 12:00:00 GMT")
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	expected := "PUT\n\n\n4\n\napplication/octet-stream\n\n\n\n\n\n\n" +
+		"x-ms-date:Sun, 18 Oct 2026 12:00:00 GMT\n" +
+		"x-ms-version:" + blobAPIVersion + "\n" +
+		"/account/container/key\nblockid:MDA=\ncomp:block"
+
+	if got := b.stringToSign(req); got != expected {
+		t.Errorf("Testing stringToSign: Wanted %q, got %q", expected, got)
+	}
+}
+
+func TestBlobStagedUpload(t *testing.T) {
+	var mu sync.Mutex
+	requests := []string{}
+	blocks := [][]byte{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		requests = append(requests, r.URL.Query().Get("comp"))
+		if r.URL.Query().Get("comp") == "block" {
+			blocks = append(blocks, body)
+		}
+		mu.Unlock()
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey account:") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	b := Blob{
+		Config: config.AzureBlobConfig{
+			AccountName: "account",
+			AccountKey:  "a2V5",
+			Container:   "container",
+			Endpoint:    server.URL,
+			BlockSize:   4,
+		},
+	}
+
+	n, err := b.Put(context.Background(), "key", strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatalf("BlobPut returned an error: %s", err)
+	}
+
+	if n != 10 {
+		t.Errorf("Testing BlobPut size: Wanted %d, got %d", 10, n)
+	}
+
+	expected := []string{"block", "block", "block", "blocklist"}
+	if strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("Testing BlobPut requests: Wanted %v, got %v", expected, requests)
+	}
+
+	if got := string(bytes.Join(blocks, nil)); got != "0123456789" {
+		t.Errorf("Testing BlobPut blocks: Wanted %q, got %q", "0123456789", got)
+	}
+}
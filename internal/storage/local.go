@@ -1,57 +1,105 @@
 package storage
 
 import (
-	"fmt"
+	"context"
+	"io"
 	"os"
+	"path/filepath"
 
 	"riley/internal/config"
 )
 
+// Local stores content as files in a directory
 type Local struct {
-	FileDetails FileDetails
+	Directory string
 }
 
-func (l *Local) Upload() (string, error) {
-	path := fmt.Sprintf("%s/%s", l.FileDetails.StorageConfig.(*config.StorageConfig).Local.Directory, l.FileDetails.Hash)
+func init() {
+	Register(STORAGE_TYPE_LOCAL, func(c config.StorageConfigInterface) (StorageInterface, error) {
+		lc, err := configSection[*config.LocalConfig](c)
+		if err != nil {
+			return nil, err
+		}
 
-	f, err := os.Create(path)
+		return &Local{Directory: lc.Directory}, nil
+	})
+}
+
+// Put writes the content to a temporary file in the directory and renames
+// it once complete, so that readers never see a partial file
+func (l *Local) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	err := validateKey(key)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	defer f.Close()
 
-	_, err = f.Write(*l.FileDetails.FileContent)
+	f, err := os.CreateTemp(l.Directory, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
 
-	return "", err
+	defer func() {
+		// Does nothing once the file was renamed
+		_ = os.Remove(f.Name())
+	}()
+
+	n, err := io.Copy(f, contextReader{ctx: ctx, r: r})
+	if err != nil {
+		f.Close()
+		return n, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return n, err
+	}
+
+	return n, os.Rename(f.Name(), l.path(key))
 }
 
-func (l *Local) Delete() error {
-	path := fmt.Sprintf("%s/%s", *&l.FileDetails.StorageConfig.(*config.StorageConfig).Local.Directory, l.FileDetails.Hash)
+func (l *Local) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
 
-	err := os.Remove(path)
+	return os.Open(l.path(key))
+}
 
-	return err
+func (l *Local) Delete(ctx context.Context, key string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(l.path(key))
 }
 
-func (l *Local) Exists() error {
-	path := fmt.Sprintf("%s/%s", l.FileDetails.StorageConfig.(*config.StorageConfig).Local.Directory, l.FileDetails.Hash)
+func (l *Local) Exists(ctx context.Context, key string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
 
-	_, err := os.Stat(path)
+	_, err = os.Stat(l.path(key))
 
 	return err
 }
 
-func (l *Local) Download() (*[]byte, error) {
-	path := fmt.Sprintf("%s/%s", l.FileDetails.StorageConfig.(*config.StorageConfig).Local.Directory, l.FileDetails.Hash)
+func (l *Local) Rename(ctx context.Context, from string, to string) error {
+	err := validateKey(from)
+	if err != nil {
+		return err
+	}
 
-	f, err := os.Open(path)
+	err = validateKey(to)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer f.Close()
 
-	content := make([]byte, l.FileDetails.Size)
-	_, err = f.Read(content)
+	return os.Rename(l.path(from), l.path(to))
+}
 
-	return &content, err
+func (l *Local) path(key string) string {
+	return filepath.Join(l.Directory, key)
 }
@@ -1,154 +1,198 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
 	"testing"
-
-	"riley/internal/config"
 )
 
-func TestLocalUploadFile(t *testing.T) {
-	l := Local{
-		FileDetails: FileDetails{
-			Hash:     "test",
-			Size:     uint64(len([]byte("test"))),
-			FileName: "test.txt",
-			FileContent: func() *[]byte {
-				content := []byte("test")
-				return &content
-			}(),
-			StorageConfig: &config.StorageConfig{
-				StorageType: STORAGE_TYPE_LOCAL,
-				Local: config.LocalConfig{
-					Directory: "/tmp",
-				},
-			},
-		},
-	}
+func TestLocalPut(t *testing.T) {
+	l := Local{Directory: t.TempDir()}
+	ctx := context.Background()
 
 	t.Run("test file upload", func(t *testing.T) {
-		_, err := l.Upload()
+		n, err := l.Put(ctx, "test", strings.NewReader("test"))
 		if err != nil {
-			t.Fatalf("LocalUploadFile returned an error: %s", err)
+			t.Fatalf("LocalPut returned an error: %s", err)
 		}
 
-		err = l.Delete()
+		if n != 4 {
+			t.Fatalf("LocalPut returned size %d, expected 4", n)
+		}
+
+		err = l.Delete(ctx, "test")
 		if err != nil {
-			t.Fatalf("LocalDeleteFile returned an error: %s", err)
+			t.Fatalf("LocalDelete returned an error: %s", err)
+		}
+	})
+
+	t.Run("test invalid key", func(t *testing.T) {
+		for _, key := range []string{"", ".", "..", "../test", "a/b"} {
+			_, err := l.Put(ctx, key, strings.NewReader("test"))
+			if !errors.Is(err, ErrInvalidKey) {
+				t.Fatalf("LocalPut with key %q returned %v, expected ErrInvalidKey", key, err)
+			}
+		}
+	})
+
+	t.Run("test cancelled upload", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := l.Put(cancelled, "cancelled", strings.NewReader("test"))
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("LocalPut returned %v, expected context.Canceled", err)
+		}
+
+		err = l.Exists(ctx, "cancelled")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("LocalExists returned %v, expected fs.ErrNotExist", err)
 		}
 	})
 }
 
 func TestLocalExists(t *testing.T) {
-	l := Local{
-		FileDetails: FileDetails{
-			Hash:     "test1",
-			Size:     uint64(len([]byte("test1"))),
-			FileName: "test1.txt",
-			FileContent: func() *[]byte {
-				content := []byte("test1")
-				return &content
-			}(),
-			StorageConfig: &config.StorageConfig{
-				StorageType: STORAGE_TYPE_LOCAL,
-				Local: config.LocalConfig{
-					Directory: "/tmp",
-				},
-			},
-		},
-	}
+	l := Local{Directory: t.TempDir()}
+	ctx := context.Background()
 
 	t.Run("test file exists", func(t *testing.T) {
-		_, err := l.Upload()
+		_, err := l.Put(ctx, "test1", strings.NewReader("test1"))
 		if err != nil {
-			t.Fatalf("LocalUploadFile returned an error: %s", err)
+			t.Fatalf("LocalPut returned an error: %s", err)
 		}
 
-		err = l.Exists()
+		err = l.Exists(ctx, "test1")
 		if err != nil {
 			t.Fatalf("LocalExists returned an error: %s", err)
 		}
 
-		err = l.Delete()
+		err = l.Delete(ctx, "test1")
 		if err != nil {
-			t.Fatalf("LocalDeleteFile returned an error: %s", err)
+			t.Fatalf("LocalDelete returned an error: %s", err)
 		}
 	})
 }
 
-func TestLocalDownload(t *testing.T) {
-	l := Local{
-		FileDetails: FileDetails{
-			Hash:     "test2",
-			Size:     uint64(len([]byte("test2"))),
-			FileName: "test2.txt",
-			FileContent: func() *[]byte {
-				content := []byte("test2")
-				return &content
-			}(),
-			StorageConfig: &config.StorageConfig{
-				StorageType: STORAGE_TYPE_LOCAL,
-				Local: config.LocalConfig{
-					Directory: "/tmp",
-        },
-      },
-		},
-	}
+func TestLocalGet(t *testing.T) {
+	l := Local{Directory: t.TempDir()}
+	ctx := context.Background()
+
+	// Larger than a single read, which used to be all Download returned
+	content := bytes.Repeat([]byte("test2"), 1<<16)
 
 	t.Run("test file download", func(t *testing.T) {
-		_, err := l.Upload()
+		_, err := l.Put(ctx, "test2", bytes.NewReader(content))
 		if err != nil {
-			t.Fatalf("LocalUploadFile returned an error: %s", err)
+			t.Fatalf("LocalPut returned an error: %s", err)
 		}
 
-		content, err := l.Download()
+		r, err := l.Get(ctx, "test2")
 		if err != nil {
-			t.Fatalf("LocalDownload returned an error: %s", err)
+			t.Fatalf("LocalGet returned an error: %s", err)
 		}
+		defer r.Close()
 
-		if string(*content) != "test2" {
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("reading returned an error: %s", err)
+		}
+
+		if !bytes.Equal(got, content) {
 			t.Fatalf("got different content")
 		}
 
-		err = l.Delete()
+		_, err = r.Seek(5, io.SeekStart)
+		if err != nil {
+			t.Fatalf("Seek returned an error: %s", err)
+		}
+
+		part := make([]byte, 5)
+
+		_, err = io.ReadFull(r, part)
+		if err != nil || string(part) != "test2" {
+			t.Fatalf("got %q after seeking, expected test2 (%v)", part, err)
+		}
+
+		err = l.Delete(ctx, "test2")
 		if err != nil {
-			t.Fatalf("LocalDeleteFile returned an error: %s", err)
+			t.Fatalf("LocalDelete returned an error: %s", err)
 		}
 	})
 }
 
-func TestLocalDeleteFile(t *testing.T) {
-	l := Local{
-		FileDetails: FileDetails{
-			Hash:     "test3",
-			Size:     uint64(len([]byte("test3"))),
-			FileName: "test3.txt",
-			FileContent: func() *[]byte {
-				content := []byte("test3")
-				return &content
-			}(),
-			StorageConfig: &config.StorageConfig{
-				StorageType: STORAGE_TYPE_LOCAL,
-				Local: config.LocalConfig{
-					Directory: "/tmp",
-				},
-			},
-		},
-	}
+func TestLocalDelete(t *testing.T) {
+	l := Local{Directory: t.TempDir()}
+	ctx := context.Background()
 
 	t.Run("test file delete", func(t *testing.T) {
-		_, err := l.Upload()
+		_, err := l.Put(ctx, "test3", strings.NewReader("test3"))
 		if err != nil {
-			t.Fatalf("LocalUploadFile returned an error: %s", err)
+			t.Fatalf("LocalPut returned an error: %s", err)
 		}
 
-		err = l.Delete()
+		err = l.Delete(ctx, "test3")
 		if err != nil {
-			t.Fatalf("LocalDeleteFile returned an error: %s", err)
+			t.Fatalf("LocalDelete returned an error: %s", err)
+		}
+
+		err = l.Exists(ctx, "test3")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("LocalExists returned %v, expected fs.ErrNotExist", err)
 		}
 
-		err = l.Exists()
-		if err == nil {
-			t.Fatalf("LocalExists returned nil, expected error")
+		_, err = l.Get(ctx, "test3")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("LocalGet returned %v, expected fs.ErrNotExist", err)
 		}
 	})
 }
+
+// getPutStore hides the Rename method of the backend it wraps
+type getPutStore struct {
+	StorageInterface
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range map[string]StorageInterface{
+		"rename":   &Local{Directory: t.TempDir()},
+		"get, put": getPutStore{&Local{Directory: t.TempDir()}},
+	} {
+		t.Run("test move with "+name, func(t *testing.T) {
+			_, err := store.Put(ctx, "from", strings.NewReader("test"))
+			if err != nil {
+				t.Fatalf("LocalPut returned an error: %s", err)
+			}
+
+			err = Move(ctx, store, "from", "to")
+			if err != nil {
+				t.Fatalf("Move returned an error: %s", err)
+			}
+
+			err = store.Exists(ctx, "from")
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("LocalExists for the moved key returned %v, expected fs.ErrNotExist", err)
+			}
+
+			r, err := store.Get(ctx, "to")
+			if err != nil {
+				t.Fatalf("LocalGet returned an error: %s", err)
+			}
+			defer r.Close()
+
+			content, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(content) != "test" {
+				t.Fatalf("LocalGet returned %q, expected %q", content, "test")
+			}
+		})
+	}
+}
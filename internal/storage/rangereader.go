@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// RangeFunc opens length bytes of stored content, starting at offset
+type RangeFunc func(ctx context.Context, offset int64, length int64) (io.ReadCloser, error)
+
+// RangeReader reads content of a known size through a RangeFunc, for
+// backends that serve ranges but cannot seek a single stream
+//
+// A range is opened on the first Read after a Seek and read up to the
+// end, so reading sequentially makes a single request
+type RangeReader struct {
+	ctx    context.Context
+	open   RangeFunc
+	body   io.ReadCloser
+	size   int64
+	offset int64
+}
+
+// NewRangeReader returns a reader over the size bytes served by open
+func NewRangeReader(ctx context.Context, size int64, open RangeFunc) *RangeReader {
+	return &RangeReader{
+		ctx:  ctx,
+		open: open,
+		size: size,
+	}
+}
+
+func (rr *RangeReader) Read(p []byte) (int, error) {
+	if rr.offset >= rr.size {
+		return 0, io.EOF
+	}
+
+	if rr.body == nil {
+		body, err := rr.open(rr.ctx, rr.offset, rr.size-rr.offset)
+		if err != nil {
+			return 0, err
+		}
+
+		rr.body = body
+	}
+
+	n, err := rr.body.Read(p)
+	rr.offset += int64(n)
+
+	if errors.Is(err, io.EOF) && rr.offset < rr.size {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func (rr *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rr.offset
+	case io.SeekEnd:
+		offset += rr.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != rr.offset && rr.body != nil {
+		err := rr.body.Close()
+		rr.body = nil
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	rr.offset = offset
+
+	return offset, nil
+}
+
+func (rr *RangeReader) Close() error {
+	if rr.body == nil {
+		return nil
+	}
+
+	err := rr.body.Close()
+	rr.body = nil
+
+	return err
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestRangeReader(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+
+	var requests [][2]int64
+
+	open := func(ctx context.Context, offset int64, length int64) (io.ReadCloser, error) {
+		requests = append(requests, [2]int64{offset, length})
+
+		return io.NopCloser(bytes.NewReader(content[offset : offset+length])), nil
+	}
+
+	r := NewRangeReader(context.Background(), int64(len(content)), open)
+	defer r.Close()
+
+	t.Run("seek to end", func(t *testing.T) {
+		size, err := r.Seek(0, io.SeekEnd)
+		if err != nil || size != int64(len(content)) {
+			t.Fatalf("Seek returned %d, %v, expected %d", size, err, len(content))
+		}
+
+		if len(requests) != 0 {
+			t.Fatalf("Seek opened a range: %v", requests)
+		}
+	})
+
+	t.Run("read range", func(t *testing.T) {
+		_, err := r.Seek(10, io.SeekStart)
+		if err != nil {
+			t.Fatalf("Seek returned an error: %s", err)
+		}
+
+		got, err := io.ReadAll(io.LimitReader(r, 5))
+		if err != nil || string(got) != "abcde" {
+			t.Fatalf("got %q, %v, expected abcde", got, err)
+		}
+
+		rest, err := io.ReadAll(r)
+		if err != nil || string(rest) != "fghij" {
+			t.Fatalf("got %q, %v, expected fghij", rest, err)
+		}
+
+		if len(requests) != 1 || requests[0] != [2]int64{10, 10} {
+			t.Fatalf("wrong ranges opened: %v", requests)
+		}
+	})
+
+	t.Run("read from start", func(t *testing.T) {
+		_, err := r.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatalf("Seek returned an error: %s", err)
+		}
+
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, content) {
+			t.Fatalf("got %q, %v, expected %q", got, err, content)
+		}
+	})
+
+	t.Run("short body", func(t *testing.T) {
+		short := NewRangeReader(context.Background(), 10, func(ctx context.Context, offset int64, length int64) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(content[:5])), nil
+		})
+
+		_, err := io.ReadAll(short)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("got %v, expected io.ErrUnexpectedEOF", err)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"riley/internal/config"
+)
+
+// Constructor builds a backend from the config of its storage type
+type Constructor func(c config.StorageConfigInterface) (StorageInterface, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Constructor{}
+)
+
+// Register makes a backend available to New under the storage type
+//
+// It is meant to be called from the init function of the package
+// implementing the backend, and panics if the storage type is already
+// registered
+func Register(storageType string, constructor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[storageType]; ok {
+		panic(fmt.Sprintf("storage: type %q registered twice", storageType))
+	}
+
+	registry[storageType] = constructor
+}
+
+// Types returns the registered storage types, sorted
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	types := []string{}
+	for storageType := range registry {
+		types = append(types, storageType)
+	}
+	slices.Sort(types)
+
+	return types
+}
+
+// New builds the backend registered for the storage type of the config
+//
+// For a *config.StorageConfig the constructor gets the config of the
+// selected storage type, such as its Local or S3 field; any other config
+// is passed as is. The backend is meant to be built once at startup and
+// shared
+func New(c config.StorageConfigInterface) (StorageInterface, error) {
+	if sc, ok := c.(*config.StorageConfig); ok {
+		if section := sc.Section(); section != nil {
+			c = section
+		}
+	}
+
+	registryMu.RLock()
+	constructor, ok := registry[c.GetStorageType()]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("storage: unknown type %q", c.GetStorageType())
+	}
+
+	return constructor(c)
+}
+
+// configSection returns the config c as T, the config type a backend is
+// registered with
+func configSection[T config.StorageConfigInterface](c config.StorageConfigInterface) (T, error) {
+	section, ok := c.(T)
+	if !ok {
+		return section, fmt.Errorf("storage: %s storage needs a %T config, got %T", c.GetStorageType(), section, c)
+	}
+
+	return section, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"slices"
+	"testing"
+
+	"riley/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		config   config.StorageConfigInterface
+		expected StorageInterface
+	}{
+		{&config.StorageConfig{StorageType: config.STORAGE_TYPE_LOCAL, Local: config.LocalConfig{Directory: "/tmp"}}, &Local{Directory: "/tmp"}},
+		{&config.StorageConfig{StorageType: config.STORAGE_TYPE_AZURE_BLOB, AzureBlob: config.AzureBlobConfig{Container: "container"}}, &Blob{}},
+		{&config.StorageConfig{StorageType: config.STORAGE_TYPE_S3, S3: config.S3Config{Bucket: "bucket"}}, &S3{}},
+		{&config.LocalConfig{Directory: "/tmp"}, &Local{}},
+	}
+
+	for _, test := range tests {
+		store, err := New(test.config)
+		if err != nil {
+			t.Fatalf("Testing New for %s: Wanted no error, got %s", test.config.GetStorageType(), err)
+		}
+
+		switch test.expected.(type) {
+		case *Local:
+			if local, ok := store.(*Local); !ok || local.Directory != "/tmp" {
+				t.Errorf("Testing New for %s: Wanted a Local in /tmp, got %#v", test.config.GetStorageType(), store)
+			}
+		case *Blob:
+			if blob, ok := store.(*Blob); !ok || blob.Config.Container != "container" {
+				t.Errorf("Testing New for %s: Wanted a Blob for container, got %#v", test.config.GetStorageType(), store)
+			}
+		case *S3:
+			if s3, ok := store.(*S3); !ok || s3.Config.Bucket != "bucket" {
+				t.Errorf("Testing New for %s: Wanted an S3 for bucket, got %#v", test.config.GetStorageType(), store)
+			}
+		}
+	}
+
+	_, err := New(&config.StorageConfig{StorageType: "unknown"})
+	if err == nil {
+		t.Errorf("Testing New for an unknown type: Wanted an error, got nil")
+	}
+}
+
+// memoryConfig is the config of a backend registered outside of the
+// built-in storage types
+type memoryConfig struct{}
+
+func (mc *memoryConfig) LoadConfig() error {
+	return nil
+}
+
+func (mc *memoryConfig) GetStorageType() string {
+	return "memory"
+}
+
+type memory struct {
+	StorageInterface
+}
+
+func (m *memory) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	return io.Copy(io.Discard, r)
+}
+
+func TestRegister(t *testing.T) {
+	Register("memory", func(c config.StorageConfigInterface) (StorageInterface, error) {
+		_, err := configSection[*memoryConfig](c)
+		if err != nil {
+			return nil, err
+		}
+
+		return &memory{}, nil
+	})
+
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "memory")
+		registryMu.Unlock()
+	}()
+
+	if !slices.Contains(Types(), "memory") {
+		t.Errorf("Testing Types: Wanted memory in %v", Types())
+	}
+
+	store, err := New(&memoryConfig{})
+	if err != nil {
+		t.Fatalf("Testing New for a registered type: Wanted no error, got %s", err)
+	}
+
+	if _, ok := store.(*memory); !ok {
+		t.Errorf("Testing New for a registered type: Wanted a memory backend, got %#v", store)
+	}
+
+	t.Run("test duplicate registration", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Testing Register twice: Wanted a panic, got none")
+			}
+		}()
+
+		Register("memory", nil)
+	})
+}
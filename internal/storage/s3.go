@@ -0,0 +1,594 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"riley/internal/config"
+)
+
+const (
+	// defaultPartSize is used when the config does not set a part size
+	defaultPartSize = 8 << 20
+
+	// minPartSize is the smallest part S3 accepts, other than the last
+	minPartSize = 5 << 20
+
+	// s3DateFormat is the format of X-Amz-Date
+	s3DateFormat = "20060102T150405Z"
+
+	// unsignedPayload is the payload hash of presigned requests
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3 stores content as objects in a bucket of an S3-compatible service,
+// signing requests with AWS Signature Version 4
+type S3 struct {
+	Config config.S3Config
+	// Client sends the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+func init() {
+	Register(STORAGE_TYPE_S3, func(c config.StorageConfigInterface) (StorageInterface, error) {
+		s3c, err := configSection[*config.S3Config](c)
+		if err != nil {
+			return nil, err
+		}
+
+		return &S3{Config: *s3c}, nil
+	})
+}
+
+// Put uploads the content in parts of Config.PartSize, so that memory use
+// does not depend on the size of the file
+//
+// Content that fits in a single part is uploaded with one PutObject
+// request; larger content with a multipart upload, which is aborted if a
+// part fails so that the parts already uploaded are not kept
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	err := validateKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, s.partSize())
+	r = contextReader{ctx: ctx, r: r}
+
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return int64(n), s.putObject(ctx, key, buf[:n])
+	} else if err != nil {
+		return 0, err
+	}
+
+	uploadID, err := s.createMultipartUpload(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := s.uploadParts(ctx, key, uploadID, r, buf)
+	if err != nil {
+		abortErr := s.abortMultipartUpload(context.WithoutCancel(ctx), key, uploadID)
+
+		return size, errors.Join(err, abortErr)
+	}
+
+	return size, nil
+}
+
+// Get returns a reader that downloads the object in ranges, starting from
+// where it was last seeked to
+//
+// The ranges are requested with the ETag of the object when it was opened,
+// so that an object replaced while it is read fails instead of mixing
+// content
+func (s *S3) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	etag := res.Header.Get("ETag")
+
+	return NewRangeReader(ctx, res.ContentLength, func(ctx context.Context, offset int64, length int64) (io.ReadCloser, error) {
+		header := http.Header{}
+		if etag != "" {
+			header.Set("If-Match", etag)
+		}
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+		res, err := s.do(ctx, http.MethodGet, key, nil, header, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return res.Body, nil
+	}), nil
+}
+
+// Delete deletes the object
+//
+// S3 does not tell whether a deleted object existed, so it is checked
+// first to return fs.ErrNotExist like the other backends
+func (s *S3) Delete(ctx context.Context, key string) error {
+	err := s.Exists(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func (s *S3) Exists(ctx context.Context, key string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Rename copies the object with CopyObject, which copies within the
+// service, and deletes the original
+func (s *S3) Rename(ctx context.Context, from string, to string) error {
+	err := validateKey(from)
+	if err != nil {
+		return err
+	}
+
+	err = validateKey(to)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", uriEncode("/"+s.Config.Bucket+"/"+from, false))
+
+	res, err := s.do(ctx, http.MethodPut, to, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Like CompleteMultipartUpload, CopyObject can fail after the 200
+	// status was sent
+	err = s3ResultError(res.Body)
+	if err != nil {
+		return fmt.Errorf("s3: copy object: %w", err)
+	}
+
+	res, err = s.do(ctx, http.MethodDelete, from, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// PresignGet returns a URL that downloads the object as filename without
+// credentials, valid for Config.PresignExpiry
+//
+// Returns ErrPresignDisabled if Config.PresignExpiry is not set
+func (s *S3) PresignGet(ctx context.Context, key string, filename string) (string, error) {
+	err := validateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	if s.Config.PresignExpiry <= 0 {
+		return "", ErrPresignDisabled
+	}
+
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filename,
+	}))
+
+	return s.presign(http.MethodGet, u, query, s.Config.PresignExpiry, time.Now().UTC()), nil
+}
+
+func (s *S3) putObject(ctx context.Context, key string, content []byte) error {
+	res, err := s.do(ctx, http.MethodPut, key, nil, nil, content)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func (s *S3) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	query := url.Values{}
+	query.Set("uploads", "")
+
+	res, err := s.do(ctx, http.MethodPost, key, query, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	result := struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+
+	err = xml.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return "", fmt.Errorf("s3: create multipart upload: %w", err)
+	}
+
+	if result.UploadID == "" {
+		return "", errors.New("s3: create multipart upload: no upload ID")
+	}
+
+	return result.UploadID, nil
+}
+
+// completedPart is a part of a multipart upload in the
+// CompleteMultipartUpload request
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadParts uploads buf, which holds the first part, and the rest of r
+// as parts of the multipart upload, then completes it
+func (s *S3) uploadParts(ctx context.Context, key string, uploadID string, r io.Reader, buf []byte) (int64, error) {
+	size := int64(0)
+	parts := []completedPart{}
+	n := len(buf)
+
+	for n > 0 {
+		query := url.Values{}
+		query.Set("partNumber", fmt.Sprintf("%d", len(parts)+1))
+		query.Set("uploadId", uploadID)
+
+		res, err := s.do(ctx, http.MethodPut, key, query, nil, buf[:n])
+		if err != nil {
+			return size, err
+		}
+		res.Body.Close()
+
+		size += int64(n)
+		parts = append(parts, completedPart{PartNumber: len(parts) + 1, ETag: res.Header.Get("ETag")})
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return size, err
+		}
+	}
+
+	content, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{
+		Parts: parts,
+	})
+	if err != nil {
+		return size, err
+	}
+
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+
+	res, err := s.do(ctx, http.MethodPost, key, query, nil, content)
+	if err != nil {
+		return size, err
+	}
+	defer res.Body.Close()
+
+	// CompleteMultipartUpload can fail after the 200 status was sent, in
+	// which case the body is an Error instead of the result
+	err = s3ResultError(res.Body)
+	if err != nil {
+		return size, fmt.Errorf("s3: complete multipart upload: %w", err)
+	}
+
+	return size, nil
+}
+
+// s3ResultError reads the XML result of a successful response, returning
+// an error if it is an Error instead
+func s3ResultError(body io.Reader) error {
+	result := struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+	}{}
+
+	err := xml.NewDecoder(body).Decode(&result)
+	if err != nil {
+		return err
+	}
+
+	if result.XMLName.Local == "Error" {
+		return errors.New(result.Code)
+	}
+
+	return nil
+}
+
+func (s *S3) abortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+
+	res, err := s.do(ctx, http.MethodDelete, key, query, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// do sends a signed request for the object and returns the response if it
+// succeeded
+//
+// A missing object returns an error matching fs.ErrNotExist
+func (s *S3) do(ctx context.Context, method string, key string, query url.Values, header http.Header, content []byte) (*http.Response, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	u.RawQuery = encodeQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := sha256.Sum256(content)
+	s.sign(req, hex.EncodeToString(payloadHash[:]), time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+
+	// HEAD responses have no body to read the error code from
+	result := struct {
+		Code string `xml:"Code"`
+	}{}
+	_ = xml.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&result)
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("s3: %s %s: %w", method, result.Code, fs.ErrNotExist)
+	}
+
+	return nil, fmt.Errorf("s3: %s: status %d %s", method, res.StatusCode, result.Code)
+}
+
+// objectURL returns the URL of the object, with the bucket in the path or
+// in the host name depending on Config.PathStyle
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	endpoint := s.Config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.Config.Region + ".amazonaws.com"
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Config.PathStyle {
+		u.Path += "/" + s.Config.Bucket + "/" + key
+	} else {
+		u.Host = s.Config.Bucket + "." + u.Host
+		u.Path += "/" + key
+	}
+
+	u.RawPath = uriEncode(u.Path, false)
+
+	return u, nil
+}
+
+// sign sets the Signature Version 4 Authorization header of the request,
+// for the payload with the hex SHA-256 payloadHash
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	req.Header.Set("X-Amz-Date", now.Format(s3DateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" || name == "if-match" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		encodeQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := s.scope(now)
+	signature := s.signature(now, canonicalRequest)
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.Config.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// presign returns the URL u signed in its query string, valid for expires
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func (s *S3) presign(method string, u *url.URL, query url.Values, expires time.Duration, now time.Time) string {
+	presigned := *u
+
+	if query == nil {
+		query = url.Values{}
+	}
+
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.Config.AccessKeyID+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format(s3DateFormat))
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		presigned.EscapedPath(),
+		encodeQuery(query),
+		"host:" + presigned.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	query.Set("X-Amz-Signature", s.signature(now, canonicalRequest))
+	presigned.RawQuery = encodeQuery(query)
+
+	return presigned.String()
+}
+
+// scope returns the credential scope of requests signed at now
+func (s *S3) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.Config.Region + "/s3/aws4_request"
+}
+
+// signature returns the hex signature of the canonical request
+func (s *S3) signature(now time.Time, canonicalRequest string) string {
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format(s3DateFormat),
+		s.scope(now),
+		hex.EncodeToString(hashedRequest[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.Config.SecretAccessKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.Config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func (s *S3) partSize() int64 {
+	if s.Config.PartSize >= minPartSize {
+		return s.Config.PartSize
+	}
+
+	return defaultPartSize
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// encodeQuery encodes the query sorted by name, with names and values
+// encoded as Signature Version 4 expects
+func encodeQuery(query url.Values) string {
+	names := []string{}
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a string, b string) int {
+		return strings.Compare(uriEncode(a, true), uriEncode(b, true))
+	})
+
+	params := []string{}
+	for _, name := range names {
+		values := slices.Clone(query[name])
+		slices.Sort(values)
+
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes every byte other than the unreserved
+// characters of RFC 3986, and slashes unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
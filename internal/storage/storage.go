@@ -1,78 +1,107 @@
 package storage
 
 import (
+	"context"
 	"errors"
-	"time"
+	"io"
+	"strings"
 
 	"riley/internal/config"
 )
 
+// ErrInvalidKey is returned for keys that are empty or could escape the
+// storage location, such as ones with a path separator
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// ErrPresignDisabled is returned by Presigner backends that are not set up
+// to hand out presigned URLs
+var ErrPresignDisabled = errors.New("presigned URLs are disabled")
+
+// StorageInterface stores file content under a key
+//
+// Content is streamed in and out, so memory use does not depend on the
+// size of the file. Get, Delete and Exists return an error matching
+// fs.ErrNotExist if nothing is stored under the key
 type StorageInterface interface {
-	Upload() (string, error)
-	Delete() error
-	Exists() error
-	Download() (*[]byte, error)
+	// Put stores the content read from r under the key, replacing any
+	// content already stored there, and returns its size
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get opens the content stored under the key
+	//
+	// The reader supports Seek, so that ranges can be read without
+	// reading what comes before them
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) error
 }
 
-type Storage struct {
-	FileDetails FileDetails
+// Presigner is implemented by backends that can hand out URLs to download
+// content from them directly, without streaming it through riley
+type Presigner interface {
+	// PresignGet returns a URL that downloads the content stored under the
+	// key as filename
+	PresignGet(ctx context.Context, key string, filename string) (string, error)
 }
 
-const (
-	STORAGE_TYPE_LOCAL = "local"
-	STORAGE_TYPE_BLOB  = "blob"
-)
-
-type FileDetails struct {
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	StorageConfig config.StorageConfigInterface
-	StorageType   string
-	FileContent   *[]byte
-	Hash          string
-	FileName      string
-	Storage       string
-	Size          uint64
+// Renamer is implemented by backends that can move content to another key
+// without streaming it through riley
+type Renamer interface {
+	// Rename moves the content stored under from to the key to, replacing
+	// any content already stored there
+	Rename(ctx context.Context, from string, to string) error
 }
 
-func (s *Storage) Upload() (string, error) {
-	switch s.FileDetails.StorageType {
-	case STORAGE_TYPE_LOCAL:
-		l := Local{
-			FileDetails: s.FileDetails,
-		}
-		return l.Upload()
-	case STORAGE_TYPE_BLOB:
-		b := Blob{
-			FileDetails: s.FileDetails,
-		}
-		return b.Upload()
+// Move moves the content stored under from to the key to, replacing any
+// content already stored there
+//
+// Backends that are not a Renamer are copied with Get and Put, and the
+// content under from deleted once the copy is complete
+func Move(ctx context.Context, store StorageInterface, from string, to string) error {
+	if renamer, ok := store.(Renamer); ok {
+		return renamer.Rename(ctx, from, to)
+	}
+
+	r, err := store.Get(ctx, from)
+	if err != nil {
+		return err
 	}
+	defer r.Close()
 
-	return "", errors.New("storage type not implemented")
+	_, err = store.Put(ctx, to, r)
+	if err != nil {
+		return err
+	}
+
+	return store.Delete(ctx, from)
 }
 
-func (s *Storage) Delete() error {
-	switch s.FileDetails.StorageType {
-	case STORAGE_TYPE_LOCAL:
-		l := Local{
-			FileDetails: s.FileDetails,
-		}
-		return l.Delete()
-	case STORAGE_TYPE_BLOB:
-		b := Blob{
-			FileDetails: s.FileDetails,
-		}
-		return b.Delete()
+// The storage types match the ones of config.StorageConfig
+const (
+	STORAGE_TYPE_LOCAL = config.STORAGE_TYPE_LOCAL
+	STORAGE_TYPE_BLOB  = config.STORAGE_TYPE_AZURE_BLOB
+	STORAGE_TYPE_S3    = config.STORAGE_TYPE_S3
+)
+
+// validateKey checks that a key can be used as a file or object name
+func validateKey(key string) error {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return ErrInvalidKey
 	}
 
 	return nil
 }
 
-func (s *Storage) Exists() error {
-	return nil
+// contextReader stops reading once the context is done, so that a
+// cancelled request does not keep streaming into storage
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
 }
 
-func (s *Storage) Download() ([]byte, error) {
-	return nil, nil
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cr.r.Read(p)
 }